@@ -0,0 +1,178 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authenticator adds the credentials of an authentication scheme to an outgoing request.
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface.
+type AuthenticatorFunc func(req *http.Request) error
+
+// Authenticate calls f(req).
+func (f AuthenticatorFunc) Authenticate(req *http.Request) error {
+	return f(req)
+}
+
+// NoAuth returns an Authenticator that leaves the request untouched.
+func NoAuth() Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		return nil
+	})
+}
+
+// BearerToken returns an Authenticator that sets a static bearer token in the Authorization header.
+func BearerToken(token string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// BasicAuth returns an Authenticator that sets the user and password in the Authorization header.
+func BasicAuth(username, password string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// APIKeyHeader returns an Authenticator that sets the API key in the given header.
+func APIKeyHeader(header, key string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.Header.Set(header, key)
+		return nil
+	})
+}
+
+// APIKeyQuery returns an Authenticator that sets the API key in the given query parameter.
+// The transport of the Client masks the parameter when it records the requests, eg: httptest.Recorder.
+func APIKeyQuery(param, key string) Authenticator {
+	return apiKeyQuery{param: param, key: key}
+}
+
+// apiKeyQuery is the Authenticator of APIKeyQuery.
+type apiKeyQuery struct {
+	param string
+	key   string
+}
+
+// Authenticate sets the API key in the query parameter.
+func (a apiKeyQuery) Authenticate(req *http.Request) error {
+	query := req.URL.Query()
+	query.Set(a.param, a.key)
+	req.URL.RawQuery = query.Encode()
+	return nil
+}
+
+// queryRedactor is implemented by the transports that record the requests, eg: httptest.Recorder, to
+// mask the query parameters that carry credentials.
+type queryRedactor interface {
+	RedactQuery(params ...string)
+}
+
+// OAuth2Config contains the configuration of the OAuth2 client credentials grant.
+type OAuth2Config struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// ExpiryDelta is how long before its expiration the token is refreshed. It defaults to 30 seconds.
+	ExpiryDelta time.Duration
+	// Client is the HTTP client used to call the token endpoint. It defaults to a client with a 30 seconds timeout.
+	Client *http.Client
+}
+
+// OAuth2ClientCredentials is an Authenticator that gets a bearer token with the OAuth2 client
+// credentials grant. The token is cached and refreshed before it expires.
+type OAuth2ClientCredentials struct {
+	config OAuth2Config
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// NewOAuth2ClientCredentials creates a new OAuth2 client credentials Authenticator.
+func NewOAuth2ClientCredentials(config OAuth2Config) *OAuth2ClientCredentials {
+	if config.ExpiryDelta == 0 {
+		config.ExpiryDelta = 30 * time.Second
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &OAuth2ClientCredentials{config: config}
+}
+
+// Authenticate sets the cached bearer token, fetching a new one when it's missing or about to expire.
+func (o *OAuth2ClientCredentials) Authenticate(req *http.Request) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.token == "" || time.Now().Add(o.config.ExpiryDelta).After(o.expiry) {
+		if err := o.refresh(req); err != nil {
+			return err
+		}
+	}
+	req.Header.Set("Authorization", "Bearer "+o.token)
+	return nil
+}
+
+// refresh requests a new token to the token endpoint. It must be called holding the lock.
+func (o *OAuth2ClientCredentials) refresh(req *http.Request) error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(o.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.config.Scopes, " "))
+	}
+	tokenReq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, o.config.TokenURL,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create token request: %w", err)
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenReq.Header.Set("Accept", "application/json")
+	tokenReq.SetBasicAuth(url.QueryEscape(o.config.ClientID), url.QueryEscape(o.config.ClientSecret))
+
+	resp, err := o.config.Client.Do(tokenReq)
+	if err != nil {
+		return fmt.Errorf("failed to request token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token endpoint status code %d body %s", resp.StatusCode, string(body))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return fmt.Errorf("failed to unmarshal token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("token response without access_token")
+	}
+	o.token = token.AccessToken
+	if token.ExpiresIn > 0 {
+		o.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	} else {
+		// a token without expiration is kept for a long time
+		o.expiry = time.Now().Add(24 * time.Hour)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticators(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   Authenticator
+		assert func(t *testing.T, r *http.Request)
+	}{
+		{
+			name: "no auth",
+			auth: NoAuth(),
+			assert: func(t *testing.T, r *http.Request) {
+				assert.Empty(t, r.Header.Get("Authorization"))
+			},
+		},
+		{
+			name: "bearer token",
+			auth: BearerToken("a-token"),
+			assert: func(t *testing.T, r *http.Request) {
+				assert.Equal(t, "Bearer a-token", r.Header.Get("Authorization"))
+			},
+		},
+		{
+			name: "basic auth",
+			auth: BasicAuth("user", "password"),
+			assert: func(t *testing.T, r *http.Request) {
+				username, password, ok := r.BasicAuth()
+				assert.True(t, ok)
+				assert.Equal(t, "user", username)
+				assert.Equal(t, "password", password)
+			},
+		},
+		{
+			name: "api key header",
+			auth: APIKeyHeader("X-Api-Key", "a-key"),
+			assert: func(t *testing.T, r *http.Request) {
+				assert.Equal(t, "a-key", r.Header.Get("X-Api-Key"))
+				assert.Empty(t, r.Header.Get("Authorization"))
+			},
+		},
+		{
+			name: "api key query",
+			auth: APIKeyQuery("api_key", "a-key"),
+			assert: func(t *testing.T, r *http.Request) {
+				assert.Equal(t, "a-key", r.URL.Query().Get("api_key"))
+				assert.Equal(t, "value", r.URL.Query().Get("key"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.assert(t, r)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			client := NewClient(server.Client(), WithAuthenticator(tt.auth))
+			_, err := client.Get(context.Background(), Payload{URL: server.URL, Query: map[string]string{"key": "value"}})
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestClient_EmptyTokenSendsNoAuthorization(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := r.Header["Authorization"]
+		assert.False(t, ok)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.Client())
+	_, err := client.Get(context.Background(), Payload{URL: server.URL})
+	assert.NoError(t, err)
+}
+
+func TestClient_PayloadTokenTakesPrecedence(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer payload-token", r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.Client(), WithAuthenticator(BasicAuth("user", "password")))
+	_, err := client.Get(context.Background(), Payload{URL: server.URL, Token: "payload-token"})
+	assert.NoError(t, err)
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	var tokenRequests atomic.Int32
+	expiresIn := 3600
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests.Add(1)
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "read write", r.PostForm.Get("scope"))
+		clientID, clientSecret, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "a-client", clientID)
+		assert.Equal(t, "a-secret", clientSecret)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "token-" + string(rune('0'+tokenRequests.Load())),
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+		})
+	}))
+	defer tokenServer.Close()
+
+	var lastAuthorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastAuthorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	auth := NewOAuth2ClientCredentials(OAuth2Config{
+		TokenURL:     tokenServer.URL,
+		ClientID:     "a-client",
+		ClientSecret: "a-secret",
+		Scopes:       []string{"read", "write"},
+		ExpiryDelta:  time.Minute,
+	})
+	client := NewClient(server.Client(), WithAuthenticator(auth))
+
+	t.Run("token is cached", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, err := client.Get(context.Background(), Payload{URL: server.URL})
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, int32(1), tokenRequests.Load())
+		assert.Equal(t, "Bearer token-1", lastAuthorization)
+	})
+
+	t.Run("token is refreshed before it expires", func(t *testing.T) {
+		auth.mu.Lock()
+		auth.expiry = time.Now().Add(30 * time.Second)
+		auth.mu.Unlock()
+
+		_, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), tokenRequests.Load())
+		assert.Equal(t, "Bearer token-2", lastAuthorization)
+	})
+
+	t.Run("token endpoint error", func(t *testing.T) {
+		failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+		}))
+		defer failingServer.Close()
+
+		failingAuth := NewOAuth2ClientCredentials(OAuth2Config{TokenURL: failingServer.URL})
+		client := NewClient(server.Client(), WithAuthenticator(failingAuth))
+		resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to authenticate request")
+		assert.Contains(t, err.Error(), "invalid_client")
+		assert.Nil(t, resp)
+	})
+}
@@ -0,0 +1,236 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when a request is rejected because the circuit of its host is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of the circuit breaker of a host.
+type CircuitState int
+
+const (
+	// StateClosed lets every request through.
+	StateClosed CircuitState = iota
+	// StateOpen rejects every request until the cooldown ends.
+	StateOpen
+	// StateHalfOpen lets a limited number of trial requests through.
+	StateHalfOpen
+)
+
+// String returns the name of the state.
+func (s CircuitState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerConfig defines when the circuit of a host opens and how it recovers.
+// The fields that aren't set take the values of DefaultCircuitBreakerConfig.
+type CircuitBreakerConfig struct {
+	// FailureRateThreshold is the failure rate (0 to 1) that opens the circuit.
+	FailureRateThreshold float64
+	// MinimumRequests is the number of requests in the window needed before the failure rate is evaluated.
+	MinimumRequests int
+	// Window is the period over which failures are counted while the circuit is closed.
+	Window time.Duration
+	// Cooldown is how long the circuit stays open before letting trial requests through.
+	Cooldown time.Duration
+	// HalfOpenRequests is the number of successful trial requests needed to close the circuit.
+	HalfOpenRequests int
+	// IsFailure classifies the result of a request. By default transport errors and 5xx responses are failures.
+	// Requests whose context is cancelled or expires aren't classified, since they say nothing about the host.
+	IsFailure func(resp *http.Response, err error) bool
+	// OnStateChange is called every time the circuit of a host changes its state.
+	OnStateChange func(host string, from, to CircuitState)
+}
+
+// DefaultCircuitBreakerConfig returns a config that opens the circuit when half of at least
+// 10 requests in 1 minute fail, and tries again after 30 seconds.
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		FailureRateThreshold: 0.5,
+		MinimumRequests:      10,
+		Window:               time.Minute,
+		Cooldown:             30 * time.Second,
+		HalfOpenRequests:     1,
+	}
+}
+
+// circuitBreakers keeps a circuit breaker for each destination host.
+type circuitBreakers struct {
+	config   CircuitBreakerConfig
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// circuitBreaker keeps the state and the counters of a single host.
+type circuitBreaker struct {
+	state       CircuitState
+	windowStart time.Time
+	openedAt    time.Time
+	requests    int
+	failures    int
+	trials      int
+	successes   int
+}
+
+func newCircuitBreakers(config CircuitBreakerConfig) *circuitBreakers {
+	if config.IsFailure == nil {
+		config.IsFailure = func(resp *http.Response, err error) bool {
+			return err != nil || resp.StatusCode >= http.StatusInternalServerError
+		}
+	}
+	// the fields that aren't set take the default values, so a partial config doesn't open the circuit
+	// on the first request
+	defaults := DefaultCircuitBreakerConfig()
+	if config.FailureRateThreshold <= 0 {
+		config.FailureRateThreshold = defaults.FailureRateThreshold
+	}
+	if config.MinimumRequests <= 0 {
+		config.MinimumRequests = defaults.MinimumRequests
+	}
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = defaults.Cooldown
+	}
+	config.HalfOpenRequests = max(config.HalfOpenRequests, 1)
+	return &circuitBreakers{
+		config:   config,
+		breakers: make(map[string]*circuitBreaker),
+	}
+}
+
+// State returns the current state of the circuit of the host.
+func (b *circuitBreakers) State(host string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if cb, ok := b.breakers[host]; ok {
+		return cb.state
+	}
+	return StateClosed
+}
+
+// allow tells if a request to the host can be sent. It returns ErrCircuitOpen when it can't.
+func (b *circuitBreakers) allow(ctx context.Context, host string) error {
+	b.mu.Lock()
+	cb := b.get(host)
+	from := cb.state
+	if cb.state == StateOpen && time.Since(cb.openedAt) >= b.config.Cooldown {
+		cb.state = StateHalfOpen
+		cb.trials = 0
+		cb.successes = 0
+	}
+	to := cb.state
+	allowed := true
+	switch cb.state {
+	case StateOpen:
+		allowed = false
+	case StateHalfOpen:
+		allowed = cb.trials < b.config.HalfOpenRequests
+		if allowed {
+			cb.trials++
+		}
+	}
+	b.mu.Unlock()
+
+	b.changed(ctx, host, from, to)
+	if !allowed {
+		return fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+	}
+	return nil
+}
+
+// record counts the result of a request to the host and moves the circuit to its next state.
+func (b *circuitBreakers) record(ctx context.Context, host string, resp *http.Response, err error) {
+	failed := b.config.IsFailure(resp, err)
+
+	b.mu.Lock()
+	cb := b.get(host)
+	from := cb.state
+	switch cb.state {
+	case StateClosed:
+		if time.Since(cb.windowStart) > b.config.Window {
+			cb.windowStart = time.Now()
+			cb.requests = 0
+			cb.failures = 0
+		}
+		cb.requests++
+		if failed {
+			cb.failures++
+		}
+		if cb.requests >= b.config.MinimumRequests &&
+			float64(cb.failures)/float64(cb.requests) >= b.config.FailureRateThreshold {
+			cb.open()
+		}
+	case StateHalfOpen:
+		if failed {
+			cb.open()
+			break
+		}
+		cb.successes++
+		if cb.successes >= b.config.HalfOpenRequests {
+			cb.state = StateClosed
+			cb.windowStart = time.Now()
+			cb.requests = 0
+			cb.failures = 0
+		}
+	}
+	to := cb.state
+	b.mu.Unlock()
+
+	b.changed(ctx, host, from, to)
+}
+
+// cancel releases the trial of a request to the host that was cancelled before having a result,
+// so a half-open circuit lets another one through.
+func (b *circuitBreakers) cancel(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if cb := b.get(host); cb.state == StateHalfOpen && cb.trials > 0 {
+		cb.trials--
+	}
+}
+
+// get returns the circuit breaker of the host, creating it when needed. It must be called holding the lock.
+func (b *circuitBreakers) get(host string) *circuitBreaker {
+	cb, ok := b.breakers[host]
+	if !ok {
+		cb = &circuitBreaker{windowStart: time.Now()}
+		b.breakers[host] = cb
+	}
+	return cb
+}
+
+// changed logs and notifies a state change of the circuit of the host.
+func (b *circuitBreakers) changed(ctx context.Context, host string, from, to CircuitState) {
+	if from == to {
+		return
+	}
+	log.Ctx(ctx).Warn().Msgf("circuit breaker for %s changed from %s to %s", host, from, to)
+	if b.config.OnStateChange != nil {
+		b.config.OnStateChange(host, from, to)
+	}
+}
+
+func (cb *circuitBreaker) open() {
+	cb.state = StateOpen
+	cb.openedAt = time.Now()
+}
@@ -0,0 +1,181 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	var calls atomic.Int32
+	failing.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	host := serverURL.Host
+
+	var transitions []string
+	config := CircuitBreakerConfig{
+		FailureRateThreshold: 0.5,
+		MinimumRequests:      2,
+		Window:               time.Minute,
+		Cooldown:             20 * time.Millisecond,
+		HalfOpenRequests:     1,
+		OnStateChange: func(host string, from, to CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+	}
+	client := NewClient(server.Client(), WithCircuitBreaker(config))
+	ctx := context.Background()
+
+	// two failures open the circuit
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ctx, Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	}
+	assert.Equal(t, StateOpen, client.CircuitState(host))
+
+	// open circuit fails fast without calling the server
+	resp, err := client.Get(ctx, Payload{URL: server.URL})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Nil(t, resp)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// a failed trial request opens the circuit again
+	time.Sleep(30 * time.Millisecond)
+	_, err = client.Get(ctx, Payload{URL: server.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, StateOpen, client.CircuitState(host))
+
+	// a successful trial request closes the circuit
+	failing.Store(false)
+	time.Sleep(30 * time.Millisecond)
+	resp, err = client.Get(ctx, Payload{URL: server.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, StateClosed, client.CircuitState(host))
+
+	assert.Equal(t, []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}, transitions)
+}
+
+func TestCircuitBreakers_PerHost(t *testing.T) {
+	breakers := newCircuitBreakers(CircuitBreakerConfig{
+		FailureRateThreshold: 1,
+		MinimumRequests:      1,
+		Window:               time.Minute,
+		Cooldown:             time.Minute,
+	})
+	ctx := context.Background()
+
+	breakers.record(ctx, "a.local", nil, assert.AnError)
+	assert.ErrorIs(t, breakers.allow(ctx, "a.local"), ErrCircuitOpen)
+	assert.NoError(t, breakers.allow(ctx, "b.local"))
+	assert.Equal(t, StateClosed, breakers.State("b.local"))
+}
+
+func TestCircuitBreakers_HalfOpenLimitsTrials(t *testing.T) {
+	breakers := newCircuitBreakers(CircuitBreakerConfig{
+		FailureRateThreshold: 1,
+		MinimumRequests:      1,
+		Window:               time.Minute,
+		Cooldown:             time.Nanosecond,
+		HalfOpenRequests:     1,
+	})
+	ctx := context.Background()
+
+	breakers.record(ctx, "a.local", nil, assert.AnError)
+	assert.NoError(t, breakers.allow(ctx, "a.local"))
+	assert.Equal(t, StateHalfOpen, breakers.State("a.local"))
+	assert.ErrorIs(t, breakers.allow(ctx, "a.local"), ErrCircuitOpen)
+}
+
+func TestCircuitBreakers_PartialConfig(t *testing.T) {
+	breakers := newCircuitBreakers(CircuitBreakerConfig{Cooldown: time.Second})
+	ctx := context.Background()
+
+	defaults := DefaultCircuitBreakerConfig()
+	assert.Equal(t, defaults.FailureRateThreshold, breakers.config.FailureRateThreshold)
+	assert.Equal(t, defaults.MinimumRequests, breakers.config.MinimumRequests)
+	assert.Equal(t, defaults.Window, breakers.config.Window)
+	assert.Equal(t, time.Second, breakers.config.Cooldown)
+
+	// a success doesn't open the circuit, and neither do the failures below the minimum requests
+	breakers.record(ctx, "a.local", &http.Response{StatusCode: http.StatusOK}, nil)
+	for i := 0; i < defaults.MinimumRequests-2; i++ {
+		breakers.record(ctx, "a.local", nil, assert.AnError)
+	}
+	assert.NoError(t, breakers.allow(ctx, "a.local"))
+	assert.Equal(t, StateClosed, breakers.State("a.local"))
+
+	breakers.record(ctx, "a.local", nil, assert.AnError)
+	assert.Equal(t, StateOpen, breakers.State("a.local"))
+}
+
+func TestClient_CircuitBreakerIgnoresCancelledRequests(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	defer close(release)
+	serverURL, _ := url.Parse(server.URL)
+	host := serverURL.Host
+
+	config := CircuitBreakerConfig{
+		FailureRateThreshold: 1,
+		MinimumRequests:      1,
+		Window:               time.Minute,
+		Cooldown:             time.Minute,
+	}
+
+	t.Run("cancelled and expired contexts of the caller", func(t *testing.T) {
+		client := NewClient(server.Client(), WithCircuitBreaker(config))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		_, err := client.Get(ctx, Payload{URL: server.URL})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, StateClosed, client.CircuitState(host))
+
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = client.Get(ctx, Payload{URL: server.URL})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, StateClosed, client.CircuitState(host))
+	})
+
+	t.Run("timeout of the http client", func(t *testing.T) {
+		httpClient := server.Client()
+		httpClient.Timeout = 10 * time.Millisecond
+		client := NewClient(httpClient, WithCircuitBreaker(config))
+
+		_, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.Error(t, err)
+		assert.Equal(t, StateOpen, client.CircuitState(host))
+	})
+}
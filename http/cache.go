@@ -0,0 +1,462 @@
+package http
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCacheEntrySize is the maximum size of a response body stored in the cache.
+	maxCacheEntrySize = 10 << 20
+	// staleRetention is how long a stale response with validators is kept to be revalidated.
+	staleRetention = 24 * time.Hour
+	// defaultMaxCacheEntries is the number of responses kept by NewMemoryCacheStore.
+	defaultMaxCacheEntries = 1000
+	// defaultMaxCacheBytes is the total size of the responses kept by NewMemoryCacheStore.
+	defaultMaxCacheBytes = 64 << 20
+)
+
+// CacheStore stores the cached responses.
+type CacheStore interface {
+	// Get returns the value of the key and whether it was found.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores the value of the key for the given time.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the key.
+	Delete(ctx context.Context, key string) error
+}
+
+// MemoryCacheConfig limits the responses kept in memory.
+// The fields that aren't set take the default values: 1000 responses and 64 MiB.
+type MemoryCacheConfig struct {
+	// MaxEntries is the maximum number of responses kept.
+	MaxEntries int
+	// MaxBytes is the maximum total size of the responses kept, counting their keys.
+	MaxBytes int64
+}
+
+// memoryCacheStore keeps the cached responses in memory, evicting the least recently used ones
+// when it's full.
+type memoryCacheStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	// order keeps the entries from the most to the least recently used
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// size is the number of bytes the entry counts against the budget of the store.
+func (e *memoryCacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// NewMemoryCacheStore creates a CacheStore that keeps up to 1000 responses and 64 MiB in memory.
+func NewMemoryCacheStore() CacheStore {
+	return NewMemoryCacheStoreWithConfig(MemoryCacheConfig{})
+}
+
+// NewMemoryCacheStoreWithSize creates a CacheStore that keeps up to maxEntries responses and 64 MiB in memory,
+// evicting the least recently used ones when it's full.
+func NewMemoryCacheStoreWithSize(maxEntries int) CacheStore {
+	return NewMemoryCacheStoreWithConfig(MemoryCacheConfig{MaxEntries: maxEntries})
+}
+
+// NewMemoryCacheStoreWithConfig creates a CacheStore that keeps the responses in memory within the limits
+// of the config, evicting the least recently used ones when it's full.
+func NewMemoryCacheStoreWithConfig(config MemoryCacheConfig) CacheStore {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = defaultMaxCacheEntries
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = defaultMaxCacheBytes
+	}
+	return &memoryCacheStore{
+		maxEntries: config.MaxEntries,
+		maxBytes:   config.MaxBytes,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value of the key if it hasn't expired.
+func (s *memoryCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expires) {
+		s.remove(element)
+		return nil, false, nil
+	}
+	s.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+// Set stores the value of the key for the given time.
+func (s *memoryCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		s.remove(element)
+	}
+	entry := &memoryCacheEntry{key: key, value: value, expires: time.Now().Add(ttl)}
+	if entry.size() > s.maxBytes {
+		// the value alone doesn't fit, so it isn't stored instead of evicting every other entry
+		return nil
+	}
+	s.entries[key] = s.order.PushFront(entry)
+	s.bytes += entry.size()
+	for s.order.Len() > s.maxEntries || s.bytes > s.maxBytes {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+// Delete removes the key.
+func (s *memoryCacheStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[key]; ok {
+		s.remove(element)
+	}
+	return nil
+}
+
+// remove removes the entry of the element. It must be called holding the lock.
+func (s *memoryCacheStore) remove(element *list.Element) {
+	entry := element.Value.(*memoryCacheEntry)
+	s.order.Remove(element)
+	delete(s.entries, entry.key)
+	s.bytes -= entry.size()
+}
+
+// cachedResponse is a response stored in the cache.
+type cachedResponse struct {
+	StatusCode int               `json:"status_code"`
+	Header     http.Header       `json:"header"`
+	Body       []byte            `json:"body"`
+	Vary       map[string]string `json:"vary"`
+	StoredAt   time.Time         `json:"stored_at"`
+	InitialAge time.Duration     `json:"initial_age"`
+}
+
+// httpCache serves GET requests from the store following RFC 9111.
+// It behaves as a private cache and keeps a single variant for each URL and credentials.
+type httpCache struct {
+	store CacheStore
+}
+
+// do serves the request of the payload from the cache, revalidating or storing the response when needed.
+func (h *httpCache) do(ctx context.Context, c *Client, payload Payload, url string) (*http.Response, error) {
+	// the request is built once to know the final headers, including authorization
+	req, err := c.newRequest(ctx, payload, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := c.prepare(payload, req); err != nil {
+		return nil, err
+	}
+	key := cacheKey(req)
+	logURL := safeURL(req.URL)
+
+	if payload.Method != http.MethodGet {
+		resp, err := c.send(ctx, payload, url)
+		if err == nil && isUnsafe(payload.Method) && resp.StatusCode < http.StatusBadRequest {
+			// a successful unsafe request invalidates the cached response of the URL
+			if err := h.store.Delete(ctx, key); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msgf("failed to invalidate cached response of %s", logURL)
+			}
+		}
+		return resp, err
+	}
+	requestDirectives := parseCacheControl(req.Header.Get("Cache-Control"))
+	_, noStore := requestDirectives["no-store"]
+	conditional := req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+	if payload.SkipCache || noStore || conditional {
+		log.Ctx(ctx).Debug().Msgf("cache bypassed for %s", logURL)
+		return c.send(ctx, payload, url)
+	}
+
+	entry := h.lookup(ctx, key, req)
+	if entry != nil {
+		_, noCache := requestDirectives["no-cache"]
+		if !noCache && entry.fresh() {
+			log.Ctx(ctx).Debug().Msgf("cache hit for %s", logURL)
+			return entry.response(req), nil
+		}
+		if !entry.hasValidators() {
+			entry = nil
+		} else {
+			// ask the server whether the stale response is still valid
+			payload.Headers = entry.conditionalHeaders(payload.Headers)
+		}
+	}
+	if entry == nil {
+		log.Ctx(ctx).Debug().Msgf("cache miss for %s", logURL)
+	}
+
+	resp, err := c.send(ctx, payload, url)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		entry.refresh(resp.Header)
+		h.save(ctx, key, entry)
+		log.Ctx(ctx).Debug().Msgf("cached response revalidated for %s", logURL)
+		return entry.response(req), nil
+	}
+	return h.storeResponse(ctx, key, req, resp), nil
+}
+
+// lookup returns the cached response of the key when it matches the Vary headers of the request.
+func (h *httpCache) lookup(ctx context.Context, key string, req *http.Request) *cachedResponse {
+	value, ok, err := h.store.Get(ctx, key)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to get cached response of %s", safeURL(req.URL))
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	var entry cachedResponse
+	if err := json.Unmarshal(value, &entry); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to unmarshal cached response of %s", safeURL(req.URL))
+		return nil
+	}
+	for header, value := range entry.Vary {
+		if req.Header.Get(header) != value {
+			return nil
+		}
+	}
+	return &entry
+}
+
+// storeResponse stores the response when it's cacheable and returns a response whose body can still be read.
+func (h *httpCache) storeResponse(ctx context.Context, key string, req *http.Request, resp *http.Response) *http.Response {
+	directives := parseCacheControl(resp.Header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok || !cacheableStatus(resp.StatusCode) || resp.Header.Get("Vary") == "*" {
+		return resp
+	}
+	entry := &cachedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+		Vary:       make(map[string]string),
+		StoredAt:   time.Now(),
+	}
+	if entry.lifetime() <= 0 && !entry.hasValidators() {
+		return resp
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCacheEntrySize+1))
+	if err != nil || len(body) > maxCacheEntrySize {
+		// hand the caller what was read followed by the rest of the body
+		resp.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		return resp
+	}
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	entry.Body = body
+	entry.InitialAge = initialAge(resp.Header, entry.StoredAt)
+	for _, header := range strings.Split(resp.Header.Get("Vary"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			entry.Vary[http.CanonicalHeaderKey(header)] = req.Header.Get(header)
+		}
+	}
+	h.save(ctx, key, entry)
+	log.Ctx(ctx).Debug().Msgf("response of %s stored in cache", safeURL(req.URL))
+	return resp
+}
+
+// save writes the entry in the store, keeping stale entries with validators to revalidate them.
+func (h *httpCache) save(ctx context.Context, key string, entry *cachedResponse) {
+	ttl := entry.lifetime() - entry.InitialAge
+	if entry.hasValidators() {
+		ttl += staleRetention
+	}
+	if ttl <= 0 {
+		return
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to marshal cached response")
+		return
+	}
+	if err := h.store.Set(ctx, key, value, ttl); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to store cached response")
+	}
+}
+
+// lifetime returns the freshness lifetime given by max-age or Expires.
+func (e *cachedResponse) lifetime() time.Duration {
+	directives := parseCacheControl(e.Header.Get("Cache-Control"))
+	if _, ok := directives["no-cache"]; ok {
+		return 0
+	}
+	if value, ok := directives["max-age"]; ok {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		return 0
+	}
+	if expires := e.Header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			// an invalid Expires means already expired
+			return 0
+		}
+		date, err := http.ParseTime(e.Header.Get("Date"))
+		if err != nil {
+			date = e.StoredAt
+		}
+		return expiresAt.Sub(date)
+	}
+	return 0
+}
+
+// age returns the current age of the response.
+func (e *cachedResponse) age() time.Duration {
+	return e.InitialAge + time.Since(e.StoredAt)
+}
+
+// fresh tells if the response can be served without revalidation.
+func (e *cachedResponse) fresh() bool {
+	return e.lifetime() > e.age()
+}
+
+// hasValidators tells if the response can be revalidated.
+func (e *cachedResponse) hasValidators() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// conditionalHeaders returns a copy of the headers with the validators of the response.
+func (e *cachedResponse) conditionalHeaders(headers map[string]string) map[string]string {
+	if etag := e.Header.Get("ETag"); etag != "" {
+		headers = withHeader(headers, "If-None-Match", etag)
+	}
+	if lastModified := e.Header.Get("Last-Modified"); lastModified != "" {
+		headers = withHeader(headers, "If-Modified-Since", lastModified)
+	}
+	return headers
+}
+
+// refresh updates the stored headers with the headers of a 304 response.
+func (e *cachedResponse) refresh(header http.Header) {
+	for _, key := range []string{"Cache-Control", "Date", "Expires", "ETag", "Last-Modified", "Vary"} {
+		if value := header.Get(key); value != "" {
+			e.Header.Set(key, value)
+		}
+	}
+	e.StoredAt = time.Now()
+	e.InitialAge = initialAge(header, e.StoredAt)
+}
+
+// response builds the HTTP response served from the cache.
+func (e *cachedResponse) response(req *http.Request) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(e.age().Seconds())))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// initialAge returns the age of a response when it was received, from its Age and Date headers.
+func initialAge(header http.Header, receivedAt time.Time) time.Duration {
+	var age time.Duration
+	if seconds, err := strconv.Atoi(header.Get("Age")); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		age = max(age, receivedAt.Sub(date))
+	}
+	return max(age, 0)
+}
+
+// cacheKey returns the key of the request: a hash of its URL and credentials, so responses are never
+// shared between different credentials and the secrets of the URL, eg: an api_key query parameter,
+// don't end up in the key names of the store.
+func cacheKey(req *http.Request) string {
+	hash := sha256.New()
+	hash.Write([]byte(req.URL.String()))
+	if authorization := req.Header.Get("Authorization"); authorization != "" {
+		hash.Write([]byte{0})
+		hash.Write([]byte(authorization))
+	}
+	return "httpcache:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// parseCacheControl returns the directives of a Cache-Control header.
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(part, "=")
+		directives[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+	return directives
+}
+
+// cacheableStatus tells if responses with the status code can be cached.
+func cacheableStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone,
+		http.StatusRequestURITooLong, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
+
+// isUnsafe tells if the method changes the state of the server.
+func isUnsafe(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// readCloser joins a reader with the closer of the original body.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,40 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"github.com/narumayase/anysher/redis"
+	"time"
+)
+
+// redisCacheStore keeps the cached responses in Redis so they are shared between replicas.
+type redisCacheStore struct {
+	repository *redis.Repository
+}
+
+// NewRedisCacheStore creates a CacheStore on top of the Redis repository.
+func NewRedisCacheStore(repository *redis.Repository) CacheStore {
+	return &redisCacheStore{repository: repository}
+}
+
+// Get returns the value of the key and whether it was found.
+func (s *redisCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := s.repository.Get(ctx, key)
+	if errors.Is(err, redis.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(value), true, nil
+}
+
+// Set stores the value of the key for the given time.
+func (s *redisCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.repository.SaveWithExpiration(ctx, key, value, ttl)
+}
+
+// Delete removes the key.
+func (s *redisCacheStore) Delete(ctx context.Context, key string) error {
+	return s.repository.Delete(ctx, key)
+}
@@ -0,0 +1,42 @@
+package http
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/narumayase/anysher/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisCacheStore(t *testing.T) {
+	ctx := context.Background()
+	db, mock := redismock.NewClientMock()
+	store := NewRedisCacheStore(redis.NewRepositoryWithClient(db))
+
+	mock.ExpectGet("missing").RedisNil()
+	value, ok, err := store.Get(ctx, "missing")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, value)
+
+	mock.ExpectSet("key", []byte("value"), time.Minute).SetVal("OK")
+	assert.NoError(t, store.Set(ctx, "key", []byte("value"), time.Minute))
+
+	mock.ExpectGet("key").SetVal("value")
+	value, ok, err = store.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value"), value)
+
+	mock.ExpectDel("key").SetVal(1)
+	assert.NoError(t, store.Delete(ctx, "key"))
+
+	mock.ExpectGet("key").SetErr(assert.AnError)
+	_, ok, err = store.Get(ctx, "key")
+	assert.Error(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,287 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readBody(t *testing.T, resp *http.Response) string {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+func TestClient_Cache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("fresh responses are served from the cache", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = w.Write([]byte("reference data"))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCache(NewMemoryCacheStore()))
+		for i := 0; i < 3; i++ {
+			resp, err := client.Get(ctx, Payload{URL: server.URL})
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "reference data", readBody(t, resp))
+		}
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("skip cache bypasses the cache", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			_, _ = w.Write([]byte("reference data"))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCache(NewMemoryCacheStore()))
+		_, err := client.Get(ctx, Payload{URL: server.URL})
+		assert.NoError(t, err)
+		_, err = client.Get(ctx, Payload{URL: server.URL, SkipCache: true})
+		assert.NoError(t, err)
+		_, err = client.Get(ctx, Payload{URL: server.URL, Headers: map[string]string{"Cache-Control": "no-store"}})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("stale responses are revalidated with ETag", func(t *testing.T) {
+		var calls, notModified atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				notModified.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			_, _ = w.Write([]byte("versioned data"))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCache(NewMemoryCacheStore()))
+		for i := 0; i < 3; i++ {
+			resp, err := client.Get(ctx, Payload{URL: server.URL})
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "versioned data", readBody(t, resp))
+		}
+		assert.Equal(t, int32(3), calls.Load())
+		assert.Equal(t, int32(2), notModified.Load())
+	})
+
+	t.Run("stale responses are revalidated with Last-Modified", func(t *testing.T) {
+		lastModified := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+		var notModified atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=0")
+			w.Header().Set("Last-Modified", lastModified)
+			if r.Header.Get("If-Modified-Since") == lastModified {
+				notModified.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			_, _ = w.Write([]byte("dated data"))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCache(NewMemoryCacheStore()))
+		for i := 0; i < 2; i++ {
+			resp, err := client.Get(ctx, Payload{URL: server.URL})
+			assert.NoError(t, err)
+			assert.Equal(t, "dated data", readBody(t, resp))
+		}
+		assert.Equal(t, int32(1), notModified.Load())
+	})
+
+	t.Run("vary headers select the stored variant", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+			_, _ = w.Write([]byte(r.Header.Get("Accept-Language")))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCache(NewMemoryCacheStore()))
+		get := func(language string) string {
+			resp, err := client.Get(ctx, Payload{URL: server.URL, Headers: map[string]string{"Accept-Language": language}})
+			assert.NoError(t, err)
+			return readBody(t, resp)
+		}
+		assert.Equal(t, "en", get("en"))
+		assert.Equal(t, "en", get("en"))
+		assert.Equal(t, "es", get("es"))
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("no-store responses and other credentials are not served from the cache", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			if r.URL.Path == "/secret" {
+				w.Header().Set("Cache-Control", "no-store")
+			} else {
+				w.Header().Set("Cache-Control", "max-age=60")
+			}
+			_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCache(NewMemoryCacheStore()))
+		for i := 0; i < 2; i++ {
+			_, err := client.Get(ctx, Payload{URL: server.URL + "/secret"})
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, int32(2), calls.Load())
+
+		resp, err := client.Get(ctx, Payload{URL: server.URL, Token: "a"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer a", readBody(t, resp))
+		resp, err = client.Get(ctx, Payload{URL: server.URL, Token: "b"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer b", readBody(t, resp))
+		assert.Equal(t, int32(4), calls.Load())
+	})
+
+	t.Run("unsafe requests invalidate the cached response", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCache(NewMemoryCacheStore()))
+		_, err := client.Get(ctx, Payload{URL: server.URL})
+		assert.NoError(t, err)
+		_, err = client.Put(ctx, Payload{URL: server.URL, Content: []byte("{}")})
+		assert.NoError(t, err)
+		_, err = client.Get(ctx, Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("expires header gives the freshness", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			now := time.Now().UTC()
+			w.Header().Set("Date", now.Format(http.TimeFormat))
+			w.Header().Set("Expires", now.Add(time.Minute).Format(http.TimeFormat))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCache(NewMemoryCacheStore()))
+		_, err := client.Get(ctx, Payload{URL: server.URL})
+		assert.NoError(t, err)
+		resp, err := client.Get(ctx, Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, "0", resp.Header.Get("Age"))
+		assert.Equal(t, int32(1), calls.Load())
+	})
+}
+
+func TestParseCacheControl(t *testing.T) {
+	directives := parseCacheControl(`max-age=60, No-Cache, private="Set-Cookie"`)
+	assert.Equal(t, map[string]string{"max-age": "60", "no-cache": "", "private": "Set-Cookie"}, directives)
+}
+
+func TestMemoryCacheStore_Expiration(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCacheStore()
+
+	assert.NoError(t, store.Set(ctx, "key", []byte("value"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	_, ok, err := store.Get(ctx, "key")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestMemoryCacheStore_EvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCacheStoreWithSize(2)
+
+	assert.NoError(t, store.Set(ctx, "a", []byte("a"), time.Minute))
+	assert.NoError(t, store.Set(ctx, "b", []byte("b"), time.Minute))
+	_, ok, _ := store.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.NoError(t, store.Set(ctx, "c", []byte("c"), time.Minute))
+
+	_, ok, _ = store.Get(ctx, "b")
+	assert.False(t, ok)
+	for _, key := range []string{"a", "c"} {
+		value, ok, err := store.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []byte(key), value)
+	}
+	assert.Len(t, store.(*memoryCacheStore).entries, 2)
+}
+
+func TestMemoryCacheStore_EvictsBySize(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCacheStoreWithConfig(MemoryCacheConfig{MaxBytes: 250})
+	large := func(b byte) []byte { return bytes.Repeat([]byte{b}, 99) }
+
+	assert.NoError(t, store.Set(ctx, "a", large('a'), time.Minute))
+	assert.NoError(t, store.Set(ctx, "b", large('b'), time.Minute))
+	_, ok, _ := store.Get(ctx, "a")
+	assert.True(t, ok)
+	// the third entry exceeds the budget, so the least recently used one is evicted
+	assert.NoError(t, store.Set(ctx, "c", large('c'), time.Minute))
+
+	_, ok, _ = store.Get(ctx, "b")
+	assert.False(t, ok)
+	for _, key := range []string{"a", "c"} {
+		value, ok, err := store.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, large(key[0]), value)
+	}
+	assert.Equal(t, int64(200), store.(*memoryCacheStore).bytes)
+
+	// replacing an entry counts only its new size
+	assert.NoError(t, store.Set(ctx, "a", []byte("a"), time.Minute))
+	assert.Equal(t, int64(102), store.(*memoryCacheStore).bytes)
+
+	// an entry larger than the whole budget isn't stored and doesn't evict the others
+	assert.NoError(t, store.Set(ctx, "d", bytes.Repeat([]byte{'d'}, 300), time.Minute))
+	_, ok, _ = store.Get(ctx, "d")
+	assert.False(t, ok)
+	assert.Len(t, store.(*memoryCacheStore).entries, 2)
+}
+
+func TestCacheKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/countries?api_key=a-secret", nil)
+	key := cacheKey(req)
+	assert.True(t, strings.HasPrefix(key, "httpcache:"))
+	assert.NotContains(t, key, "a-secret")
+	assert.NotContains(t, key, "example.com")
+	assert.Equal(t, key, cacheKey(req.Clone(context.Background())))
+
+	req.Header.Set("Authorization", "Bearer a-token")
+	assert.NotEqual(t, key, cacheKey(req))
+	assert.NotContains(t, cacheKey(req), "a-token")
+}
@@ -0,0 +1,135 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CoalescingConfig defines which in-flight requests are identical and share a single response.
+type CoalescingConfig struct {
+	// Headers are the headers of the payload that must be equal besides the method, the URL and the token,
+	// eg: Accept or Accept-Language.
+	Headers []string
+	// MaxBodySize is the size in bytes up to which a response body is shared. Larger responses are only
+	// returned to the first request, the others send their own. It defaults to 10MB.
+	MaxBodySize int64
+}
+
+// WithCoalescing makes identical GET and HEAD requests in flight at the same time share the response
+// of the first one, instead of sending a request each, eg: on a cache miss storm.
+// Every caller gets its own copy of the response, whose body is read into memory.
+func WithCoalescing(config CoalescingConfig) Option {
+	return func(c *Client) {
+		if config.MaxBodySize <= 0 {
+			config.MaxBodySize = defaultMaxResponseSize
+		}
+		c.coalescing = &coalescer{config: config, calls: make(map[string]*coalescedCall)}
+	}
+}
+
+// coalescer keeps the requests in flight by key.
+type coalescer struct {
+	config CoalescingConfig
+	mu     sync.Mutex
+	calls  map[string]*coalescedCall
+}
+
+// coalescedCall is a request in flight. Its response is shared once done is closed, unless it has
+// no body because it failed or it was too large.
+type coalescedCall struct {
+	done chan struct{}
+	resp *http.Response
+	body []byte
+	err  error
+}
+
+// do returns a copy of the response of the identical request in flight, or sends the request with
+// fetch and shares its response with the identical ones sent meanwhile.
+func (g *coalescer) do(ctx context.Context, payload Payload, url string, fetch func() (*http.Response, error)) (*http.Response, error) {
+	key := g.key(payload, url)
+
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to execute request: %w", ctx.Err())
+		}
+		switch {
+		case call.err != nil && !errors.Is(call.err, context.Canceled) && !errors.Is(call.err, context.DeadlineExceeded):
+			return nil, call.err
+		case call.resp == nil:
+			// the response was too large or the context of the first request ended
+			return fetch()
+		}
+		log.Ctx(ctx).Debug().Msgf("request to %s %s coalesced with an identical one in flight", payload.Method, safeRawURL(url))
+		return call.response(), nil
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	resp, err := fetch()
+	if err != nil {
+		call.err = err
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, g.config.MaxBodySize+1))
+	if err != nil {
+		_ = resp.Body.Close()
+		call.err = fmt.Errorf("failed to read response body: %w", err)
+		return nil, call.err
+	}
+	if int64(len(body)) > g.config.MaxBodySize {
+		// the rest of the body is only read by this request
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	_ = resp.Body.Close()
+	call.resp, call.body = resp, body
+	return call.response(), nil
+}
+
+// key identifies identical requests by method, URL, token and the configured headers.
+func (g *coalescer) key(payload Payload, url string) string {
+	var key strings.Builder
+	key.WriteString(payload.Method + " " + url + "\n" + payload.Token)
+	for _, header := range g.config.Headers {
+		key.WriteString("\n" + http.CanonicalHeaderKey(header) + ":")
+		for name, value := range payload.Headers {
+			if strings.EqualFold(name, header) {
+				key.WriteString(value)
+			}
+		}
+	}
+	return key.String()
+}
+
+// response returns a copy of the shared response with its own body and headers.
+func (c *coalescedCall) response() *http.Response {
+	resp := *c.resp
+	resp.Header = c.resp.Header.Clone()
+	resp.Trailer = c.resp.Trailer.Clone()
+	resp.Body = io.NopCloser(bytes.NewReader(c.body))
+	resp.ContentLength = int64(len(c.body))
+	return &resp
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sendConcurrently sends the payloads at the same time and returns the bodies, or the errors, in order.
+func sendConcurrently(client *Client, payloads ...Payload) []string {
+	results := make([]string, len(payloads))
+	var wg sync.WaitGroup
+	for i, payload := range payloads {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Do(context.Background(), payload)
+			if err != nil {
+				results[i] = err.Error()
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			results[i] = string(body)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// slowServer answers with the body after the delay and counts its requests.
+func slowServer(t *testing.T, body string, delay time.Duration) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		time.Sleep(delay)
+		w.Header().Set("X-Request", r.Header.Get("Accept-Language"))
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestClient_Coalescing(t *testing.T) {
+	t.Run("identical requests share the response", func(t *testing.T) {
+		server, requests := slowServer(t, `{"id":1}`, 100*time.Millisecond)
+		client := NewClient(server.Client(), WithCoalescing(CoalescingConfig{}))
+
+		payload := Payload{URL: server.URL + "/items/1"}
+		results := sendConcurrently(client, payload, payload, payload, payload, payload)
+		assert.Equal(t, int32(1), requests.Load())
+		for _, result := range results {
+			assert.Equal(t, `{"id":1}`, result)
+		}
+
+		// the requests sent afterward aren't coalesced
+		sendConcurrently(client, payload)
+		assert.Equal(t, int32(2), requests.Load())
+	})
+
+	t.Run("the configured headers and the token are part of the key", func(t *testing.T) {
+		server, requests := slowServer(t, `{"id":1}`, 100*time.Millisecond)
+		client := NewClient(server.Client(), WithCoalescing(CoalescingConfig{Headers: []string{"accept-language"}}))
+
+		english := Payload{URL: server.URL, Headers: map[string]string{"Accept-Language": "en"}}
+		spanish := Payload{URL: server.URL, Headers: map[string]string{"Accept-Language": "es"}}
+		other := Payload{URL: server.URL, Headers: map[string]string{"Accept-Language": "en"}, Token: "another-token"}
+		sendConcurrently(client, english, english, spanish, spanish, other)
+		assert.Equal(t, int32(3), requests.Load())
+	})
+
+	t.Run("requests with a body aren't coalesced", func(t *testing.T) {
+		server, requests := slowServer(t, `{"id":1}`, 50*time.Millisecond)
+		client := NewClient(server.Client(), WithCoalescing(CoalescingConfig{}))
+
+		payload := Payload{Method: http.MethodPost, URL: server.URL, Content: []byte(`{}`)}
+		sendConcurrently(client, payload, payload)
+		assert.Equal(t, int32(2), requests.Load())
+	})
+
+	t.Run("large responses aren't shared", func(t *testing.T) {
+		server, requests := slowServer(t, `{"id":1,"name":"a large item"}`, 100*time.Millisecond)
+		client := NewClient(server.Client(), WithCoalescing(CoalescingConfig{MaxBodySize: 10}))
+
+		payload := Payload{URL: server.URL}
+		results := sendConcurrently(client, payload, payload, payload)
+		// the first request and then each of the others
+		assert.Equal(t, int32(3), requests.Load())
+		for _, result := range results {
+			assert.Equal(t, `{"id":1,"name":"a large item"}`, result)
+		}
+	})
+
+	t.Run("errors are shared", func(t *testing.T) {
+		var calls atomic.Int32
+		client := NewClient(&http.Client{Transport: &MockRoundTripper{
+			RoundTripFunc: func(*http.Request) (*http.Response, error) {
+				calls.Add(1)
+				time.Sleep(100 * time.Millisecond)
+				return nil, errors.New("connection refused")
+			},
+		}}, WithCoalescing(CoalescingConfig{}))
+
+		payload := Payload{URL: "http://example.com"}
+		results := sendConcurrently(client, payload, payload, payload)
+		assert.Equal(t, int32(1), calls.Load())
+		for _, result := range results {
+			assert.Contains(t, result, "connection refused")
+		}
+	})
+
+	t.Run("every caller gets its own response", func(t *testing.T) {
+		server, _ := slowServer(t, `{"id":1}`, 0)
+		client := NewClient(server.Client(), WithCoalescing(CoalescingConfig{}))
+
+		resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(8), resp.ContentLength)
+		resp.Header.Set("X-Request", "changed")
+		assert.NoError(t, resp.Body.Close())
+	})
+}
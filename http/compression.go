@@ -0,0 +1,230 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// acceptEncoding is the Accept-Encoding header sent when the Client decompresses the responses.
+	acceptEncoding = "gzip, deflate, br, zstd"
+	// maxBufferedCompression is the size up to which request bodies are compressed in memory, keeping
+	// their Content-Length. Larger bodies are compressed while they're sent.
+	maxBufferedCompression = 4 << 20
+)
+
+// ErrDecompressedTooLarge is returned reading a response body whose decompressed size exceeds the limit.
+var ErrDecompressedTooLarge = errors.New("decompressed response body is too large")
+
+// CompressionConfig defines how request bodies are compressed and response bodies are decompressed.
+type CompressionConfig struct {
+	// Encoding compresses the request bodies, gzip or zstd. They're sent as they are when it's empty or identity.
+	Encoding string
+	// MinSize is the size in bytes from which request bodies are compressed. It defaults to 1KB.
+	// Bodies of unknown length are always compressed.
+	MinSize int64
+	// MaxDecompressedSize is the maximum size in bytes of a decompressed response body. It defaults to 32MB.
+	MaxDecompressedSize int64
+}
+
+// WithCompression makes the Client compress the request bodies and decompress the gzip, deflate, br
+// and zstd response bodies.
+func WithCompression(config CompressionConfig) Option {
+	return func(c *Client) {
+		c.compression = &config
+	}
+}
+
+// Compression returns an Interceptor that compresses the request body with the encoding of the config,
+// unless the request already has a Content-Encoding, and asks for compressed responses, decompressing
+// them while they're read. The decompressed response has no Content-Encoding nor Content-Length.
+func Compression(config CompressionConfig) Interceptor {
+	if config.MinSize <= 0 {
+		config.MinSize = 1 << 10
+	}
+	if config.MaxDecompressedSize <= 0 {
+		config.MaxDecompressedSize = 32 << 20
+	}
+	return func(req *http.Request, next Handler) (*http.Response, error) {
+		if err := compressRequest(req, config); err != nil {
+			return nil, fmt.Errorf("failed to compress request body: %w", err)
+		}
+		if req.Header.Get("Accept-Encoding") == "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := next(req)
+		if err != nil {
+			return resp, err
+		}
+		decompressResponse(resp, config.MaxDecompressedSize)
+		return resp, nil
+	}
+}
+
+// compressRequest replaces the body of the request with its compressed content.
+func compressRequest(req *http.Request, config CompressionConfig) error {
+	if config.Encoding == "" || config.Encoding == "identity" ||
+		req.Body == nil || req.Body == http.NoBody || req.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+	if req.ContentLength > 0 && req.ContentLength < config.MinSize {
+		return nil
+	}
+	if _, err := newEncoder(config.Encoding, io.Discard); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", config.Encoding)
+
+	body := req.Body
+	if req.ContentLength > 0 && req.ContentLength <= maxBufferedCompression {
+		var buf bytes.Buffer
+		err := encode(config.Encoding, &buf, body)
+		_ = body.Close()
+		if err != nil {
+			return err
+		}
+		compressed := buf.Bytes()
+		req.Body = io.NopCloser(bytes.NewReader(compressed))
+		req.ContentLength = int64(len(compressed))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(compressed)), nil
+		}
+		return nil
+	}
+
+	// the body is compressed while the transport reads it, the pipe is closed by the transport when it fails
+	reader, writer := io.Pipe()
+	go func() {
+		err := encode(config.Encoding, writer, body)
+		_ = body.Close()
+		_ = writer.CloseWithError(err)
+	}()
+	req.Body = reader
+	req.ContentLength = -1
+	req.GetBody = nil
+	return nil
+}
+
+// encode writes the content compressed with the encoding.
+func encode(encoding string, w io.Writer, content io.Reader) error {
+	encoder, err := newEncoder(encoding, w)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(encoder, content); err != nil {
+		_ = encoder.Close()
+		return err
+	}
+	return encoder.Close()
+}
+
+func newEncoder(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("unsupported encoding %q, expected gzip or zstd", encoding)
+}
+
+// decompressResponse replaces the body of a response with a supported Content-Encoding with its
+// decompressed content, limited to maxSize bytes.
+func decompressResponse(resp *http.Response, maxSize int64) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "gzip", "x-gzip", "deflate", "br", "zstd":
+	default:
+		return
+	}
+	resp.Body = &decompressingBody{body: resp.Body, encoding: encoding, maxSize: maxSize}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+}
+
+// decompressingBody decompresses a response body while it's read. The decoder is created on the first read,
+// so empty bodies, eg: of HEAD requests, aren't decoded.
+type decompressingBody struct {
+	body     io.ReadCloser
+	encoding string
+	maxSize  int64
+
+	decoder io.ReadCloser
+	read    int64
+	err     error
+}
+
+// Read reads the decompressed body, failing with ErrDecompressedTooLarge after maxSize bytes.
+func (b *decompressingBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.decoder == nil {
+		decoder, err := newDecoder(b.encoding, b.body)
+		if err != nil {
+			if err != io.EOF {
+				err = fmt.Errorf("failed to decompress %s response body: %w", b.encoding, err)
+			}
+			b.err = err
+			return 0, err
+		}
+		b.decoder = decoder
+	}
+	n, err := b.decoder.Read(p)
+	b.read += int64(n)
+	if b.read > b.maxSize {
+		b.err = fmt.Errorf("%w: more than %d bytes", ErrDecompressedTooLarge, b.maxSize)
+		return n - int(b.read-b.maxSize), b.err
+	}
+	return n, err
+}
+
+// Close closes the decoder and the compressed body.
+func (b *decompressingBody) Close() error {
+	if b.decoder != nil {
+		_ = b.decoder.Close()
+	}
+	return b.body.Close()
+}
+
+func newDecoder(encoding string, body io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		// deflate should be zlib wrapped, but some servers send raw deflate
+		buffered := bufio.NewReader(body)
+		header, err := buffered.Peek(2)
+		if err == io.EOF || (err == nil && !isZlibHeader(header)) {
+			return flate.NewReader(buffered), nil
+		}
+		return zlib.NewReader(buffered)
+	case "br":
+		return io.NopCloser(brotli.NewReader(body)), nil
+	case "zstd":
+		decoder, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
+
+// isZlibHeader tells if the first two bytes are a zlib header of deflate content.
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
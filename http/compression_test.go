@@ -0,0 +1,233 @@
+package http
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+// compressed returns the content compressed with the encoding.
+func compressed(t *testing.T, encoding string, content []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "zlib":
+		w = zlib.NewWriter(&buf)
+	case "deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	case "zstd":
+		w, _ = zstd.NewWriter(&buf)
+	}
+	_, err := w.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+// decompressed returns the content of the request body decompressed with its Content-Encoding.
+func decompressed(t *testing.T, r *http.Request) []byte {
+	var reader io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		assert.NoError(t, err)
+		reader = gz
+	case "zstd":
+		decoder, err := zstd.NewReader(r.Body)
+		assert.NoError(t, err)
+		defer decoder.Close()
+		reader = decoder
+	}
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	return content
+}
+
+func TestClient_CompressRequests(t *testing.T) {
+	large := []byte(`{"items":"` + strings.Repeat("an item ", 500) + `"}`)
+
+	for _, encoding := range []string{"gzip", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, encoding, r.Header.Get("Content-Encoding"))
+				assert.Less(t, r.ContentLength, int64(len(large)))
+				assert.Equal(t, large, decompressed(t, r))
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			client := NewClient(server.Client(), WithCompression(CompressionConfig{Encoding: encoding}))
+			resp, err := client.Post(context.Background(), Payload{URL: server.URL, Content: large})
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+
+	t.Run("streamed body is compressed while it's sent", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+			assert.Equal(t, int64(-1), r.ContentLength)
+			assert.Equal(t, large, decompressed(t, r))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCompression(CompressionConfig{Encoding: "gzip"}))
+		resp, err := client.Post(context.Background(), Payload{URL: server.URL, Body: io.MultiReader(bytes.NewReader(large))})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("bodies below the minimum size and encoded bodies are sent as they are", func(t *testing.T) {
+		var encodings []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encodings = append(encodings, r.Header.Get("Content-Encoding"))
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCompression(CompressionConfig{Encoding: "gzip", MinSize: 100}))
+		_, err := client.Post(context.Background(), Payload{URL: server.URL, Content: []byte(`{"name":"an item"}`)})
+		assert.NoError(t, err)
+		_, err = client.Post(context.Background(), Payload{
+			URL:     server.URL,
+			Headers: map[string]string{"Content-Encoding": "br"},
+			Content: compressed(t, "br", large),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"", "br"}, encodings)
+	})
+
+	t.Run("compressed body is retried", func(t *testing.T) {
+		attempts := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			assert.Equal(t, large, decompressed(t, r))
+			if attempts == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCompression(CompressionConfig{Encoding: "zstd"}), WithRetryPolicy(testRetryPolicy()))
+		resp, err := client.Put(context.Background(), Payload{URL: server.URL, Content: large})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 2, attempts)
+	})
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		client := NewClient(&http.Client{}, WithCompression(CompressionConfig{Encoding: "br"}))
+		_, err := client.Post(context.Background(), Payload{URL: "http://example.com", Content: large})
+		assert.ErrorContains(t, err, `failed to compress request body: unsupported encoding "br"`)
+	})
+}
+
+func TestClient_DecompressResponses(t *testing.T) {
+	content := []byte(`{"id":1,"name":"` + strings.Repeat("a", 1000) + `"}`)
+
+	for encoding, body := range map[string][]byte{
+		"gzip":    compressed(t, "gzip", content),
+		"deflate": compressed(t, "zlib", content),
+		"br":      compressed(t, "br", content),
+		"zstd":    compressed(t, "zstd", content),
+	} {
+		t.Run(encoding, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "gzip, deflate, br, zstd", r.Header.Get("Accept-Encoding"))
+				w.Header().Set("Content-Encoding", encoding)
+				_, _ = w.Write(body)
+			}))
+			defer server.Close()
+
+			client := NewClient(server.Client(), WithCompression(CompressionConfig{}))
+			resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+			assert.NoError(t, err)
+			assert.Empty(t, resp.Header.Get("Content-Encoding"))
+			assert.True(t, resp.Uncompressed)
+			received, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, content, received)
+			assert.NoError(t, resp.Body.Close())
+		})
+	}
+
+	t.Run("raw deflate", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "deflate")
+			_, _ = w.Write(compressed(t, "deflate", content))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCompression(CompressionConfig{}))
+		item, err := GetJSON[item](context.Background(), client, Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, item.ID)
+	})
+
+	t.Run("decompression bomb", func(t *testing.T) {
+		bomb := compressed(t, "gzip", make([]byte, 10<<20))
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(bomb)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCompression(CompressionConfig{MaxDecompressedSize: 1 << 20}))
+		resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		received, err := io.ReadAll(resp.Body)
+		assert.ErrorIs(t, err, ErrDecompressedTooLarge)
+		assert.Len(t, received, 1<<20)
+	})
+
+	t.Run("empty and invalid bodies", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			if r.Method == http.MethodGet {
+				_, _ = w.Write([]byte("not gzip"))
+			}
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithCompression(CompressionConfig{}))
+		resp, err := client.Head(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		received, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Empty(t, received)
+
+		resp, err = client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+		assert.ErrorContains(t, err, "failed to decompress gzip response body")
+	})
+}
+
+func TestNewClient_CompressionFromEnv(t *testing.T) {
+	os.Setenv("HTTP_COMPRESSION_ENABLED", "true")
+	os.Setenv("HTTP_REQUEST_ENCODING", "zstd")
+	defer os.Unsetenv("HTTP_COMPRESSION_ENABLED")
+	defer os.Unsetenv("HTTP_REQUEST_ENCODING")
+
+	client := NewClient(&http.Client{})
+	assert.Equal(t, &CompressionConfig{Encoding: "zstd", MinSize: 1024, MaxDecompressedSize: 32 << 20}, client.compression)
+}
@@ -1,17 +1,299 @@
 package http
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/joho/godotenv"
 	anysherlog "github.com/narumayase/anysher/log"
+	"github.com/rs/zerolog/log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// tlsVersions are the accepted values of HTTP_MIN_TLS_VERSION.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
 // Config contains the application configuration for HTTP.
-// required environment variable is LOG_LEVEL.
+// It's used by NewClientFromEnv to build the transport of the http.Client.
 type Config struct {
+	timeout               time.Duration
+	connectTimeout        time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	idleConnTimeout       time.Duration
+	maxIdleConns          int
+	maxIdleConnsPerHost   int
+	maxConnsPerHost       int
+	proxyURL              string
+	caFile                string
+	certFile              string
+	keyFile               string
+	minTLSVersion         string
+	compressionEnabled    bool
+	requestEncoding       string
+	compressionMinSize    int
+	maxDecompressedSize   int
+}
+
+// load loads the configuration of every Client from environment variables or an .env file
+// It takes the configuration from environment variables:
+// - HTTP_COMPRESSION_ENABLED -> compresses the request bodies and decompresses the responses
+// - HTTP_REQUEST_ENCODING -> gzip, zstd or identity
+// - HTTP_COMPRESSION_MIN_SIZE -> size in bytes from which request bodies are compressed
+// - HTTP_MAX_DECOMPRESSED_SIZE -> maximum size in bytes of a decompressed response body
+// - LOG_LEVEL
+// The values that can't be parsed keep their defaults and are returned as an error. An invalid
+// HTTP_REQUEST_ENCODING disables the compression.
+func load() (Config, error) {
+	// Load .env file if it exists (ignore error if file doesn't exist)
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file found or error loading .env file: %v", err)
+	}
+	var errs []error
+	config := Config{
+		compressionEnabled:  getEnvAsBool("HTTP_COMPRESSION_ENABLED", false),
+		requestEncoding:     getEnv("HTTP_REQUEST_ENCODING", "gzip"),
+		compressionMinSize:  getEnvAsInt("HTTP_COMPRESSION_MIN_SIZE", 1<<10, &errs),
+		maxDecompressedSize: getEnvAsInt("HTTP_MAX_DECOMPRESSED_SIZE", 32<<20, &errs),
+	}
+	if config.compressionEnabled {
+		switch config.requestEncoding {
+		case "gzip", "zstd", "identity":
+		default:
+			errs = append(errs, fmt.Errorf("invalid HTTP_REQUEST_ENCODING %q, expected gzip, zstd or identity", config.requestEncoding))
+			config.compressionEnabled = false
+		}
+	}
+	anysherlog.SetLogLevel()
+	return config, errors.Join(errs...)
+}
+
+// loadTransport loads the configuration of the http.Client built by NewClientFromEnv besides the one of load.
+// It takes the configuration from environment variables:
+// - HTTP_TIMEOUT -> overall timeout of a request, including reading the body, eg: 30s
+// - HTTP_CONNECT_TIMEOUT
+// - HTTP_TLS_HANDSHAKE_TIMEOUT
+// - HTTP_RESPONSE_HEADER_TIMEOUT
+// - HTTP_IDLE_CONN_TIMEOUT
+// - HTTP_MAX_IDLE_CONNS
+// - HTTP_MAX_IDLE_CONNS_PER_HOST
+// - HTTP_MAX_CONNS_PER_HOST
+// - HTTP_PROXY_URL -> when it's empty HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used
+// - HTTP_CA_FILE -> PEM bundle trusted besides the system roots
+// - HTTP_CERT_FILE and HTTP_KEY_FILE -> PEM client certificate for mTLS
+// - HTTP_MIN_TLS_VERSION -> 1.0, 1.1, 1.2 or 1.3
+// It returns the errors of load and of the values that can't be parsed.
+func loadTransport() (Config, error) {
+	config, err := load()
+	errs := []error{err}
+	config.timeout = getEnvAsDuration("HTTP_TIMEOUT", 30*time.Second, &errs)
+	config.connectTimeout = getEnvAsDuration("HTTP_CONNECT_TIMEOUT", 10*time.Second, &errs)
+	config.tlsHandshakeTimeout = getEnvAsDuration("HTTP_TLS_HANDSHAKE_TIMEOUT", 10*time.Second, &errs)
+	config.responseHeaderTimeout = getEnvAsDuration("HTTP_RESPONSE_HEADER_TIMEOUT", 0, &errs)
+	config.idleConnTimeout = getEnvAsDuration("HTTP_IDLE_CONN_TIMEOUT", 90*time.Second, &errs)
+	config.maxIdleConns = getEnvAsInt("HTTP_MAX_IDLE_CONNS", 100, &errs)
+	config.maxIdleConnsPerHost = getEnvAsInt("HTTP_MAX_IDLE_CONNS_PER_HOST", 10, &errs)
+	config.maxConnsPerHost = getEnvAsInt("HTTP_MAX_CONNS_PER_HOST", 0, &errs)
+	config.proxyURL = getEnv("HTTP_PROXY_URL", "")
+	config.caFile = getEnv("HTTP_CA_FILE", "")
+	config.certFile = getEnv("HTTP_CERT_FILE", "")
+	config.keyFile = getEnv("HTTP_KEY_FILE", "")
+	config.minTLSVersion = getEnv("HTTP_MIN_TLS_VERSION", "1.2")
+	return config, errors.Join(errs...)
+}
+
+// newHTTPClient builds the http.Client with the timeouts, connection pool, proxy and TLS of the configuration.
+func (c Config) newHTTPClient() (*http.Client, error) {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	proxy := http.ProxyFromEnvironment
+	if c.proxyURL != "" {
+		proxyURL, err := url.Parse(c.proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse HTTP_PROXY_URL: %w", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+	return &http.Client{
+		Timeout: c.timeout,
+		Transport: &http.Transport{
+			Proxy: proxy,
+			DialContext: (&net.Dialer{
+				Timeout:   c.connectTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   c.tlsHandshakeTimeout,
+			ResponseHeaderTimeout: c.responseHeaderTimeout,
+			IdleConnTimeout:       c.idleConnTimeout,
+			MaxIdleConns:          c.maxIdleConns,
+			MaxIdleConnsPerHost:   c.maxIdleConnsPerHost,
+			MaxConnsPerHost:       c.maxConnsPerHost,
+		},
+	}, nil
+}
+
+// tlsConfig returns the TLS configuration with the minimum version, the CA bundle and the client certificate.
+func (c Config) tlsConfig() (*tls.Config, error) {
+	minVersion, ok := tlsVersions[c.minTLSVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid HTTP_MIN_TLS_VERSION %q, expected 1.0, 1.1, 1.2 or 1.3", c.minTLSVersion)
+	}
+	config := &tls.Config{MinVersion: minVersion}
+
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HTTP_CA_FILE: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed to parse HTTP_CA_FILE %s: no PEM certificates found", c.caFile)
+		}
+		config.RootCAs = pool
+	}
+	if c.certFile != "" || c.keyFile != "" {
+		if c.certFile == "" || c.keyFile == "" {
+			return nil, fmt.Errorf("HTTP_CERT_FILE and HTTP_KEY_FILE must be set together")
+		}
+		certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
 }
 
-// NewConfiguration creates a new Config instance for HTTP implementation.
-// It takes the environment variable LOG_LEVEL
-func load() Config {
+// loadUpstream loads the configuration of the upstream from environment variables or an .env file.
+// It takes the configuration from environment variables prefixed with HTTP_UPSTREAM_<NAME>_, where NAME
+// is the name of the upstream in uppercase with - replaced by _:
+// - ENDPOINTS -> base URLs separated by pipe, eg: http://10.0.0.1:8080|http://10.0.0.2:8080
+// - FILE -> file with a base URL per line
+// - SRV -> SRV name, eg: _http._tcp.users.service.consul
+// - SRV_SCHEME -> scheme of the URLs of the SRV targets, http or https
+// - REFRESH_INTERVAL -> time between discoveries of FILE or SRV, 0 to discover them only once
+// - BALANCER -> round-robin, least-outstanding or consistent-hash
+// - HASH_HEADER -> header of the payload used as consistent hash key instead of its URL
+// - MAX_FAILURES -> consecutive failures that eject an endpoint
+// - EJECTION_TIME
+// - HEALTH_PATH -> path probed on every endpoint, eg: /health
+// - HEALTH_INTERVAL
+// - HEALTH_TIMEOUT
+// Exactly one of ENDPOINTS, FILE or SRV must be set.
+func loadUpstream(name string) (UpstreamConfig, error) {
+	// Load .env file if it exists (ignore error if file doesn't exist)
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file found or error loading .env file: %v", err)
+	}
+	prefix := "HTTP_UPSTREAM_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+	var errs []error
+	config := UpstreamConfig{
+		RefreshInterval: getEnvAsDuration(prefix+"REFRESH_INTERVAL", 30*time.Second, &errs),
+		Balancer:        Balancer(getEnv(prefix+"BALANCER", string(RoundRobin))),
+		MaxFailures:     getEnvAsInt(prefix+"MAX_FAILURES", 3, &errs),
+		EjectionTime:    getEnvAsDuration(prefix+"EJECTION_TIME", 30*time.Second, &errs),
+		HealthCheck: HealthCheck{
+			Path:     getEnv(prefix+"HEALTH_PATH", ""),
+			Interval: getEnvAsDuration(prefix+"HEALTH_INTERVAL", 10*time.Second, &errs),
+			Timeout:  getEnvAsDuration(prefix+"HEALTH_TIMEOUT", 2*time.Second, &errs),
+		},
+	}
+	if err := errors.Join(errs...); err != nil {
+		return UpstreamConfig{}, err
+	}
+	if header := getEnv(prefix+"HASH_HEADER", ""); header != "" {
+		config.HashKey = func(payload Payload) string {
+			if value, ok := payload.Headers[header]; ok {
+				return value
+			}
+			return payload.URL
+		}
+	}
+
+	endpoints := getEnv(prefix+"ENDPOINTS", "")
+	file := getEnv(prefix+"FILE", "")
+	srv := getEnv(prefix+"SRV", "")
+	sources := 0
+	for _, source := range []string{endpoints, file, srv} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return UpstreamConfig{}, fmt.Errorf("exactly one of %sENDPOINTS, %sFILE or %sSRV must be set", prefix, prefix, prefix)
+	}
+	switch {
+	case endpoints != "":
+		config.Endpoints = strings.Split(endpoints, "|")
+	case file != "":
+		config.Discovery = FileDiscovery(file)
+	default:
+		config.Discovery = SRVDiscovery(getEnv(prefix+"SRV_SCHEME", "http"), srv)
+	}
 	anysherlog.SetLogLevel()
-	return Config{}
+	return config, nil
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsInt retrieves environment variable with a default value, which is also returned when the
+// value can't be parsed, adding the error to errs
+func getEnvAsInt(key string, defaultValue int, errs *[]error) int {
+	if value := os.Getenv(key); value != "" {
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("error converting %s to int: %w", key, err))
+			return defaultValue
+		}
+		return intValue
+	}
+	return defaultValue
+}
+
+// getEnvAsBool gets an environment variable as a boolean or returns a default value
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		return strings.ToLower(value) == "true"
+	}
+	return defaultValue
+}
+
+// getEnvAsDuration retrieves environment variable as a duration, eg: 500ms or 30s, with a default value,
+// which is also returned when the value can't be parsed, adding the error to errs
+func getEnvAsDuration(key string, defaultValue time.Duration, errs *[]error) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("error converting %s to duration: %w", key, err))
+			return defaultValue
+		}
+		return duration
+	}
+	return defaultValue
 }
@@ -0,0 +1,298 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewConfiguration(t *testing.T) {
+	env := map[string]string{
+		"HTTP_TIMEOUT":                 "5s",
+		"HTTP_CONNECT_TIMEOUT":         "1s",
+		"HTTP_TLS_HANDSHAKE_TIMEOUT":   "2s",
+		"HTTP_RESPONSE_HEADER_TIMEOUT": "3s",
+		"HTTP_IDLE_CONN_TIMEOUT":       "1m",
+		"HTTP_MAX_IDLE_CONNS":          "50",
+		"HTTP_MAX_IDLE_CONNS_PER_HOST": "5",
+		"HTTP_MAX_CONNS_PER_HOST":      "20",
+		"HTTP_PROXY_URL":               "http://proxy:3128",
+		"HTTP_CA_FILE":                 "ca.pem",
+		"HTTP_CERT_FILE":               "cert.pem",
+		"HTTP_KEY_FILE":                "key.pem",
+		"HTTP_MIN_TLS_VERSION":         "1.3",
+		"HTTP_COMPRESSION_ENABLED":     "true",
+		"HTTP_REQUEST_ENCODING":        "zstd",
+		"HTTP_COMPRESSION_MIN_SIZE":    "512",
+		"HTTP_MAX_DECOMPRESSED_SIZE":   "1048576",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	expectedCfg := Config{
+		timeout:               5 * time.Second,
+		connectTimeout:        time.Second,
+		tlsHandshakeTimeout:   2 * time.Second,
+		responseHeaderTimeout: 3 * time.Second,
+		idleConnTimeout:       time.Minute,
+		maxIdleConns:          50,
+		maxIdleConnsPerHost:   5,
+		maxConnsPerHost:       20,
+		proxyURL:              "http://proxy:3128",
+		caFile:                "ca.pem",
+		certFile:              "cert.pem",
+		keyFile:               "key.pem",
+		minTLSVersion:         "1.3",
+		compressionEnabled:    true,
+		requestEncoding:       "zstd",
+		compressionMinSize:    512,
+		maxDecompressedSize:   1 << 20,
+	}
+	cfg, err := loadTransport()
+	assert.NoError(t, err)
+	assert.Equal(t, expectedCfg, cfg)
+}
+
+func TestNewConfiguration_Defaults(t *testing.T) {
+	cfg, err := loadTransport()
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, cfg.timeout)
+	assert.Equal(t, 10*time.Second, cfg.connectTimeout)
+	assert.Equal(t, 10*time.Second, cfg.tlsHandshakeTimeout)
+	assert.Equal(t, time.Duration(0), cfg.responseHeaderTimeout)
+	assert.Equal(t, 100, cfg.maxIdleConns)
+	assert.Equal(t, 10, cfg.maxIdleConnsPerHost)
+	assert.Equal(t, "1.2", cfg.minTLSVersion)
+	assert.False(t, cfg.compressionEnabled)
+	assert.Equal(t, "gzip", cfg.requestEncoding)
+	assert.Equal(t, 1024, cfg.compressionMinSize)
+	assert.Equal(t, 32<<20, cfg.maxDecompressedSize)
+}
+
+func TestNewConfiguration_InvalidRequestEncoding(t *testing.T) {
+	os.Setenv("HTTP_COMPRESSION_ENABLED", "true")
+	os.Setenv("HTTP_REQUEST_ENCODING", "br")
+	defer os.Unsetenv("HTTP_COMPRESSION_ENABLED")
+	defer os.Unsetenv("HTTP_REQUEST_ENCODING")
+
+	cfg, err := load()
+	assert.ErrorContains(t, err, "HTTP_REQUEST_ENCODING")
+	assert.False(t, cfg.compressionEnabled)
+
+	_, err = NewClientFromEnv()
+	assert.ErrorContains(t, err, "HTTP_REQUEST_ENCODING")
+
+	// the client without the environment transport settings doesn't compress
+	var client *Client
+	assert.NotPanics(t, func() { client = NewClient(&http.Client{}) })
+	assert.Nil(t, client.compression)
+}
+
+func TestNewConfiguration_InvalidValues(t *testing.T) {
+	for key, value := range map[string]string{
+		"HTTP_TIMEOUT":              "thirty",
+		"HTTP_IDLE_CONN_TIMEOUT":    "30",
+		"HTTP_MAX_IDLE_CONNS":       "many",
+		"HTTP_COMPRESSION_MIN_SIZE": "1kb",
+	} {
+		os.Setenv(key, value)
+
+		cfg, err := loadTransport()
+		assert.ErrorContains(t, err, key)
+		assert.Equal(t, 30*time.Second, cfg.timeout, key)
+		assert.Equal(t, 100, cfg.maxIdleConns, key)
+
+		client, err := NewClientFromEnv()
+		assert.ErrorContains(t, err, key)
+		assert.Nil(t, client)
+
+		assert.NotPanics(t, func() { NewClient(&http.Client{}) }, key)
+		os.Unsetenv(key)
+	}
+}
+
+func TestConfig_NewHTTPClient(t *testing.T) {
+	t.Run("transport settings", func(t *testing.T) {
+		cfg, _ := loadTransport()
+		cfg.proxyURL = "http://proxy:3128"
+		cfg.minTLSVersion = "1.3"
+
+		client, err := cfg.newHTTPClient()
+		assert.NoError(t, err)
+		assert.Equal(t, 30*time.Second, client.Timeout)
+		transport := client.Transport.(*http.Transport)
+		assert.Equal(t, 10, transport.MaxIdleConnsPerHost)
+		assert.Equal(t, uint16(tls.VersionTLS13), transport.TLSClientConfig.MinVersion)
+		proxy, err := transport.Proxy(httptest.NewRequest("GET", "http://example.com", nil))
+		assert.NoError(t, err)
+		assert.Equal(t, "http://proxy:3128", proxy.String())
+	})
+
+	t.Run("invalid settings", func(t *testing.T) {
+		for name, modify := range map[string]func(*Config){
+			"tls version":      func(c *Config) { c.minTLSVersion = "2.0" },
+			"proxy":            func(c *Config) { c.proxyURL = "://proxy" },
+			"missing ca":       func(c *Config) { c.caFile = "missing.pem" },
+			"key without cert": func(c *Config) { c.keyFile = "key.pem" },
+		} {
+			cfg, _ := loadTransport()
+			modify(&cfg)
+			_, err := cfg.newHTTPClient()
+			assert.Error(t, err, name)
+		}
+	})
+
+	t.Run("custom CA and client certificate", func(t *testing.T) {
+		dir := t.TempDir()
+		serverCert, serverCertFile, _ := writeCertificate(t, dir, "server")
+		clientCert, clientCertFile, clientKeyFile := writeCertificate(t, dir, "client")
+
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(clientCert.Leaf)
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Len(t, r.TLS.PeerCertificates, 1)
+			w.WriteHeader(http.StatusOK)
+		}))
+		server.TLS = &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    clientCAs,
+		}
+		server.StartTLS()
+		defer server.Close()
+
+		os.Setenv("HTTP_CA_FILE", serverCertFile)
+		os.Setenv("HTTP_CERT_FILE", clientCertFile)
+		os.Setenv("HTTP_KEY_FILE", clientKeyFile)
+		defer os.Unsetenv("HTTP_CA_FILE")
+		defer os.Unsetenv("HTTP_CERT_FILE")
+		defer os.Unsetenv("HTTP_KEY_FILE")
+
+		client, err := NewClientFromEnv()
+		assert.NoError(t, err)
+		resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+	})
+}
+
+func TestNewClientFromEnv_InvalidConfig(t *testing.T) {
+	os.Setenv("HTTP_MIN_TLS_VERSION", "1.4")
+	defer os.Unsetenv("HTTP_MIN_TLS_VERSION")
+
+	client, err := NewClientFromEnv()
+	assert.Error(t, err)
+	assert.Nil(t, client)
+	assert.Contains(t, err.Error(), "failed to create HTTP client")
+}
+
+// writeCertificate creates a self-signed certificate for 127.0.0.1 and writes it and its key as PEM files.
+func writeCertificate(t *testing.T, dir, name string) (tls.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	assert.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.NoError(t, err)
+	certificate.Leaf, err = x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return certificate, certFile, keyFile
+}
+
+func TestLoadUpstream(t *testing.T) {
+	env := map[string]string{
+		"HTTP_UPSTREAM_USER_SERVICE_ENDPOINTS":     "http://a:8080|http://b:8080",
+		"HTTP_UPSTREAM_USER_SERVICE_BALANCER":      "consistent-hash",
+		"HTTP_UPSTREAM_USER_SERVICE_HASH_HEADER":   "X-User-Id",
+		"HTTP_UPSTREAM_USER_SERVICE_MAX_FAILURES":  "5",
+		"HTTP_UPSTREAM_USER_SERVICE_EJECTION_TIME": "1m",
+		"HTTP_UPSTREAM_USER_SERVICE_HEALTH_PATH":   "/health",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	cfg, err := loadUpstream("user-service")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://a:8080", "http://b:8080"}, cfg.Endpoints)
+	assert.Nil(t, cfg.Discovery)
+	assert.Equal(t, ConsistentHash, cfg.Balancer)
+	assert.Equal(t, 5, cfg.MaxFailures)
+	assert.Equal(t, time.Minute, cfg.EjectionTime)
+	assert.Equal(t, HealthCheck{Path: "/health", Interval: 10 * time.Second, Timeout: 2 * time.Second}, cfg.HealthCheck)
+	assert.Equal(t, "42", cfg.HashKey(Payload{URL: "/users", Headers: map[string]string{"X-User-Id": "42"}}))
+	assert.Equal(t, "/users", cfg.HashKey(Payload{URL: "/users"}))
+}
+
+func TestNewUpstreamFromEnv(t *testing.T) {
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "endpoints")
+		assert.NoError(t, os.WriteFile(path, []byte("http://a:8080\n"), 0o600))
+		os.Setenv("HTTP_UPSTREAM_ITEMS_FILE", path)
+		os.Setenv("HTTP_UPSTREAM_ITEMS_REFRESH_INTERVAL", "0")
+		defer os.Unsetenv("HTTP_UPSTREAM_ITEMS_FILE")
+		defer os.Unsetenv("HTTP_UPSTREAM_ITEMS_REFRESH_INTERVAL")
+
+		upstream, err := NewUpstreamFromEnv("items")
+		assert.NoError(t, err)
+		defer upstream.Close()
+		assert.Equal(t, "items", upstream.Name())
+		assert.Equal(t, []Endpoint{{URL: "http://a:8080", Healthy: true}}, upstream.Endpoints())
+	})
+
+	t.Run("without endpoints", func(t *testing.T) {
+		upstream, err := NewUpstreamFromEnv("items")
+		assert.Nil(t, upstream)
+		assert.ErrorContains(t, err, "exactly one of HTTP_UPSTREAM_ITEMS_ENDPOINTS, HTTP_UPSTREAM_ITEMS_FILE or HTTP_UPSTREAM_ITEMS_SRV must be set")
+	})
+
+	t.Run("invalid balancer", func(t *testing.T) {
+		os.Setenv("HTTP_UPSTREAM_ITEMS_ENDPOINTS", "http://a:8080")
+		os.Setenv("HTTP_UPSTREAM_ITEMS_BALANCER", "random")
+		defer os.Unsetenv("HTTP_UPSTREAM_ITEMS_ENDPOINTS")
+		defer os.Unsetenv("HTTP_UPSTREAM_ITEMS_BALANCER")
+
+		_, err := NewUpstreamFromEnv("items")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,74 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// lookupSRV resolves the SRV records, it's replaced in tests.
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// Discovery finds the base URLs of the endpoints of an upstream.
+type Discovery interface {
+	Discover(ctx context.Context) ([]string, error)
+}
+
+// DiscoveryFunc is a function that implements Discovery.
+type DiscoveryFunc func(ctx context.Context) ([]string, error)
+
+// Discover calls the function.
+func (f DiscoveryFunc) Discover(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+// FileDiscovery reads the endpoints from a file with a base URL per line.
+// Empty lines and lines starting with # are ignored.
+func FileDiscovery(path string) Discovery {
+	return DiscoveryFunc(func(ctx context.Context) ([]string, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open endpoints file: %w", err)
+		}
+		defer file.Close()
+
+		var endpoints []string
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			endpoints = append(endpoints, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read endpoints file: %w", err)
+		}
+		return endpoints, nil
+	})
+}
+
+// SRVDiscovery looks up the SRV records of the name, eg: _http._tcp.users.service.consul, and returns
+// a base URL with the scheme for each target of the lowest priority.
+func SRVDiscovery(scheme, name string) Discovery {
+	return DiscoveryFunc(func(ctx context.Context) ([]string, error) {
+		_, records, err := lookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up SRV records of %s: %w", name, err)
+		}
+		var endpoints []string
+		for _, record := range records {
+			// the records are sorted by priority
+			if record.Priority != records[0].Priority {
+				break
+			}
+			host := strings.TrimSuffix(record.Target, ".")
+			endpoints = append(endpoints, scheme+"://"+net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+		}
+		return endpoints, nil
+	})
+}
@@ -0,0 +1,137 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+)
+
+// defaultMaxErrorBody is the maximum error body size kept by the StatusError of WithStatusErrors.
+const defaultMaxErrorBody = 64 << 10
+
+// StatusError is returned by the JSON helpers, the streams and, with WithStatusErrors, by every request
+// when the response status code isn't 2xx.
+// Err holds the error body decoded into the type given with WithErrorType, if any.
+// URL is the request URL without its query, which may carry credentials.
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+	Header     http.Header
+	Method     string
+	URL        string
+	Err        error
+	// retryableStatusCodes are the RetryableStatusCodes of the retry policy of the Client that got the response
+	retryableStatusCodes []int
+}
+
+// newStatusError creates the StatusError of the response with the body already read.
+func (c *Client) newStatusError(resp *http.Response, body []byte) *StatusError {
+	statusErr := &StatusError{
+		StatusCode:           resp.StatusCode,
+		Body:                 body,
+		Header:               resp.Header,
+		retryableStatusCodes: c.retry.RetryableStatusCodes,
+	}
+	if resp.Request != nil {
+		statusErr.Method = resp.Request.Method
+		statusErr.URL = safeURL(resp.Request.URL)
+	}
+	return statusErr
+}
+
+// Error returns the request, the status code and the error body.
+func (e *StatusError) Error() string {
+	message := fmt.Sprintf("unexpected status code %d: %s", e.StatusCode, string(e.Body))
+	if e.Err != nil {
+		message = fmt.Sprintf("unexpected status code %d: %v", e.StatusCode, e.Err)
+	}
+	if e.Method != "" {
+		return fmt.Sprintf("%s %s: %s", e.Method, e.URL, message)
+	}
+	return message
+}
+
+// Unwrap returns the decoded error body so it can be matched with errors.As.
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable tells if the request may succeed when it's sent again, that is, when the status code
+// is one of the RetryableStatusCodes of the retry policy of the Client, or of the DefaultRetryPolicy
+// when the policy has none.
+func (e *StatusError) Retryable() bool {
+	codes := e.retryableStatusCodes
+	if codes == nil {
+		codes = DefaultRetryPolicy().RetryableStatusCodes
+	}
+	return slices.Contains(codes, e.StatusCode)
+}
+
+// WithStatusErrors makes the Client return a *StatusError instead of the response when its status code
+// isn't 2xx. The response body is closed and up to 64KB of it are kept in the error.
+func WithStatusErrors() Option {
+	return func(c *Client) {
+		c.statusErrors = true
+	}
+}
+
+// checkStatus returns the response when it's 2xx, otherwise it closes it and returns its StatusError.
+func (c *Client) checkStatus(resp *http.Response) (*http.Response, error) {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, defaultMaxErrorBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return nil, c.newStatusError(resp, body)
+}
+
+// StatusCode returns the status code of the *StatusError in the chain of err, or 0 when there's none.
+func StatusCode(err error) int {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode
+	}
+	return 0
+}
+
+// IsRetryable tells if err is a *StatusError that may succeed when the request is sent again.
+func IsRetryable(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Retryable()
+}
+
+// IsNotFound tells if err is a *StatusError with a 404 Not Found status code.
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+// IsThrottled tells if err is a *StatusError with a 429 Too Many Requests status code.
+func IsThrottled(err error) bool {
+	return StatusCode(err) == http.StatusTooManyRequests
+}
+
+// IsUnauthorized tells if err is a *StatusError with a 401 Unauthorized status code.
+func IsUnauthorized(err error) bool {
+	return StatusCode(err) == http.StatusUnauthorized
+}
+
+// IsForbidden tells if err is a *StatusError with a 403 Forbidden status code.
+func IsForbidden(err error) bool {
+	return StatusCode(err) == http.StatusForbidden
+}
+
+// IsConflict tells if err is a *StatusError with a 409 Conflict status code.
+func IsConflict(err error) bool {
+	return StatusCode(err) == http.StatusConflict
+}
+
+// IsServerError tells if err is a *StatusError with a 5xx status code.
+func IsServerError(err error) bool {
+	code := StatusCode(err)
+	return code >= 500 && code <= 599
+}
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_WithStatusErrors(t *testing.T) {
+	t.Run("non-2xx responses are returned as StatusError", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Error-Id", "an-error")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message":"not found"}`))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithStatusErrors())
+		resp, err := client.Post(context.Background(), Payload{URL: server.URL + "/items/1?api_key=secret"})
+		assert.Nil(t, resp)
+
+		var statusErr *StatusError
+		assert.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+		assert.Equal(t, `{"message":"not found"}`, string(statusErr.Body))
+		assert.Equal(t, "an-error", statusErr.Header.Get("X-Error-Id"))
+		assert.Equal(t, http.MethodPost, statusErr.Method)
+		assert.Equal(t, server.URL+"/items/1", statusErr.URL)
+		assert.False(t, statusErr.Retryable())
+		assert.True(t, IsNotFound(err))
+		assert.Equal(t, fmt.Sprintf(`POST %s/items/1: unexpected status code 404: {"message":"not found"}`, server.URL), err.Error())
+	})
+
+	t.Run("2xx responses are returned", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithStatusErrors())
+		resp, err := client.Delete(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+
+	t.Run("the body is capped", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(strings.Repeat("a", defaultMaxErrorBody+10)))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithStatusErrors())
+		_, err := client.Get(context.Background(), Payload{URL: server.URL})
+
+		var statusErr *StatusError
+		assert.ErrorAs(t, err, &statusErr)
+		assert.Len(t, statusErr.Body, defaultMaxErrorBody)
+		assert.True(t, IsRetryable(err))
+		assert.True(t, IsServerError(err))
+	})
+
+	t.Run("retryable follows the retry policy of the client", func(t *testing.T) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			if r.URL.Path == "/unavailable" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		policy := RetryPolicy{MaxAttempts: 2, RetryableStatusCodes: []int{http.StatusInternalServerError}}
+		client := NewClient(server.Client(), WithStatusErrors(), WithRetryPolicy(policy))
+
+		_, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.True(t, IsRetryable(err))
+		assert.Equal(t, int32(2), calls.Load())
+
+		_, err = client.Get(context.Background(), Payload{URL: server.URL + "/unavailable"})
+		assert.True(t, IsServerError(err))
+		assert.False(t, IsRetryable(err))
+		assert.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("the error type of the JSON helpers is decoded", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"code":"conflict","message":"already exists"}`))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithStatusErrors())
+		_, err := GetJSON[item](context.Background(), client, Payload{URL: server.URL}, WithErrorType[*apiError]())
+
+		assert.True(t, IsConflict(err))
+		var apiErr *apiError
+		assert.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, "conflict", apiErr.Code)
+	})
+
+	t.Run("the event stream stops", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithStatusErrors())
+		for _, err := range client.Events(context.Background(), Payload{URL: server.URL}) {
+			assert.True(t, IsThrottled(err))
+		}
+	})
+}
+
+func TestStatusErrorPredicates(t *testing.T) {
+	wrap := func(code int) error {
+		return fmt.Errorf("failed to call the API: %w", &StatusError{StatusCode: code})
+	}
+
+	assert.True(t, IsNotFound(wrap(http.StatusNotFound)))
+	assert.True(t, IsThrottled(wrap(http.StatusTooManyRequests)))
+	assert.True(t, IsUnauthorized(wrap(http.StatusUnauthorized)))
+	assert.True(t, IsForbidden(wrap(http.StatusForbidden)))
+	assert.True(t, IsConflict(wrap(http.StatusConflict)))
+	assert.True(t, IsServerError(wrap(http.StatusBadGateway)))
+	assert.True(t, IsRetryable(wrap(http.StatusServiceUnavailable)))
+	assert.False(t, IsRetryable(wrap(http.StatusBadRequest)))
+	assert.Equal(t, http.StatusTeapot, StatusCode(wrap(http.StatusTeapot)))
+
+	assert.False(t, IsNotFound(errors.New("not found")))
+	assert.False(t, IsNotFound(nil))
+	assert.Equal(t, 0, StatusCode(nil))
+}
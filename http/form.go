@@ -0,0 +1,194 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WithForm returns a copy of the payload whose content is the application/x-www-form-urlencoded form.
+func (p Payload) WithForm(values url.Values) Payload {
+	p.Content = []byte(values.Encode())
+	p.Headers = withHeader(p.Headers, "Content-Type", "application/x-www-form-urlencoded")
+	return p
+}
+
+// redactedContent returns the content of the payload to log, with the denied fields of JSON and
+// application/x-www-form-urlencoded bodies masked, eg: the client_secret of an OAuth2 token request.
+func (c *Client) redactedContent(payload Payload) string {
+	var contentType string
+	for key, value := range payload.Headers {
+		if strings.EqualFold(key, "Content-Type") {
+			contentType = value
+		}
+	}
+	return c.redactor.Body(c.redactor.Content(contentType, payload.Content))
+}
+
+// WithMultipart returns a copy of the payload whose body streams the multipart/form-data form.
+// The form is replayed on retries only when all its files are read from paths.
+func (p Payload) WithMultipart(form *Multipart) Payload {
+	p.Body = &lazyBody{open: form.reader}
+	p.Content = nil
+	p.ContentLength = 0
+	p.GetBody = nil
+	if form.replayable() {
+		p.GetBody = func() (io.ReadCloser, error) {
+			return &lazyBody{open: form.reader}, nil
+		}
+	}
+	headers := make(map[string]string, len(p.Headers)+1)
+	for key, value := range p.Headers {
+		if !strings.EqualFold(key, "Content-Type") {
+			headers[key] = value
+		}
+	}
+	headers["Content-Type"] = form.contentType()
+	p.Headers = headers
+	return p
+}
+
+// Multipart builds a multipart/form-data form whose files are streamed instead of held in memory.
+type Multipart struct {
+	boundary string
+	parts    []multipartPart
+}
+
+type multipartPart struct {
+	field       string
+	value       string
+	filename    string
+	contentType string
+	path        string
+	content     io.Reader
+}
+
+// NewMultipart creates an empty multipart form.
+func NewMultipart() *Multipart {
+	// the boundary is fixed so every replay of the form is identical
+	return &Multipart{boundary: multipart.NewWriter(io.Discard).Boundary()}
+}
+
+// Field adds a text field to the form.
+func (m *Multipart) Field(name, value string) *Multipart {
+	m.parts = append(m.parts, multipartPart{field: name, value: value})
+	return m
+}
+
+// File adds a file read from the content to the form. An empty content type defaults to application/octet-stream.
+func (m *Multipart) File(field, filename, contentType string, content io.Reader) *Multipart {
+	m.parts = append(m.parts, multipartPart{field: field, filename: filename, contentType: contentType, content: content})
+	return m
+}
+
+// FileFromPath adds a file read from the path to the form. The file is opened when the form is sent.
+func (m *Multipart) FileFromPath(field, path, contentType string) *Multipart {
+	m.parts = append(m.parts, multipartPart{field: field, filename: filepath.Base(path), contentType: contentType, path: path})
+	return m
+}
+
+// replayable tells if the form can be streamed again.
+func (m *Multipart) replayable() bool {
+	for _, part := range m.parts {
+		if part.content != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// contentType returns the content type of the form with its boundary.
+func (m *Multipart) contentType() string {
+	return "multipart/form-data; boundary=" + m.boundary
+}
+
+// reader returns a reader that streams the form.
+func (m *Multipart) reader() io.ReadCloser {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	_ = writer.SetBoundary(m.boundary)
+
+	go func() {
+		err := m.write(writer)
+		if err == nil {
+			err = writer.Close()
+		}
+		// the reader gets the error, if any, or io.EOF
+		_ = pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// lazyBody starts streaming when it's read for the first time, so an unsent body doesn't hold resources.
+type lazyBody struct {
+	open func() io.ReadCloser
+	body io.ReadCloser
+}
+
+func (l *lazyBody) Read(p []byte) (int, error) {
+	if l.body == nil {
+		l.body = l.open()
+	}
+	return l.body.Read(p)
+}
+
+func (l *lazyBody) Close() error {
+	if l.body == nil {
+		return nil
+	}
+	return l.body.Close()
+}
+
+// write writes every part of the form.
+func (m *Multipart) write(writer *multipart.Writer) error {
+	for _, part := range m.parts {
+		if part.filename == "" {
+			if err := writer.WriteField(part.field, part.value); err != nil {
+				return err
+			}
+			continue
+		}
+		contentType := part.contentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			escapeQuotes(part.field), escapeQuotes(part.filename)))
+		header.Set("Content-Type", contentType)
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if err := copyPart(w, part); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", part.filename, err)
+		}
+	}
+	return nil
+}
+
+// copyPart copies the content of the file part into the writer.
+func copyPart(w io.Writer, part multipartPart) error {
+	if part.content != nil {
+		_, err := io.Copy(w, part.content)
+		return err
+	}
+	file, err := os.Open(part.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
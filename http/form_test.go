@@ -0,0 +1,137 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayload_WithForm(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-www-form-urlencoded", r.Header.Get("Content-Type"))
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "a value", r.PostForm.Get("field"))
+		assert.Equal(t, []string{"1", "2"}, r.PostForm["list"])
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.Client())
+	payload := Payload{URL: server.URL}.WithForm(url.Values{"field": {"a value"}, "list": {"1", "2"}})
+	resp, err := client.Post(context.Background(), payload)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestPayload_WithForm_RedactsLogs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "a-secret-password", r.PostForm.Get("password"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.Client())
+
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer zerolog.SetGlobalLevel(level)
+
+	var logs bytes.Buffer
+	ctx := zerolog.New(&logs).WithContext(context.Background())
+
+	payload := Payload{URL: server.URL}.WithForm(url.Values{
+		"username":      {"a-user"},
+		"password":      {"a-secret-password"},
+		"client_secret": {"a-client-secret"},
+	})
+	_, err := client.Post(ctx, payload)
+	assert.NoError(t, err)
+	assert.Contains(t, logs.String(), "a-user")
+	assert.Contains(t, logs.String(), "password=%5BREDACTED%5D")
+	assert.NotContains(t, logs.String(), "a-secret-password")
+	assert.NotContains(t, logs.String(), "a-client-secret")
+}
+
+func TestPayload_WithMultipart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.csv")
+	assert.NoError(t, os.WriteFile(path, []byte("a,b\n1,2\n"), 0o600))
+
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseMultipartForm(1<<20))
+		assert.Equal(t, "a description", r.FormValue("description"))
+
+		file, header, err := r.FormFile("report")
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(file)
+		assert.Equal(t, "report.csv", header.Filename)
+		assert.Equal(t, "text/csv", header.Header.Get("Content-Type"))
+		assert.Equal(t, "a,b\n1,2\n", string(content))
+
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	form := NewMultipart().
+		Field("description", "a description").
+		FileFromPath("report", path, "text/csv")
+
+	client := NewClient(server.Client(), WithRetryPolicy(testRetryPolicy()))
+	payload := Payload{URL: server.URL, Headers: map[string]string{"Idempotency-Key": "1"}}.WithMultipart(form)
+	resp, err := client.Post(context.Background(), payload)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, int32(2), attempts.Load())
+}
+
+func TestPayload_WithMultipartReader(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		file, header, err := r.FormFile("upload")
+		assert.NoError(t, err)
+		content, _ := io.ReadAll(file)
+		assert.Equal(t, "streamed content", string(content))
+		assert.Equal(t, "application/octet-stream", header.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// a plain reader can't be replayed so the request isn't retried
+	form := NewMultipart().File("upload", "data.bin", "", io.MultiReader(strings.NewReader("streamed content")))
+	client := NewClient(server.Client(), WithRetryPolicy(testRetryPolicy()))
+	resp, err := client.Put(context.Background(), Payload{URL: server.URL}.WithMultipart(form))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), attempts.Load())
+}
+
+func TestMultipart_FileError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	form := NewMultipart().FileFromPath("report", filepath.Join(t.TempDir(), "missing.csv"), "")
+	client := NewClient(server.Client())
+	_, err := client.Post(context.Background(), Payload{URL: server.URL}.WithMultipart(form))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "missing.csv")
+}
@@ -0,0 +1,201 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// errHedgeLost is the cause of the cancellation of an attempt that lost the race against another one.
+var errHedgeLost = errors.New("another attempt answered first")
+
+// HedgingConfig defines when a read request is sent again while its first attempt hasn't answered.
+type HedgingConfig struct {
+	// Percentile is the percentile (0 to 1) of the recent latencies of the destination after which the
+	// hedged attempt is sent. It defaults to 0.95.
+	Percentile float64
+	// Delay is the wait before the hedged attempt until MinSamples latencies are known. It defaults to 100ms.
+	Delay time.Duration
+	// MinDelay is the minimum wait before the hedged attempt, so fast destinations aren't always hedged.
+	MinDelay time.Duration
+	// MinSamples is the number of latencies needed to use the Percentile. It defaults to 20.
+	MinSamples int
+	// WindowSize is the number of recent latencies kept for each destination. It defaults to 1000.
+	WindowSize int
+}
+
+// WithHedging makes the Client send a second attempt of GET and HEAD requests when the first one hasn't
+// answered after a percentile of the recent latencies of the destination, host or upstream.
+// The first response is kept and the other attempt is cancelled.
+func WithHedging(config HedgingConfig) Option {
+	return func(c *Client) {
+		c.hedging = newHedger(config)
+	}
+}
+
+// hedger keeps the recent latencies of each destination.
+type hedger struct {
+	config    HedgingConfig
+	mu        sync.Mutex
+	latencies map[string]*latencyWindow
+}
+
+// latencyWindow is a ring of the recent latencies of a destination.
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+// hedgedAttempt is the result of an attempt raced against another one.
+type hedgedAttempt struct {
+	attemptResult
+	index   int
+	latency time.Duration
+}
+
+func newHedger(config HedgingConfig) *hedger {
+	if config.Percentile <= 0 || config.Percentile > 1 {
+		config.Percentile = 0.95
+	}
+	if config.Delay <= 0 {
+		config.Delay = 100 * time.Millisecond
+	}
+	if config.MinSamples <= 0 {
+		config.MinSamples = 20
+	}
+	if config.WindowSize <= 0 {
+		config.WindowSize = 1000
+	}
+	config.MinSamples = min(config.MinSamples, config.WindowSize)
+	return &hedger{
+		config:    config,
+		latencies: make(map[string]*latencyWindow),
+	}
+}
+
+// delay returns the wait before hedging an attempt to the destination.
+func (h *hedger) delay(key string) time.Duration {
+	h.mu.Lock()
+	window, ok := h.latencies[key]
+	if !ok || len(window.samples) < h.config.MinSamples {
+		h.mu.Unlock()
+		return max(h.config.Delay, h.config.MinDelay)
+	}
+	samples := slices.Clone(window.samples)
+	h.mu.Unlock()
+
+	slices.Sort(samples)
+	i := int(math.Ceil(h.config.Percentile*float64(len(samples)))) - 1
+	return max(samples[max(i, 0)], h.config.MinDelay)
+}
+
+// observe records the latency of an attempt to the destination.
+func (h *hedger) observe(key string, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	window, ok := h.latencies[key]
+	if !ok {
+		window = &latencyWindow{}
+		h.latencies[key] = window
+	}
+	if len(window.samples) < h.config.WindowSize {
+		window.samples = append(window.samples, latency)
+		return
+	}
+	window.samples[window.next] = latency
+	window.next = (window.next + 1) % h.config.WindowSize
+}
+
+// hedge sends an attempt of the payload and, when it hasn't answered after the hedging delay, another one.
+// It returns the first response, or the last failure when both attempts fail, and cancels the other attempt.
+func (c *Client) hedge(ctx context.Context, payload Payload, rawURL string, upstream *Upstream, attempt int, avoid string) attemptResult {
+	key := destination(payload, rawURL)
+	delay := c.hedging.delay(key)
+
+	results := make(chan hedgedAttempt, 2)
+	var cancels []context.CancelCauseFunc
+	send := func() {
+		attemptCtx, cancel := context.WithCancelCause(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			start := time.Now()
+			result := c.try(attemptCtx, payload, rawURL, upstream, attempt, avoid)
+			results <- hedgedAttempt{attemptResult: result, index: index, latency: time.Since(start)}
+		}()
+	}
+	send()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending := 1
+	for {
+		select {
+		case <-timer.C:
+			log.Ctx(ctx).Debug().Msgf("attempt %d to %s %s hasn't answered after %s, hedging it",
+				attempt, payload.Method, safeRawURL(rawURL), delay)
+			send()
+			pending++
+		case result := <-results:
+			pending--
+			failed := result.req == nil || result.err != nil
+			if failed {
+				cancels[result.index](nil)
+				if pending > 0 {
+					// the other attempt may still answer
+					continue
+				}
+				return result.attemptResult
+			}
+			c.hedging.observe(key, result.latency)
+			for i, cancel := range cancels {
+				if i != result.index {
+					cancel(errHedgeLost)
+				}
+			}
+			// the attempt that lost is released when it finishes
+			for range pending {
+				go func() {
+					if lost := <-results; lost.resp != nil {
+						_ = lost.resp.Body.Close()
+					}
+				}()
+			}
+			// the context of the kept attempt lives until its body is closed
+			result.resp.Body = &cancelOnClose{ReadCloser: result.resp.Body, cancel: cancels[result.index]}
+			return result.attemptResult
+		}
+	}
+}
+
+// destination returns the upstream or the host the payload is sent to, the latencies are kept for each one.
+func destination(payload Payload, rawURL string) string {
+	if payload.Upstream != "" {
+		return upstreamURL(payload)
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Host
+	}
+	return rawURL
+}
+
+// cancelOnClose cancels the context of a response when its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelCauseFunc
+}
+
+// Close closes the body and cancels its context.
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
@@ -0,0 +1,113 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_Hedging(t *testing.T) {
+	t.Run("slow attempts are hedged and cancelled", func(t *testing.T) {
+		var requests atomic.Int32
+		cancelled := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) == 1 {
+				select {
+				case <-r.Context().Done():
+					close(cancelled)
+				case <-time.After(time.Second):
+				}
+				return
+			}
+			_, _ = w.Write([]byte("hedged"))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithHedging(HedgingConfig{Delay: 20 * time.Millisecond}))
+		start := time.Now()
+		resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.NoError(t, resp.Body.Close())
+		assert.Equal(t, "hedged", string(body))
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.Equal(t, int32(2), requests.Load())
+
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("the slow attempt wasn't cancelled")
+		}
+	})
+
+	t.Run("fast attempts aren't hedged", func(t *testing.T) {
+		server, requests := slowServer(t, "fast", 0)
+		client := NewClient(server.Client(), WithHedging(HedgingConfig{Delay: 200 * time.Millisecond}))
+
+		for range 3 {
+			resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+			assert.NoError(t, err)
+			resp.Body.Close()
+		}
+		assert.Equal(t, int32(3), requests.Load())
+	})
+
+	t.Run("writes aren't hedged", func(t *testing.T) {
+		server, requests := slowServer(t, "slow", 50*time.Millisecond)
+		client := NewClient(server.Client(), WithHedging(HedgingConfig{Delay: time.Millisecond}))
+
+		resp, err := client.Post(context.Background(), Payload{URL: server.URL, Content: []byte(`{}`)})
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("hedged attempts go to another endpoint, the slow one isn't a failure", func(t *testing.T) {
+		var slowRequests atomic.Int32
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			slowRequests.Add(1)
+			<-r.Context().Done()
+		}))
+		defer slow.Close()
+		fast := newReplica(t)
+		upstream, err := NewUpstream("items", UpstreamConfig{Endpoints: []string{slow.URL + "/api", fast.URL + "/api"}, MaxFailures: 1})
+		assert.NoError(t, err)
+		config := DefaultCircuitBreakerConfig()
+		config.MinimumRequests = 1
+		client := NewClient(&http.Client{}, WithUpstream(upstream), WithCircuitBreaker(config),
+			WithHedging(HedgingConfig{Delay: 20 * time.Millisecond}))
+
+		sendToUpstream(t, client, 1)
+		assert.Equal(t, int32(1), slowRequests.Load())
+		assert.Equal(t, int32(1), fast.requests.Load())
+		assert.Eventually(t, func() bool { return upstream.Endpoints()[0].Outstanding == 0 }, time.Second, 5*time.Millisecond)
+		assert.True(t, upstream.Endpoints()[0].Healthy)
+		assert.Equal(t, StateClosed, client.CircuitState(slow.Listener.Addr().String()))
+	})
+}
+
+func TestHedger_Delay(t *testing.T) {
+	h := newHedger(HedgingConfig{Percentile: 0.9, Delay: 50 * time.Millisecond, MinSamples: 10, WindowSize: 100})
+	assert.Equal(t, 50*time.Millisecond, h.delay("example.com"))
+
+	for i := 1; i <= 100; i++ {
+		h.observe("example.com", time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 90*time.Millisecond, h.delay("example.com"))
+	assert.Equal(t, 50*time.Millisecond, h.delay("another.com"))
+
+	// the oldest latencies are replaced
+	for range 100 {
+		h.observe("example.com", time.Millisecond)
+	}
+	assert.Equal(t, time.Millisecond, h.delay("example.com"))
+
+	h.config.MinDelay = 5 * time.Millisecond
+	assert.Equal(t, 5*time.Millisecond, h.delay("example.com"))
+}
@@ -4,67 +4,499 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"github.com/narumayase/anysher/propagation"
+	"github.com/narumayase/anysher/redact"
 	"github.com/rs/zerolog/log"
+	"io"
 	"net/http"
+	"net/url"
+	"time"
 )
 
 // Payload represents the structure of an HTTP request payload.
+// Method defaults to GET when it's empty. When BaseURL is set, URL is taken as a path relative to it,
+// unless URL is already an absolute URL. When Upstream is set, URL is taken as a path relative to an endpoint
+// of the upstream registered with that name, picked on every attempt, see WithUpstream. When Token is set, it's sent as a bearer token instead of
+// using the Authenticator of the Client. SkipCache bypasses the cache of the Client for this request.
+// Body streams the request content instead of holding it in Content, with ContentLength when it's known.
+// A Body is only replayed on retries when GetBody is set or when it implements io.Seeker, in which case
+// it isn't closed by the Client.
 type Payload struct {
-	URL     string
-	Token   string
-	Headers map[string]string
-	Content []byte
+	Method    string
+	BaseURL   string
+	Upstream  string
+	URL       string
+	Query     map[string]string
+	Token     string
+	Headers   map[string]string
+	Content   []byte
+	SkipCache bool
+
+	Body          io.Reader
+	ContentLength int64
+	GetBody       func() (io.ReadCloser, error)
+
+	// stream is set by Events and StreamJSON, whose responses are read while they arrive
+	stream bool
 }
 
 // Client represents an HTTP client with a base client and configuration.
 type Client struct {
 	client *http.Client
 	config Config
+	retry  RetryPolicy
+	auth   Authenticator
+
+	propagator *propagation.Propagator
+	redactor   *redact.Redactor
+	breakers   *circuitBreakers
+	limiter    RateLimiter
+	cache      *httpCache
+
+	compression  *CompressionConfig
+	coalescing   *coalescer
+	hedging      *hedger
+	upstreams    map[string]*Upstream
+	interceptors []Interceptor
+	statusErrors bool
+}
+
+// Option configures optional behavior of the Client.
+type Option func(*Client)
+
+// WithRetryPolicy makes the Client retry failed requests according to the given policy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithAuthenticator makes the Client authenticate every request with the given scheme.
+func WithAuthenticator(auth Authenticator) Option {
+	return func(c *Client) {
+		c.auth = auth
+	}
+}
+
+// WithPropagator replaces the Propagator that adds the IDs found in the context to every request.
+func WithPropagator(propagator *propagation.Propagator) Option {
+	return func(c *Client) {
+		c.propagator = propagator
+	}
+}
+
+// WithRedactor replaces the Redactor that masks secrets in the debug logs of the Client.
+func WithRedactor(redactor *redact.Redactor) Option {
+	return func(c *Client) {
+		c.redactor = redactor
+	}
+}
+
+// WithCircuitBreaker makes the Client keep a circuit breaker for each destination host.
+// Requests to a host whose circuit is open fail fast with ErrCircuitOpen.
+func WithCircuitBreaker(config CircuitBreakerConfig) Option {
+	return func(c *Client) {
+		c.breakers = newCircuitBreakers(config)
+	}
+}
+
+// WithRateLimiter makes the Client wait for the rate limiter before sending every request.
+func WithRateLimiter(limiter RateLimiter) Option {
+	return func(c *Client) {
+		c.limiter = limiter
+	}
+}
+
+// WithCache makes the Client cache GET responses in the store following the HTTP caching rules
+// of Cache-Control, Expires, ETag, Last-Modified and Vary.
+func WithCache(store CacheStore) Option {
+	return func(c *Client) {
+		c.cache = &httpCache{store: store}
+	}
 }
 
-// NewClient creates a new HTTP client with bearer token authentication.
-// It takes an http.Client and a Config struct as input.
-// It takes the environment variable LOG_LEVEL
-func NewClient(client *http.Client) *Client {
+// NewClient creates a new HTTP client.
+// It takes an http.Client and optional settings as input.
+// It takes the environment variables:
+// - LOG_LEVEL
+// - PROPAGATION_HEADERS -> headers propagated from the context, eg: X-Request-Id|X-Correlation-Id
+// - REDACT_HEADERS, REDACT_FIELDS and REDACT_MAX_BODY -> redaction of the debug logs
+// - HTTP_COMPRESSION_ENABLED, HTTP_REQUEST_ENCODING, HTTP_COMPRESSION_MIN_SIZE and HTTP_MAX_DECOMPRESSED_SIZE
+// -> compression of the bodies, see WithCompression
+// The invalid values are logged and replaced by their defaults.
+func NewClient(client *http.Client, opts ...Option) *Client {
 	// load configuration from environment
-	cfg := load()
+	cfg, err := load()
+	if err != nil {
+		log.Warn().Err(err).Msg("http: invalid configuration, using the defaults of the invalid values")
+	}
+	return newClient(client, cfg, opts...)
+}
+
+// NewClientFromEnv creates a new HTTP client with an http.Client built from the environment variables
+// of the timeouts, connection pool, proxy and TLS, see loadTransport, and optional settings.
+// It fails when a value can't be parsed or when the proxy URL, the CA bundle, the client certificate,
+// the TLS version or the request encoding are invalid.
+func NewClientFromEnv(opts ...Option) (*Client, error) {
+	// load configuration from environment
+	cfg, err := loadTransport()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP client: %w", err)
+	}
 
-	return &Client{
+	client, err := cfg.newHTTPClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP client: %w", err)
+	}
+	return newClient(client, cfg, opts...), nil
+}
+
+func newClient(client *http.Client, cfg Config, opts ...Option) *Client {
+	c := &Client{
 		client: client,
 		config: cfg,
+		retry:  RetryPolicy{MaxAttempts: 1},
+		auth:   NoAuth(),
+
+		propagator: propagation.NewPropagator(),
+		redactor:   redact.NewRedactor(),
+	}
+	if cfg.compressionEnabled {
+		c.compression = &CompressionConfig{
+			Encoding:            cfg.requestEncoding,
+			MinSize:             int64(cfg.compressionMinSize),
+			MaxDecompressedSize: int64(cfg.maxDecompressedSize),
+		}
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if auth, ok := c.auth.(apiKeyQuery); ok && client != nil {
+		if transport, ok := client.Transport.(queryRedactor); ok {
+			transport.RedactQuery(auth.param)
+		}
+	}
+	return c
+}
+
+// CircuitState returns the state of the circuit breaker of the host.
+// It's always StateClosed when the Client has no circuit breaker.
+func (c *Client) CircuitState(host string) CircuitState {
+	if c.breakers == nil {
+		return StateClosed
+	}
+	return c.breakers.State(host)
+}
+
+// Get sends a GET request.
+func (c *Client) Get(ctx context.Context, payload Payload) (*http.Response, error) {
+	payload.Method = http.MethodGet
+	return c.Do(ctx, payload)
 }
 
-// Post sends a POST request with JSON payload and bearer token authentication.
+// Post sends a POST request.
 // It takes a context and a Payload struct as input.
 // It returns the HTTP response and an error if the request fails.
 func (c *Client) Post(ctx context.Context, payload Payload) (*http.Response, error) {
-	payloadContent := payload.Content
-	url := payload.URL
-	headers := payload.Headers
+	payload.Method = http.MethodPost
+	return c.Do(ctx, payload)
+}
+
+// Put sends a PUT request.
+func (c *Client) Put(ctx context.Context, payload Payload) (*http.Response, error) {
+	payload.Method = http.MethodPut
+	return c.Do(ctx, payload)
+}
+
+// Patch sends a PATCH request.
+func (c *Client) Patch(ctx context.Context, payload Payload) (*http.Response, error) {
+	payload.Method = http.MethodPatch
+	return c.Do(ctx, payload)
+}
 
-	log.Ctx(ctx).Debug().Msgf("payload to send to %s: %s", url, string(payloadContent))
+// Delete sends a DELETE request.
+func (c *Client) Delete(ctx context.Context, payload Payload) (*http.Response, error) {
+	payload.Method = http.MethodDelete
+	return c.Do(ctx, payload)
+}
+
+// Head sends a HEAD request.
+func (c *Client) Head(ctx context.Context, payload Payload) (*http.Response, error) {
+	payload.Method = http.MethodHead
+	return c.Do(ctx, payload)
+}
+
+// Do sends a request using the method in the payload.
+// It takes a context and a Payload struct as input.
+// Failed attempts are retried according to the retry policy of the Client.
+// GET requests are served from the cache of the Client when it has one. Identical GET and HEAD requests
+// share a single response when the Client coalesces them, and their attempts are hedged when it hedges them.
+// It returns the HTTP response and an error if the request fails, or a *StatusError instead of
+// the response when the status code isn't 2xx and the Client was created WithStatusErrors.
+func (c *Client) Do(ctx context.Context, payload Payload) (*http.Response, error) {
+	if payload.Method == "" {
+		payload.Method = http.MethodGet
+	}
+	url, err := buildURL(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if payload.Body != nil {
+		log.Ctx(ctx).Debug().Msgf("payload to send to %s %s: streamed body of %d bytes",
+			payload.Method, safeRawURL(url), payload.ContentLength)
+	} else {
+		log.Ctx(ctx).Debug().Msgf("payload to send to %s %s: %s", payload.Method, safeRawURL(url), c.redactedContent(payload))
+	}
+
+	var resp *http.Response
+	if c.coalescing != nil && payload.readOnly() {
+		resp, err = c.coalescing.do(ctx, payload, url, func() (*http.Response, error) {
+			return c.fetch(ctx, payload, url)
+		})
+	} else {
+		resp, err = c.fetch(ctx, payload, url)
+	}
+	if err != nil || !c.statusErrors {
+		return resp, err
+	}
+	return c.checkStatus(resp)
+}
+
+// fetch returns the response of the payload from the cache of the Client, when it has one, or sends it.
+func (c *Client) fetch(ctx context.Context, payload Payload, url string) (*http.Response, error) {
+	if c.cache != nil {
+		return c.cache.do(ctx, c, payload, url)
+	}
+	return c.send(ctx, payload, url)
+}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payloadContent))
+// send executes the request of the payload, retrying failed attempts according to the retry policy.
+// Payloads sent to an upstream go to the endpoint picked for every attempt.
+func (c *Client) send(ctx context.Context, payload Payload, url string) (*http.Response, error) {
+	upstream, err := c.upstream(payload, url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	var previous string
+	maxAttempts := max(c.retry.MaxAttempts, 1)
+	// the query and the credentials of the URL may carry secrets, so they aren't logged
+	logURL := safeRawURL(url)
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && payload.Body != nil {
+			body, err := payload.rewind()
+			if err != nil {
+				return nil, fmt.Errorf("failed to replay request body: %w", err)
+			}
+			payload.Body = body
+		}
+		start := time.Now()
+		var result attemptResult
+		if c.hedging != nil && payload.readOnly() {
+			result = c.hedge(ctx, payload, url, upstream, attempt, previous)
+		} else {
+			result = c.try(ctx, payload, url, upstream, attempt, previous)
+		}
+		if result.req == nil {
+			return nil, result.err
+		}
+		req, resp, err := result.req, result.resp, result.err
+		previous = result.endpoint
+
+		retry := attempt < maxAttempts && c.retry.canRetry(req) && c.retry.shouldRetry(ctx, resp, err)
+		if retry && !payload.replayable() {
+			log.Ctx(ctx).Warn().Msgf("request body to %s %s can't be rewound, it won't be retried", payload.Method, logURL)
+			retry = false
+		}
+		var wait time.Duration
+		if retry {
+			var ok bool
+			if wait, ok = c.retry.backoff(attempt, resp); !ok {
+				log.Ctx(ctx).Warn().Msgf("Retry-After of %s %s exceeds the maximum backoff %s, it won't be retried",
+					payload.Method, logURL, c.retry.MaxBackoff)
+				retry = false
+			}
+		}
+		if !retry {
+			if err != nil {
+				log.Ctx(ctx).Err(safeError(err)).Msgf("Failed to send message to %s via HTTP: %v", logURL, resp)
+				return nil, fmt.Errorf("failed to execute request: %w", err)
+			}
+			log.Ctx(ctx).Info().Msgf("API response %s %s status code: %d", payload.Method, logURL, resp.StatusCode)
+			return resp, nil
+		}
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(safeError(err)).Msgf("attempt %d/%d to %s %s failed after %s, retrying in %s",
+				attempt, maxAttempts, payload.Method, logURL, time.Since(start), wait)
+		} else {
+			log.Ctx(ctx).Warn().Msgf("attempt %d/%d to %s %s returned status code %d after %s, retrying in %s",
+				attempt, maxAttempts, payload.Method, logURL, resp.StatusCode, time.Since(start), wait)
+			// release the connection of the discarded response
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return nil, fmt.Errorf("failed to execute request: %w", err)
+		}
+	}
+}
 
+// attemptResult is the outcome of an attempt. Its request is nil when the attempt couldn't be sent,
+// eg: because the rate limiter or the circuit breaker rejected it, and then its error is final.
+type attemptResult struct {
+	req      *http.Request
+	resp     *http.Response
+	err      error
+	endpoint string
+}
+
+// try sends an attempt of the payload. When it's sent to an upstream, the endpoint to avoid, eg: the one
+// of the previous attempt, is only picked when there are no others.
+func (c *Client) try(ctx context.Context, payload Payload, url string, upstream *Upstream, attempt int, avoid string) attemptResult {
+	target := url
+	var endpoint *endpoint
+	if upstream != nil {
+		var err error
+		if endpoint, err = upstream.acquire(payload, avoid); err != nil {
+			return attemptResult{err: fmt.Errorf("failed to execute request: %w", err)}
+		}
+		target = endpoint.resolve(url)
+	}
+	// the request is built on every attempt so the body is replayed from the start
+	req, err := c.newRequest(ctx, payload, target)
+	if err != nil {
+		if endpoint != nil {
+			upstream.cancel(endpoint)
+		}
+		return attemptResult{err: fmt.Errorf("failed to create request: %w", err)}
+	}
+	if c.limiter != nil {
+		if err := c.limiter.Wait(ctx, req.URL.Host); err != nil {
+			if endpoint != nil {
+				upstream.cancel(endpoint)
+			}
+			log.Ctx(ctx).Warn().Err(err).Msgf("request to %s %s rate limited", payload.Method, safeURL(req.URL))
+			return attemptResult{err: fmt.Errorf("failed to execute request: %w", err)}
+		}
+	}
+	if c.breakers != nil {
+		if err := c.breakers.allow(ctx, req.URL.Host); err != nil {
+			if endpoint != nil {
+				upstream.cancel(endpoint)
+			}
+			log.Ctx(ctx).Warn().Err(err).Msgf("request to %s %s rejected", payload.Method, safeURL(req.URL))
+			return attemptResult{err: fmt.Errorf("failed to execute request: %w", err)}
+		}
+	}
+	// Execute the HTTP request.
+	resp, err := c.chain(payload, attempt)(req)
+
+	// an attempt cancelled by the caller, or because a hedged one answered first, isn't a failure of its host
+	cancelled := err != nil && ctx.Err() != nil
+	if c.breakers != nil {
+		if cancelled {
+			c.breakers.cancel(req.URL.Host)
+		} else {
+			c.breakers.record(ctx, req.URL.Host, resp, err)
+		}
+	}
+	result := attemptResult{req: req, resp: resp, err: err}
+	if endpoint != nil {
+		if cancelled {
+			upstream.cancel(endpoint)
+		} else {
+			upstream.release(ctx, endpoint, resp, err)
+		}
+		result.endpoint = endpoint.url
+	}
+	return result
+}
+
+// newRequest creates the HTTP request for the payload with its headers.
+// The IDs of the context and the authorization are added by the interceptor chain.
+func (c *Client) newRequest(ctx context.Context, payload Payload, url string) (*http.Request, error) {
+	var body io.Reader
+	if _, ok := payload.Body.(io.Seeker); ok && payload.GetBody == nil {
+		// a seekable body, eg: a file, is kept open to rewind it on retries
+		body = io.NopCloser(payload.Body)
+	} else if payload.Body != nil {
+		body = payload.Body
+	} else if payload.Content != nil {
+		body = bytes.NewReader(payload.Content)
+	}
+	req, err := http.NewRequestWithContext(ctx, payload.Method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Body != nil {
+		if payload.ContentLength > 0 {
+			req.ContentLength = payload.ContentLength
+		}
+		if payload.GetBody != nil {
+			req.GetBody = payload.GetBody
+		}
+	}
 	// Set custom headers from the payload.
-	for key, value := range headers {
+	for key, value := range payload.Headers {
 		req.Header.Set(key, value)
 	}
-	log.Ctx(ctx).Debug().Msgf("headers: to send to %s %+v", url, req.Header)
+	return req, nil
+}
 
-	// Set Authorization header with Bearer token.
-	req.Header.Set("Authorization", "Bearer "+payload.Token)
+// replayable tells if the body of the payload can be sent again.
+func (p Payload) replayable() bool {
+	if p.Body == nil || p.GetBody != nil {
+		return true
+	}
+	_, ok := p.Body.(io.Seeker)
+	return ok
+}
 
-	// Execute the HTTP request.
-	resp, err := c.client.Do(req)
+// readOnly tells if the payload only reads, that is a GET or HEAD without body, so it can be sent
+// more than once at the same time or share the response of an identical one.
+// Streams aren't, their response never ends before it's read.
+func (p Payload) readOnly() bool {
+	return (p.Method == http.MethodGet || p.Method == http.MethodHead) && p.Body == nil && p.Content == nil && !p.stream
+}
+
+// rewind returns the body of the payload ready to be sent again.
+func (p Payload) rewind() (io.Reader, error) {
+	if p.GetBody != nil {
+		return p.GetBody()
+	}
+	if seeker, ok := p.Body.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return p.Body, nil
+	}
+	return nil, fmt.Errorf("body can't be rewound")
+}
+
+// buildURL joins the base URL, or the upstream, and the URL of the payload and appends the query parameters.
+func buildURL(payload Payload) (string, error) {
+	rawURL := payload.URL
+	baseURL := payload.BaseURL
+	if payload.Upstream != "" {
+		baseURL = upstreamURL(payload)
+	}
+	u, err := url.Parse(rawURL)
 	if err != nil {
-		log.Ctx(ctx).Err(err).Msgf("Failed to send message to %s via HTTP: %v", url, resp)
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+		return "", err
+	}
+	// only a relative URL is resolved against the base, a query may carry another absolute URL
+	if baseURL != "" && !u.IsAbs() {
+		rawURL = joinURL(baseURL, rawURL)
+		if u, err = url.Parse(rawURL); err != nil {
+			return "", err
+		}
+	}
+	if len(payload.Query) == 0 {
+		return rawURL, nil
+	}
+	query := u.Query()
+	for key, value := range payload.Query {
+		query.Set(key, value)
 	}
-	log.Ctx(ctx).Info().Msgf("API response %s status code: %d", url, resp.StatusCode)
-	return resp, nil
+	u.RawQuery = query.Encode()
+	return u.String(), nil
 }
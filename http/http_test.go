@@ -1,12 +1,19 @@
 package http
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/narumayase/anysher/propagation"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -98,3 +105,286 @@ func TestHttpClientImpl_Post(t *testing.T) {
 		assert.Nil(t, resp)
 	})
 }
+
+// TestHttpClientImpl_Methods tests every HTTP verb exposed by the Client.
+func TestHttpClientImpl_Methods(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.Client())
+	payload := Payload{URL: server.URL, Token: "test-token"}
+
+	calls := map[string]func(context.Context, Payload) (*http.Response, error){
+		http.MethodGet:    client.Get,
+		http.MethodPost:   client.Post,
+		http.MethodPut:    client.Put,
+		http.MethodPatch:  client.Patch,
+		http.MethodDelete: client.Delete,
+		http.MethodHead:   client.Head,
+	}
+	for method, call := range calls {
+		t.Run(method, func(t *testing.T) {
+			resp, err := call(context.Background(), payload)
+			assert.NoError(t, err)
+			assert.Equal(t, method, resp.Header.Get("X-Method"))
+		})
+	}
+}
+
+// TestHttpClientImpl_Do tests the general Do method of the Client.
+func TestHttpClientImpl_Do(t *testing.T) {
+	t.Run("base url, path and query parameters", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPut, r.Method)
+			assert.Equal(t, "/api/v1/items/1", r.URL.Path)
+			assert.Equal(t, "value", r.URL.Query().Get("key"))
+			assert.Equal(t, "keep", r.URL.Query().Get("existing"))
+			assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		resp, err := client.Do(context.Background(), Payload{
+			Method:  http.MethodPut,
+			BaseURL: server.URL + "/api/v1/",
+			URL:     "/items/1?existing=keep",
+			Query:   map[string]string{"key": "value"},
+			Token:   "test-token",
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Content: []byte("{}"),
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+
+	t.Run("absolute url ignores base url", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodGet, r.Method)
+			assert.Equal(t, "/absolute", r.URL.Path)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		resp, err := client.Do(context.Background(), Payload{
+			BaseURL: "http://unused.local",
+			URL:     server.URL + "/absolute",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		client := NewClient(&http.Client{})
+		resp, err := client.Do(context.Background(), Payload{
+			BaseURL: "http://example.com",
+			URL:     "/items/%zz",
+		})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to create request")
+		assert.Nil(t, resp)
+	})
+
+	t.Run("invalid query url", func(t *testing.T) {
+		client := NewClient(&http.Client{})
+		resp, err := client.Do(context.Background(), Payload{
+			URL:   "http://[::1",
+			Query: map[string]string{"key": "value"},
+		})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to create request")
+		assert.Nil(t, resp)
+	})
+}
+
+// TestBuildURL tests that only relative URLs are joined to the base URL.
+func TestBuildURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload Payload
+		want    string
+	}{
+		{
+			name:    "without base url",
+			payload: Payload{URL: "http://example.com/items"},
+			want:    "http://example.com/items",
+		},
+		{
+			name:    "relative url",
+			payload: Payload{BaseURL: "http://example.com/api/", URL: "/items/1"},
+			want:    "http://example.com/api/items/1",
+		},
+		{
+			name:    "absolute url ignores base url",
+			payload: Payload{BaseURL: "http://unused.local", URL: "https://example.com/items"},
+			want:    "https://example.com/items",
+		},
+		{
+			name:    "absolute url in the query of a relative url",
+			payload: Payload{BaseURL: "http://example.com", URL: "/callback?redirect=https://x"},
+			want:    "http://example.com/callback?redirect=https://x",
+		},
+		{
+			name:    "query parameters",
+			payload: Payload{BaseURL: "http://example.com", URL: "/items?existing=keep", Query: map[string]string{"key": "value"}},
+			want:    "http://example.com/items?existing=keep&key=value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildURL(tt.payload)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestHttpClientImpl_Propagation tests that the IDs of the context are added to the request.
+func TestHttpClientImpl_Propagation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "a-request", r.Header.Get("X-Request-Id"))
+		assert.Equal(t, "from-payload", r.Header.Get("X-Correlation-Id"))
+		assert.Empty(t, r.Header.Get("X-Other"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	ctx := propagation.ContextWithValue(context.Background(), "X-Request-Id", "a-request")
+	ctx = propagation.ContextWithValue(ctx, "X-Correlation-Id", "from-context")
+	ctx = propagation.ContextWithValue(ctx, "X-Other", "not propagated")
+
+	client := NewClient(server.Client(), WithPropagator(propagation.NewPropagator("X-Request-Id", "X-Correlation-Id")))
+	_, err := client.Get(ctx, Payload{
+		URL:     server.URL,
+		Headers: map[string]string{"X-Correlation-Id": "from-payload"},
+	})
+	assert.NoError(t, err)
+}
+
+// TestHttpClientImpl_RedactsLogs tests that secrets aren't written to the debug logs.
+func TestHttpClientImpl_RedactsLogs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.Client())
+
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer zerolog.SetGlobalLevel(level)
+
+	var logs bytes.Buffer
+	ctx := zerolog.New(&logs).WithContext(context.Background())
+
+	_, err := client.Post(ctx, Payload{
+		URL:     server.URL,
+		Token:   "a-secret-token",
+		Content: []byte(`{"user":"a-user","password":"a-secret-password"}`),
+	})
+	assert.NoError(t, err)
+	assert.Contains(t, logs.String(), "a-user")
+	assert.NotContains(t, logs.String(), "a-secret-token")
+	assert.NotContains(t, logs.String(), "a-secret-password")
+}
+
+func TestHttpClientImpl_RedactsURLsInLogs(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	client := NewClient(server.Client(), WithRetryPolicy(policy),
+		WithAuthenticator(APIKeyQuery("api_key", "a-secret-key")))
+
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer zerolog.SetGlobalLevel(level)
+
+	var logs bytes.Buffer
+	ctx := zerolog.New(&logs).WithContext(context.Background())
+
+	// a retried request and a request whose transport fails, whose *url.Error includes the URL
+	for _, rawURL := range []string{server.URL, closed.URL} {
+		userinfo := strings.Replace(rawURL, "http://", "http://user:a-secret-password@", 1)
+		_, _ = client.Get(ctx, Payload{URL: userinfo + "/users", Query: map[string]string{"token": "a-secret-token"}})
+	}
+	assert.Contains(t, logs.String(), server.URL+"/users")
+	assert.Contains(t, logs.String(), closed.URL+"/users")
+	for _, secret := range []string{"a-secret-key", "a-secret-token", "a-secret-password"} {
+		assert.NotContains(t, logs.String(), secret)
+	}
+}
+
+// TestHttpClientImpl_StreamedBody tests sending an io.Reader body and replaying it on retries.
+func TestHttpClientImpl_StreamedBody(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "streamed content", string(body))
+		assert.Equal(t, int64(16), r.ContentLength)
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.Client(), WithRetryPolicy(testRetryPolicy()))
+
+	t.Run("seekable body is rewound", func(t *testing.T) {
+		attempts.Store(0)
+		resp, err := client.Put(context.Background(), Payload{
+			URL:           server.URL,
+			Body:          strings.NewReader("streamed content"),
+			ContentLength: 16,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int32(2), attempts.Load())
+	})
+
+	t.Run("get body replays the body", func(t *testing.T) {
+		attempts.Store(0)
+		getBody := func() (io.ReadCloser, error) {
+			return io.NopCloser(io.MultiReader(strings.NewReader("streamed content"))), nil
+		}
+		body, _ := getBody()
+		resp, err := client.Put(context.Background(), Payload{
+			URL:           server.URL,
+			Body:          body,
+			ContentLength: 16,
+			GetBody:       getBody,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int32(2), attempts.Load())
+	})
+
+	t.Run("body that can't be rewound isn't retried", func(t *testing.T) {
+		attempts.Store(0)
+		resp, err := client.Put(context.Background(), Payload{
+			URL:           server.URL,
+			Body:          io.MultiReader(strings.NewReader("streamed content")),
+			ContentLength: 16,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.Equal(t, int32(1), attempts.Load())
+	})
+}
@@ -0,0 +1,105 @@
+package httptest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Cassette holds the recorded interactions of a test.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions" yaml:"interactions"`
+}
+
+// Interaction is a recorded request and the response it got.
+type Interaction struct {
+	Request  Request  `json:"request" yaml:"request"`
+	Response Response `json:"response" yaml:"response"`
+}
+
+// Request is a recorded request.
+type Request struct {
+	Method  string              `json:"method" yaml:"method"`
+	URL     string              `json:"url" yaml:"url"`
+	Headers map[string][]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body    Body                `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// Response is a recorded response.
+type Response struct {
+	StatusCode int                 `json:"status_code" yaml:"status_code"`
+	Headers    map[string][]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body       Body                `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// Body is a recorded body, text is kept as it is and binary content is base64 encoded.
+type Body struct {
+	Text   string `json:"text,omitempty" yaml:"text,omitempty"`
+	Base64 string `json:"base64,omitempty" yaml:"base64,omitempty"`
+}
+
+// newBody records the content as text when it's valid UTF-8.
+func newBody(content []byte) Body {
+	if utf8.Valid(content) {
+		return Body{Text: string(content)}
+	}
+	return Body{Base64: base64.StdEncoding.EncodeToString(content)}
+}
+
+// Bytes returns the content of the body.
+func (b Body) Bytes() ([]byte, error) {
+	if b.Base64 != "" {
+		return base64.StdEncoding.DecodeString(b.Base64)
+	}
+	return []byte(b.Text), nil
+}
+
+// isJSON tells if the cassette is stored as JSON, otherwise it's YAML.
+func isJSON(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// loadCassette reads the cassette file, JSON or YAML according to its extension.
+func loadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	cassette := &Cassette{}
+	if isJSON(path) {
+		err = json.Unmarshal(data, cassette)
+	} else {
+		err = yaml.Unmarshal(data, cassette)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	return cassette, nil
+}
+
+// save writes the cassette file, JSON or YAML according to its extension, creating its directory.
+func (c *Cassette) save(path string) error {
+	var data []byte
+	var err error
+	if isJSON(path) {
+		data, err = json.MarshalIndent(c, "", "  ")
+	} else {
+		data, err = yaml.Marshal(c)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package httptest
+
+import (
+	"github.com/joho/godotenv"
+	anysherlog "github.com/narumayase/anysher/log"
+	"github.com/rs/zerolog/log"
+	"os"
+)
+
+// Config contains the configuration of the recorder.
+type Config struct {
+	mode Mode
+}
+
+// load loads configuration from environment variables or an .env file
+// It takes the configuration from environment variables:
+// - HTTPTEST_MODE -> replay or record
+// - LOG_LEVEL
+func load() Config {
+	// Load .env file if it exists (ignore error if file doesn't exist)
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file found or error loading .env file: %v", err)
+	}
+	anysherlog.SetLogLevel()
+
+	mode := ModeReplay
+	if getEnv("HTTPTEST_MODE", "replay") == "record" {
+		mode = ModeRecord
+	}
+	return Config{mode: mode}
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
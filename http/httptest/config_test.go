@@ -0,0 +1,20 @@
+package httptest
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestNewConfiguration(t *testing.T) {
+	os.Setenv("HTTPTEST_MODE", "record")
+	defer os.Unsetenv("HTTPTEST_MODE")
+
+	cfg := load()
+	assert.Equal(t, Config{mode: ModeRecord}, cfg)
+}
+
+func TestNewConfiguration_Defaults(t *testing.T) {
+	cfg := load()
+	assert.Equal(t, Config{mode: ModeReplay}, cfg)
+}
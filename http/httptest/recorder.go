@@ -0,0 +1,332 @@
+package httptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/narumayase/anysher/redact"
+)
+
+// ErrNoInteraction is returned by the Recorder when no recorded interaction matches the request.
+var ErrNoInteraction = errors.New("no recorded interaction matches the request")
+
+// Mode tells if the Recorder replays or records the interactions.
+type Mode int
+
+const (
+	// ModeReplay serves the requests from the cassette without network access.
+	ModeReplay Mode = iota
+	// ModeRecord sends the requests and writes the interactions to the cassette when the test ends.
+	ModeRecord
+)
+
+// Matcher tells which parts of a request must be equal to the recorded one to replay its response.
+// JSON bodies are compared ignoring their formatting and the order of their fields.
+type Matcher struct {
+	Method  bool
+	URL     bool
+	Headers []string
+	Body    bool
+}
+
+// DefaultMatcher matches the requests by method and URL.
+func DefaultMatcher() Matcher {
+	return Matcher{Method: true, URL: true}
+}
+
+// Option configures optional behavior of the Recorder.
+type Option func(*Recorder)
+
+// WithMode sets the mode of the Recorder instead of HTTPTEST_MODE.
+func WithMode(mode Mode) Option {
+	return func(r *Recorder) {
+		r.mode = mode
+	}
+}
+
+// WithMatcher sets how the requests are matched with the recorded interactions.
+func WithMatcher(matcher Matcher) Option {
+	return func(r *Recorder) {
+		r.matcher = matcher
+	}
+}
+
+// WithRedactor sets the Redactor that masks the headers, query parameters and JSON fields before recording them.
+func WithRedactor(redactor *redact.Redactor) Option {
+	return func(r *Recorder) {
+		r.redactor = redactor
+	}
+}
+
+// WithTransport sets the transport used to send the requests while recording (default: http.DefaultTransport).
+func WithTransport(transport http.RoundTripper) Option {
+	return func(r *Recorder) {
+		r.transport = transport
+	}
+}
+
+// Recorder is an http.RoundTripper that records the interactions to a cassette file and replays them offline.
+type Recorder struct {
+	t         testing.TB
+	path      string
+	mode      Mode
+	matcher   Matcher
+	redactor  *redact.Redactor
+	transport http.RoundTripper
+
+	mu       sync.Mutex
+	cassette *Cassette
+	replayed []bool
+	// params are the query parameters masked besides the fields of the redactor
+	params []string
+}
+
+// New creates a Recorder for the cassette file, stored as JSON when its extension is .json, otherwise as YAML.
+// It takes the environment variables:
+// - HTTPTEST_MODE -> replay (default) or record
+// - REDACT_HEADERS and REDACT_FIELDS -> headers, query parameters and JSON fields masked in the cassette
+// In replay mode the test fails when the cassette can't be read. In record mode the cassette is written
+// when the test ends.
+func New(t testing.TB, path string, opts ...Option) *Recorder {
+	t.Helper()
+	cfg := load()
+
+	r := &Recorder{
+		t:         t,
+		path:      path,
+		mode:      cfg.mode,
+		matcher:   DefaultMatcher(),
+		redactor:  redact.NewRedactor(),
+		transport: http.DefaultTransport,
+		cassette:  &Cassette{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if r.mode == ModeRecord {
+		t.Cleanup(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if err := r.cassette.save(r.path); err != nil {
+				t.Errorf("httptest: %v", err)
+			}
+		})
+		return r
+	}
+	cassette, err := loadCassette(path)
+	if err != nil {
+		t.Fatalf("httptest: %v, record it with HTTPTEST_MODE=record", err)
+		return r
+	}
+	r.cassette = cassette
+	r.replayed = make([]bool, len(cassette.Interactions))
+	return r
+}
+
+// Client returns an http.Client that sends its requests through the Recorder, eg: http.NewClient(recorder.Client()).
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// RedactQuery masks the query parameters in the cassette besides the fields of the Redactor.
+// The http.Client created with recorder.Client() calls it with the parameter of its http.APIKeyQuery
+// authenticator, so the API key is never recorded.
+func (r *Recorder) RedactQuery(params ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.params = append(r.params, params...)
+}
+
+// RoundTrip records or replays the request according to the mode of the Recorder.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	recorded := r.recordRequest(req, body)
+
+	if r.mode == ModeRecord {
+		return r.record(req, recorded)
+	}
+	return r.replay(req, recorded)
+}
+
+// record sends the request and keeps the redacted interaction, the caller gets the response as it is.
+func (r *Recorder) record(req *http.Request, recorded Request) (*http.Response, error) {
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	content, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(content))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request: recorded,
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Headers:    r.redactor.Headers(resp.Header),
+			Body:       newBody(r.redactor.JSON(content)),
+		},
+	})
+	return resp, nil
+}
+
+// replay returns the response of the first matching interaction that wasn't replayed yet, or of the last
+// matching one when all of them were, so retried and repeated requests are served too.
+func (r *Recorder) replay(req *http.Request, recorded Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	match := -1
+	for i, interaction := range r.cassette.Interactions {
+		if !r.matches(recorded, interaction.Request) {
+			continue
+		}
+		match = i
+		if !r.replayed[i] {
+			break
+		}
+	}
+	if match < 0 {
+		r.t.Errorf("httptest: %s", r.diff(recorded))
+		return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, recorded.Method, recorded.URL)
+	}
+	r.replayed[match] = true
+
+	response := r.cassette.Interactions[match].Response
+	content, err := response.Body.Bytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode recorded body: %w", err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode)),
+		StatusCode:    response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header(response.Headers).Clone(),
+		Body:          io.NopCloser(bytes.NewReader(content)),
+		ContentLength: int64(len(content)),
+		Request:       req,
+	}, nil
+}
+
+// recordRequest returns the redacted request, as it's written to the cassette and compared when replaying.
+// The body is redacted according to its content type, eg: the client_secret of a form is masked.
+func (r *Recorder) recordRequest(req *http.Request, body []byte) Request {
+	u := *req.URL
+	if u.RawQuery != "" {
+		query := r.redactor.Query(u.Query())
+		r.mu.Lock()
+		for _, param := range r.params {
+			if query.Has(param) {
+				query[param] = []string{redact.Mask}
+			}
+		}
+		r.mu.Unlock()
+		u.RawQuery = query.Encode()
+	}
+
+	return Request{
+		Method:  req.Method,
+		URL:     u.String(),
+		Headers: r.redactor.Headers(req.Header),
+		Body:    newBody(r.redactor.Content(req.Header.Get("Content-Type"), body)),
+	}
+}
+
+// matches tells if the request is equal to the recorded one in the parts of the Matcher.
+func (r *Recorder) matches(request, recorded Request) bool {
+	return len(r.differences(request, recorded)) == 0
+}
+
+// differences returns a line for every part of the Matcher where the request and the recorded one differ.
+func (r *Recorder) differences(request, recorded Request) []string {
+	var lines []string
+	add := func(name, want, got string) {
+		lines = append(lines, fmt.Sprintf("  %s:\n    - recorded: %s\n    + request:  %s", name, want, got))
+	}
+	if r.matcher.Method && request.Method != recorded.Method {
+		add("method", recorded.Method, request.Method)
+	}
+	if r.matcher.URL && request.URL != recorded.URL {
+		add("url", recorded.URL, request.URL)
+	}
+	for _, header := range r.matcher.Headers {
+		want := strings.Join(http.Header(recorded.Headers).Values(header), ", ")
+		got := strings.Join(http.Header(request.Headers).Values(header), ", ")
+		if want != got {
+			add("header "+http.CanonicalHeaderKey(header), want, got)
+		}
+	}
+	if r.matcher.Body && !equalBodies(request.Body, recorded.Body) {
+		add("body", bodyString(recorded.Body), bodyString(request.Body))
+	}
+	return lines
+}
+
+// diff describes the request that didn't match and how it differs from the closest recorded interaction.
+func (r *Recorder) diff(request Request) string {
+	message := fmt.Sprintf("%s: %s %s in cassette %s", ErrNoInteraction, request.Method, request.URL, r.path)
+	var closest []string
+	closestIndex := -1
+	for i, interaction := range r.cassette.Interactions {
+		lines := r.differences(request, interaction.Request)
+		if closestIndex < 0 || len(lines) < len(closest) {
+			closest, closestIndex = lines, i
+		}
+	}
+	if closestIndex < 0 {
+		return message + "\nthe cassette has no interactions"
+	}
+	return fmt.Sprintf("%s\nclosest interaction #%d differs in:\n%s", message, closestIndex+1, strings.Join(closest, "\n"))
+}
+
+// equalBodies compares the bodies, JSON documents are compared by value.
+func equalBodies(a, b Body) bool {
+	if a == b {
+		return true
+	}
+	var documentA, documentB any
+	if json.Unmarshal([]byte(a.Text), &documentA) != nil || json.Unmarshal([]byte(b.Text), &documentB) != nil {
+		return false
+	}
+	normalizedA, _ := json.Marshal(documentA)
+	normalizedB, _ := json.Marshal(documentB)
+	return bytes.Equal(normalizedA, normalizedB)
+}
+
+func bodyString(body Body) string {
+	if body.Base64 != "" {
+		return "base64:" + body.Base64
+	}
+	return body.Text
+}
+
+// readBody reads the request body and restores it, so it can still be sent.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,231 @@
+package httptest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	nethttptest "net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	anysherhttp "github.com/narumayase/anysher/http"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeT captures the failures of the Recorder, so the tests can check them without failing.
+type fakeT struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeT) Fatalf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeT) Cleanup(cleanup func()) {
+	f.cleanups = append(f.cleanups, cleanup)
+}
+
+// finish runs the cleanups, like the end of a test does.
+func (f *fakeT) finish() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+// recordUsers records a POST and a GET to a server that's closed afterward.
+func recordUsers(t *testing.T, path string) *atomic.Int32 {
+	var requests atomic.Int32
+	server := nethttptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id":1,"request":` + string(body) + `,"access_token":"a-secret-token"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":1,"name":"a-user"}`))
+	}))
+	defer server.Close()
+
+	recording := &fakeT{}
+	recorder := New(recording, path, WithMode(ModeRecord))
+	client := anysherhttp.NewClient(recorder.Client())
+
+	resp, err := client.Post(context.Background(), anysherhttp.Payload{
+		URL:     server.URL + "/users?token=a-secret-query",
+		Token:   "a-secret-bearer",
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Content: []byte(`{"name":"a-user","password":"a-secret-password"}`),
+	})
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	// the caller gets the response as it is
+	assert.Contains(t, string(body), "a-secret-token")
+
+	resp, err = client.Get(context.Background(), anysherhttp.Payload{URL: server.URL + "/users/1"})
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	recording.finish()
+	assert.Empty(t, recording.errors)
+	return &requests
+}
+
+func TestRecorder_RecordAndReplay(t *testing.T) {
+	for _, name := range []string{"users.yaml", "users.json"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cassettes", name)
+			requests := recordUsers(t, path)
+			assert.Equal(t, int32(2), requests.Load())
+
+			content, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			assert.NotContains(t, string(content), "a-secret")
+			assert.Contains(t, string(content), "a-user")
+
+			recorder := New(t, path, WithMatcher(Matcher{Method: true, URL: true, Body: true}))
+			client := anysherhttp.NewClient(recorder.Client())
+
+			// the recorded URL and body are redacted the same way before matching
+			resp, err := client.Post(context.Background(), anysherhttp.Payload{
+				URL:     recorder.cassette.Interactions[0].Request.URL,
+				Content: []byte(`{"password": "another-password", "name": "a-user"}`),
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusCreated, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			body, _ := io.ReadAll(resp.Body)
+			assert.Contains(t, string(body), `"id":1`)
+
+			resp, err = client.Get(context.Background(), anysherhttp.Payload{
+				URL: strings.Replace(recorder.cassette.Interactions[0].Request.URL, "/users?token=%5BREDACTED%5D", "/users/1", 1),
+			})
+			assert.NoError(t, err)
+			body, _ = io.ReadAll(resp.Body)
+			assert.Equal(t, `{"id":1,"name":"a-user"}`, string(body))
+			// no request reached the closed server
+			assert.Equal(t, int32(2), requests.Load())
+		})
+	}
+}
+
+func TestRecorder_ReplayRepeatedRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repeated.yaml")
+	cassette := &Cassette{Interactions: []Interaction{
+		{Request: Request{Method: "GET", URL: "http://example.com/status"}, Response: Response{StatusCode: 503}},
+		{Request: Request{Method: "GET", URL: "http://example.com/status"}, Response: Response{StatusCode: 200}},
+	}}
+	assert.NoError(t, cassette.save(path))
+
+	client := New(t, path).Client()
+	for _, expected := range []int{503, 200, 200} {
+		resp, err := client.Get("http://example.com/status")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, resp.StatusCode)
+	}
+}
+
+func TestRecorder_Unmatched(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unmatched.yaml")
+	cassette := &Cassette{Interactions: []Interaction{
+		{Request: Request{Method: "GET", URL: "http://example.com/other"}, Response: Response{StatusCode: 200}},
+		{
+			Request: Request{
+				Method:  "POST",
+				URL:     "http://example.com/users",
+				Headers: map[string][]string{"X-Tenant": {"a"}},
+				Body:    Body{Text: `{"name":"a-user"}`},
+			},
+			Response: Response{StatusCode: 201},
+		},
+	}}
+	assert.NoError(t, cassette.save(path))
+
+	replaying := &fakeT{}
+	recorder := New(replaying, path, WithMatcher(Matcher{Method: true, URL: true, Headers: []string{"X-Tenant"}, Body: true}))
+	req, _ := http.NewRequest("POST", "http://example.com/users", strings.NewReader(`{"name":"another-user"}`))
+	req.Header.Set("X-Tenant", "b")
+
+	_, err := recorder.Client().Do(req)
+	assert.ErrorIs(t, err, ErrNoInteraction)
+	assert.Len(t, replaying.errors, 1)
+	assert.Contains(t, replaying.errors[0], "POST http://example.com/users")
+	assert.Contains(t, replaying.errors[0], "closest interaction #2")
+	assert.Contains(t, replaying.errors[0], "header X-Tenant:\n    - recorded: a\n    + request:  b")
+	assert.Contains(t, replaying.errors[0], `body:
+    - recorded: {"name":"a-user"}
+    + request:  {"name":"another-user"}`)
+	assert.NotContains(t, replaying.errors[0], "url:")
+}
+
+func TestRecorder_MissingCassette(t *testing.T) {
+	replaying := &fakeT{}
+	New(replaying, filepath.Join(t.TempDir(), "missing.yaml"))
+
+	assert.Len(t, replaying.errors, 1)
+	assert.Contains(t, replaying.errors[0], "HTTPTEST_MODE=record")
+}
+
+func TestRecorder_BinaryBody(t *testing.T) {
+	content := []byte{0xff, 0x00, 0xfe}
+	body := newBody(content)
+	assert.NotEmpty(t, body.Base64)
+
+	decoded, err := body.Bytes()
+	assert.NoError(t, err)
+	assert.Equal(t, content, decoded)
+}
+
+func TestRecorder_RedactsFormAndAPIKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "form.yaml")
+	server := nethttptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "a-secret-key", r.URL.Query().Get("partner_key"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	recording := &fakeT{}
+	recorder := New(recording, path, WithMode(ModeRecord))
+	// partner_key isn't one of the redacted fields, it's masked because the API key is sent in it
+	client := anysherhttp.NewClient(recorder.Client(),
+		anysherhttp.WithAuthenticator(anysherhttp.APIKeyQuery("partner_key", "a-secret-key")))
+
+	form := url.Values{"grant_type": {"client_credentials"}, "client_secret": {"a-client-secret"}}
+	payload := anysherhttp.Payload{URL: server.URL + "/token", Query: map[string]string{"api_key": "a-query-key"}}
+	resp, err := client.Post(context.Background(), payload.WithForm(form))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	recording.finish()
+	assert.Empty(t, recording.errors)
+
+	cassette, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(cassette), "a-secret-key")
+	assert.NotContains(t, string(cassette), "a-client-secret")
+	assert.NotContains(t, string(cassette), "a-query-key")
+	assert.Contains(t, string(cassette), "grant_type=client_credentials")
+
+	// the redacted request is replayed
+	replaying := &fakeT{}
+	recorder = New(replaying, path, WithMatcher(Matcher{Method: true, URL: true, Body: true}))
+	client = anysherhttp.NewClient(recorder.Client(),
+		anysherhttp.WithAuthenticator(anysherhttp.APIKeyQuery("partner_key", "another-key")))
+	resp, err = client.Post(context.Background(), payload.WithForm(form))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, replaying.errors)
+}
@@ -0,0 +1,88 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/narumayase/anysher/metrics"
+	"github.com/narumayase/anysher/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	otelpropagation "go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// roundTrip executes the request within an OpenTelemetry client span, one per attempt,
+// and writes the trace context into the request headers with the global propagator.
+// Every attempt is recorded in the metrics package, labelled by host and status.
+func (c *Client) roundTrip(req *http.Request, attempt int) (*http.Response, error) {
+	ctx, span := tracing.Tracer().Start(req.Context(), req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(safeURL(req.URL)),
+			semconv.ServerAddress(req.URL.Hostname()),
+		))
+	defer span.End()
+	if attempt > 1 {
+		span.SetAttributes(semconv.HTTPRequestResendCount(attempt - 1))
+	}
+
+	otel.GetTextMapPropagator().Inject(ctx, otelpropagation.HeaderCarrier(req.Header))
+
+	start := time.Now()
+	resp, err := c.client.Do(req.WithContext(ctx))
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	metrics.ObserveClientRequest(req.Method, req.URL.Host, status, err, time.Since(start))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, fmt.Sprintf("status code %d", resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// safeURL returns the URL without credentials nor query, which may carry API keys.
+func safeURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+	redacted.RawQuery = ""
+	redacted.ForceQuery = false
+	return redacted.String()
+}
+
+// safeRawURL returns the raw URL to log, without credentials nor query, see safeURL.
+func safeRawURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid URL"
+	}
+	return safeURL(u)
+}
+
+// safeError returns the error to log, the message of a *url.Error includes the full URL of the request,
+// which is replaced by its safe version.
+func safeError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	if err == error(urlErr) {
+		redacted := *urlErr
+		redacted.URL = safeRawURL(urlErr.URL)
+		return &redacted
+	}
+	return errors.New(strings.ReplaceAll(err.Error(), urlErr.URL, safeRawURL(urlErr.URL)))
+}
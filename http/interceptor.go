@@ -0,0 +1,119 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/narumayase/anysher/propagation"
+	"github.com/narumayase/anysher/redact"
+	"github.com/rs/zerolog/log"
+)
+
+// Handler sends an outbound request and returns its response, it's the rest of the interceptor chain.
+type Handler func(req *http.Request) (*http.Response, error)
+
+// Interceptor intercepts every attempt of an outbound request, like a Gin HandlerFunc does with the incoming ones.
+// It can change the request before calling next, short-circuit the chain returning a response or an error
+// without calling next, or inspect and replace the response returned by next.
+type Interceptor func(req *http.Request, next Handler) (*http.Response, error)
+
+// WithInterceptors adds interceptors to the chain of the Client, see Use.
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(c *Client) {
+		c.Use(interceptors...)
+	}
+}
+
+// Use adds interceptors to the chain of the Client, they run in the order they were added.
+// The chain starts with the built-in Propagation and Authentication interceptors, then the added ones,
+// and ends with the Compression interceptor, when the Client has one, and the Logging interceptor,
+// so the logged headers are the ones sent.
+// Use must not be called while the Client is sending requests.
+func (c *Client) Use(interceptors ...Interceptor) {
+	c.interceptors = append(c.interceptors, interceptors...)
+}
+
+// chain returns the handler that runs every interceptor for the payload and finally sends the request.
+func (c *Client) chain(payload Payload, attempt int) Handler {
+	interceptors := make([]Interceptor, 0, len(c.interceptors)+4)
+	interceptors = append(interceptors, c.builtins(payload)...)
+	interceptors = append(interceptors, c.interceptors...)
+	if c.compression != nil {
+		interceptors = append(interceptors, Compression(*c.compression))
+	}
+	interceptors = append(interceptors, Logging(c.redactor))
+
+	return compose(interceptors, func(req *http.Request) (*http.Response, error) {
+		return c.roundTrip(req, attempt)
+	})
+}
+
+// builtins returns the built-in interceptors that start the chain, they set the headers of the request.
+func (c *Client) builtins(payload Payload) []Interceptor {
+	return []Interceptor{Propagation(c.propagator), Authentication(c.authenticator(payload))}
+}
+
+// prepare runs the built-in interceptors of the chain on the request without sending it, so the cache
+// knows the headers that will be sent, eg: the authorization.
+func (c *Client) prepare(payload Payload, req *http.Request) error {
+	_, err := compose(c.builtins(payload), func(*http.Request) (*http.Response, error) {
+		return nil, nil
+	})(req)
+	return err
+}
+
+// compose returns the handler that runs the interceptors in order and finally the handler.
+func compose(interceptors []Interceptor, handler Handler) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(req *http.Request) (*http.Response, error) {
+			return interceptor(req, next)
+		}
+	}
+	return handler
+}
+
+// authenticator returns the Authenticator of the payload, its token takes precedence over the one of the Client.
+func (c *Client) authenticator(payload Payload) Authenticator {
+	if payload.Token != "" {
+		return BearerToken(payload.Token)
+	}
+	return c.auth
+}
+
+// Propagation returns an Interceptor that adds the IDs of the request context that the request doesn't set.
+func Propagation(propagator *propagation.Propagator) Interceptor {
+	return func(req *http.Request, next Handler) (*http.Response, error) {
+		propagator.Inject(req.Context(), req.Header)
+		return next(req)
+	}
+}
+
+// Authentication returns an Interceptor that adds the credentials of the Authenticator to the request.
+func Authentication(auth Authenticator) Interceptor {
+	return func(req *http.Request, next Handler) (*http.Response, error) {
+		if err := auth.Authenticate(req); err != nil {
+			return nil, fmt.Errorf("failed to authenticate request: %w", err)
+		}
+		return next(req)
+	}
+}
+
+// Logging returns an Interceptor that logs the redacted headers of the request and the status code of the response.
+func Logging(redactor *redact.Redactor) Interceptor {
+	return func(req *http.Request, next Handler) (*http.Response, error) {
+		ctx := req.Context()
+		log.Ctx(ctx).Debug().Msgf("headers: to send to %s %s %+v", req.Method, safeURL(req.URL), redactor.Headers(req.Header))
+
+		start := time.Now()
+		resp, err := next(req)
+		if err != nil {
+			log.Ctx(ctx).Debug().Err(safeError(err)).Msgf("%s %s failed after %s", req.Method, safeURL(req.URL), time.Since(start))
+			return resp, err
+		}
+		log.Ctx(ctx).Debug().Msgf("%s %s returned status code %d after %s",
+			req.Method, safeURL(req.URL), resp.StatusCode, time.Since(start))
+		return resp, nil
+	}
+}
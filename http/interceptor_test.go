@@ -0,0 +1,170 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/narumayase/anysher/propagation"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_Interceptors(t *testing.T) {
+	t.Run("interceptors run in order after the built-in ones", func(t *testing.T) {
+		var received http.Header
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r.Header.Clone()
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		var calls []string
+		record := func(name string) Interceptor {
+			return func(req *http.Request, next Handler) (*http.Response, error) {
+				calls = append(calls, name)
+				// the authorization is already set
+				req.Header.Set("X-Signature", name+":"+req.Header.Get("Authorization"))
+				return next(req)
+			}
+		}
+		client := NewClient(server.Client(), WithAuthenticator(BearerToken("token")), WithInterceptors(record("first")))
+		client.Use(record("second"))
+
+		resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, []string{"first", "second"}, calls)
+		assert.Equal(t, "second:Bearer token", received.Get("X-Signature"))
+	})
+
+	t.Run("short-circuit", func(t *testing.T) {
+		var transportCalls atomic.Int32
+		client := NewClient(&http.Client{Transport: &MockRoundTripper{
+			RoundTripFunc: func(*http.Request) (*http.Response, error) {
+				transportCalls.Add(1)
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+			},
+		}}, WithInterceptors(func(req *http.Request, next Handler) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusTeapot,
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader("injected")),
+				Request:    req,
+			}, nil
+		}))
+
+		resp, err := client.Get(context.Background(), Payload{URL: "http://example.com"})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+		assert.Equal(t, int32(0), transportCalls.Load())
+	})
+
+	t.Run("fault injection is retried", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		var attempts atomic.Int32
+		faults := func(req *http.Request, next Handler) (*http.Response, error) {
+			if attempts.Add(1) == 1 {
+				return nil, errors.New("injected fault")
+			}
+			return next(req)
+		}
+		client := NewClient(server.Client(), WithRetryPolicy(testRetryPolicy()), WithInterceptors(faults))
+
+		resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int32(2), attempts.Load())
+	})
+
+	t.Run("inspect the response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+
+		var status int
+		client := NewClient(server.Client(), WithInterceptors(func(req *http.Request, next Handler) (*http.Response, error) {
+			resp, err := next(req)
+			if err == nil {
+				status = resp.StatusCode
+			}
+			return resp, err
+		}))
+
+		resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusAccepted, status)
+	})
+
+	t.Run("authentication error stops the chain", func(t *testing.T) {
+		called := false
+		client := NewClient(&http.Client{}, WithAuthenticator(AuthenticatorFunc(func(*http.Request) error {
+			return assert.AnError
+		})), WithInterceptors(func(req *http.Request, next Handler) (*http.Response, error) {
+			called = true
+			return next(req)
+		}))
+
+		_, err := client.Get(context.Background(), Payload{URL: "http://example.com"})
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.Contains(t, err.Error(), "failed to authenticate request")
+		assert.False(t, called)
+	})
+}
+
+func TestBuiltInInterceptors(t *testing.T) {
+	var received *http.Request
+	next := func(req *http.Request) (*http.Response, error) {
+		received = req
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	t.Run("propagation", func(t *testing.T) {
+		ctx := propagation.ContextWithValue(context.Background(), "X-Correlation-Id", "a-correlation")
+		req := httptest.NewRequest("GET", "http://example.com", nil).WithContext(ctx)
+
+		_, err := Propagation(propagation.NewPropagator())(req, next)
+		assert.NoError(t, err)
+		assert.Equal(t, "a-correlation", received.Header.Get("X-Correlation-Id"))
+	})
+
+	t.Run("authentication", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+
+		_, err := Authentication(BasicAuth("user", "password"))(req, next)
+		assert.NoError(t, err)
+		username, password, ok := received.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "user", username)
+		assert.Equal(t, "password", password)
+	})
+
+	t.Run("logging redacts headers and query", func(t *testing.T) {
+		level := zerolog.GlobalLevel()
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+		defer zerolog.SetGlobalLevel(level)
+
+		var logs bytes.Buffer
+		ctx := zerolog.New(&logs).WithContext(context.Background())
+		req := httptest.NewRequest("GET", "http://example.com/path?api_key=secret", nil).WithContext(ctx)
+		req.Header.Set("Authorization", "Bearer secret")
+
+		_, err := Logging(nil)(req, next)
+		assert.NoError(t, err)
+		assert.Contains(t, logs.String(), "http://example.com/path")
+		assert.Contains(t, logs.String(), "returned status code 200")
+		assert.NotContains(t, logs.String(), "secret")
+	})
+}
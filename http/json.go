@@ -0,0 +1,144 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// defaultMaxResponseSize is the maximum response body size read by the JSON helpers.
+const defaultMaxResponseSize = 10 << 20
+
+// JSONOption configures optional behavior of the JSON helpers.
+type JSONOption func(*jsonOptions)
+
+type jsonOptions struct {
+	maxResponseSize int64
+	decodeError     func(body []byte) error
+}
+
+// WithMaxResponseSize sets the maximum size in bytes of the response body. It defaults to 10MB.
+func WithMaxResponseSize(size int64) JSONOption {
+	return func(o *jsonOptions) {
+		o.maxResponseSize = size
+	}
+}
+
+// WithErrorType decodes non-2xx response bodies into E, which is wrapped by the returned StatusError.
+func WithErrorType[E error]() JSONOption {
+	return func(o *jsonOptions) {
+		o.decodeError = func(body []byte) error {
+			var e E
+			if err := json.Unmarshal(body, &e); err != nil {
+				return nil
+			}
+			return e
+		}
+	}
+}
+
+// GetJSON sends a GET request and decodes the JSON response into Resp.
+func GetJSON[Resp any](ctx context.Context, c *Client, payload Payload, opts ...JSONOption) (Resp, error) {
+	payload.Method = http.MethodGet
+	return doJSON[Resp](ctx, c, payload, opts)
+}
+
+// DeleteJSON sends a DELETE request and decodes the JSON response into Resp.
+func DeleteJSON[Resp any](ctx context.Context, c *Client, payload Payload, opts ...JSONOption) (Resp, error) {
+	payload.Method = http.MethodDelete
+	return doJSON[Resp](ctx, c, payload, opts)
+}
+
+// PostJSON encodes the request as JSON, sends it with a POST and decodes the JSON response into Resp.
+func PostJSON[Req, Resp any](ctx context.Context, c *Client, payload Payload, request Req, opts ...JSONOption) (Resp, error) {
+	payload.Method = http.MethodPost
+	return DoJSON[Req, Resp](ctx, c, payload, request, opts...)
+}
+
+// PutJSON encodes the request as JSON, sends it with a PUT and decodes the JSON response into Resp.
+func PutJSON[Req, Resp any](ctx context.Context, c *Client, payload Payload, request Req, opts ...JSONOption) (Resp, error) {
+	payload.Method = http.MethodPut
+	return DoJSON[Req, Resp](ctx, c, payload, request, opts...)
+}
+
+// PatchJSON encodes the request as JSON, sends it with a PATCH and decodes the JSON response into Resp.
+func PatchJSON[Req, Resp any](ctx context.Context, c *Client, payload Payload, request Req, opts ...JSONOption) (Resp, error) {
+	payload.Method = http.MethodPatch
+	return DoJSON[Req, Resp](ctx, c, payload, request, opts...)
+}
+
+// DoJSON encodes the request as JSON, sends it with the method of the payload and decodes
+// the JSON response into Resp. Non-2xx responses are returned as a *StatusError.
+// The response body is always closed.
+func DoJSON[Req, Resp any](ctx context.Context, c *Client, payload Payload, request Req, opts ...JSONOption) (Resp, error) {
+	content, err := json.Marshal(request)
+	if err != nil {
+		var zero Resp
+		return zero, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	payload.Content = content
+	payload.Headers = withHeader(payload.Headers, "Content-Type", "application/json")
+	return doJSON[Resp](ctx, c, payload, opts)
+}
+
+func doJSON[Resp any](ctx context.Context, c *Client, payload Payload, opts []JSONOption) (Resp, error) {
+	var result Resp
+
+	options := jsonOptions{maxResponseSize: defaultMaxResponseSize}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	payload.Headers = withHeader(payload.Headers, "Accept", "application/json")
+
+	resp, err := c.Do(ctx, payload)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && options.decodeError != nil {
+		// the Client was created WithStatusErrors
+		statusErr.Err = options.decodeError(statusErr.Body)
+	}
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	// read one byte more than the limit to know if the body exceeds it
+	body, err := io.ReadAll(io.LimitReader(resp.Body, options.maxResponseSize+1))
+	if err != nil {
+		return result, fmt.Errorf("failed to read response: %w", err)
+	}
+	if int64(len(body)) > options.maxResponseSize {
+		return result, fmt.Errorf("response body exceeds %d bytes", options.maxResponseSize)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		statusErr = c.newStatusError(resp, body)
+		if options.decodeError != nil {
+			statusErr.Err = options.decodeError(body)
+		}
+		return result, statusErr
+	}
+	if len(body) == 0 {
+		return result, nil
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return result, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	return result, nil
+}
+
+// withHeader returns a copy of the headers with the header set, unless it's already present.
+func withHeader(headers map[string]string, key, value string) map[string]string {
+	result := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		if strings.EqualFold(k, key) {
+			key = k
+			value = v
+		}
+		result[k] = v
+	}
+	result[key] = value
+	return result
+}
@@ -0,0 +1,154 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type item struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// trackingBody records whether the response body was closed.
+type trackingBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *trackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestPostJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "application/json", r.Header.Get("Accept"))
+		var request item
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		request.ID = 1
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(request)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.Client())
+	created, err := PostJSON[item, item](context.Background(), client, Payload{URL: server.URL}, item{Name: "an item"})
+	assert.NoError(t, err)
+	assert.Equal(t, item{ID: 1, Name: "an item"}, created)
+}
+
+func TestGetJSON(t *testing.T) {
+	t.Run("decodes the response", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodGet, r.Method)
+			_, _ = w.Write([]byte(`[{"id":1,"name":"a"},{"id":2,"name":"b"}]`))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		items, err := GetJSON[[]item](context.Background(), client, Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, []item{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}}, items)
+	})
+
+	t.Run("decodes the error body into the error type", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"code":"not_found","message":"item not found"}`))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		_, err := GetJSON[item](context.Background(), client, Payload{URL: server.URL}, WithErrorType[*apiError]())
+
+		var statusErr *StatusError
+		assert.True(t, errors.As(err, &statusErr))
+		assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+
+		var apiErr *apiError
+		assert.True(t, errors.As(err, &apiErr))
+		assert.Equal(t, "not_found", apiErr.Code)
+	})
+
+	t.Run("error without error type keeps the raw body", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`bad request`))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		_, err := GetJSON[item](context.Background(), client, Payload{URL: server.URL})
+
+		var statusErr *StatusError
+		assert.True(t, errors.As(err, &statusErr))
+		assert.Equal(t, "bad request", string(statusErr.Body))
+		assert.Contains(t, err.Error(), "400")
+	})
+
+	t.Run("enforces the maximum response size", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"id":1,"name":"a very long name"}`))
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		_, err := GetJSON[item](context.Background(), client, Payload{URL: server.URL}, WithMaxResponseSize(10))
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "exceeds 10 bytes")
+	})
+
+	t.Run("empty body returns the zero value", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		result, err := DeleteJSON[item](context.Background(), client, Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, item{}, result)
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		body := &trackingBody{Reader: strings.NewReader(`not json`)}
+		mockClient := &http.Client{Transport: &MockRoundTripper{
+			RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: body, Header: http.Header{}}, nil
+			},
+		}}
+		client := NewClient(mockClient)
+		_, err := GetJSON[item](context.Background(), client, Payload{URL: "http://example.com"})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to unmarshal response")
+		assert.True(t, body.closed)
+	})
+}
+
+func TestWithHeader(t *testing.T) {
+	headers := withHeader(map[string]string{"content-type": "text/plain"}, "Content-Type", "application/json")
+	assert.Equal(t, map[string]string{"content-type": "text/plain"}, headers)
+
+	headers = withHeader(nil, "Accept", "application/json")
+	assert.Equal(t, map[string]string{"Accept": "application/json"}, headers)
+}
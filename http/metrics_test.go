@@ -0,0 +1,44 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/narumayase/anysher/internal/metricstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	// every test server is another host, they're kept as label values instead of being counted as other
+	os.Setenv("METRICS_MAX_LABEL_VALUES", "100000")
+	os.Exit(m.Run())
+}
+
+func TestHttpClientImpl_Metrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	client := NewClient(server.Client(), WithRetryPolicy(testRetryPolicy()))
+	resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	// every attempt is recorded
+	assert.Equal(t, float64(testRetryPolicy().MaxAttempts), metricstest.CounterValue(t, "anysher_http_client_requests_total",
+		map[string]string{"method": "GET", "host": host, "status": "503"}))
+	assert.Equal(t, uint64(testRetryPolicy().MaxAttempts), metricstest.HistogramCount(t, "anysher_http_client_request_duration_seconds",
+		map[string]string{"method": "GET", "host": host}))
+
+	server.Close()
+	_, err = client.Get(context.Background(), Payload{URL: server.URL})
+	assert.Error(t, err)
+	assert.Equal(t, float64(testRetryPolicy().MaxAttempts), metricstest.CounterValue(t, "anysher_http_client_requests_total",
+		map[string]string{"method": "GET", "host": host, "status": "error"}))
+}
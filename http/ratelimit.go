@@ -0,0 +1,167 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a request can't get a token from the rate limiter in time.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// RateLimiter limits the requests sent to each destination.
+type RateLimiter interface {
+	// Wait blocks until a request to the host can be sent. It returns an error wrapping
+	// ErrRateLimited when it fails fast or when the wait would exceed the context deadline.
+	Wait(ctx context.Context, host string) error
+}
+
+// RateLimitConfig defines a token bucket limit.
+type RateLimitConfig struct {
+	// RequestsPerSecond is the rate at which the bucket is refilled. It defaults to Burst, that is the
+	// bucket is refilled every second.
+	RequestsPerSecond float64
+	// Burst is the size of the bucket, that is the requests that can be sent at once. It defaults to 1.
+	Burst int
+	// FailFast returns ErrRateLimited instead of waiting for a token.
+	FailFast bool
+	// Name makes every request share a single bucket, instead of having one bucket for each host.
+	Name string
+}
+
+// withDefaults returns the config with the default values of the fields that aren't set.
+func (c RateLimitConfig) withDefaults() RateLimitConfig {
+	c.Burst = max(c.Burst, 1)
+	if c.RequestsPerSecond <= 0 || math.IsNaN(c.RequestsPerSecond) || math.IsInf(c.RequestsPerSecond, 0) {
+		c.RequestsPerSecond = float64(c.Burst)
+	}
+	return c
+}
+
+// key returns the name of the bucket used for the host.
+func (c RateLimitConfig) key(host string) string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return host
+}
+
+// tokenBucket is the in memory state of a bucket.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// memoryRateLimiter keeps a token bucket for each destination in memory.
+type memoryRateLimiter struct {
+	config  RateLimitConfig
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// NewRateLimiter creates an in memory token bucket RateLimiter.
+func NewRateLimiter(config RateLimitConfig) RateLimiter {
+	return &memoryRateLimiter{
+		config:  config.withDefaults(),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Wait takes a token from the bucket of the host, waiting for it to be refilled when it's empty.
+func (l *memoryRateLimiter) Wait(ctx context.Context, host string) error {
+	key := l.config.key(host)
+
+	l.mu.Lock()
+	bucket, ok := l.buckets[key]
+	now := time.Now()
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(l.config.Burst), last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(l.config.Burst),
+		bucket.tokens+now.Sub(bucket.last).Seconds()*l.config.RequestsPerSecond)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		l.mu.Unlock()
+		return nil
+	}
+	wait := time.Duration((1 - bucket.tokens) / l.config.RequestsPerSecond * float64(time.Second))
+	if err := checkWait(ctx, key, wait, l.config.FailFast); err != nil {
+		l.mu.Unlock()
+		return err
+	}
+	// reserve the next token so concurrent callers queue behind this one
+	bucket.tokens--
+	l.mu.Unlock()
+
+	if err := sleep(ctx, wait); err != nil {
+		l.mu.Lock()
+		bucket.tokens++
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Counter increments a counter in a store shared by several replicas. *redis.Repository implements it.
+type Counter interface {
+	Increment(ctx context.Context, key string, expiration time.Duration) (int64, error)
+}
+
+// sharedRateLimiter counts the requests of each destination in fixed windows of a shared store.
+type sharedRateLimiter struct {
+	config  RateLimitConfig
+	counter Counter
+	window  time.Duration
+}
+
+// NewSharedRateLimiter creates a RateLimiter whose quota is shared through the counter, eg: a *redis.Repository.
+// Requests are counted in windows of Burst/RequestsPerSecond, allowing Burst requests in each window.
+func NewSharedRateLimiter(counter Counter, config RateLimitConfig) RateLimiter {
+	config = config.withDefaults()
+	return &sharedRateLimiter{
+		config:  config,
+		counter: counter,
+		window:  time.Duration(float64(config.Burst) / config.RequestsPerSecond * float64(time.Second)),
+	}
+}
+
+// Wait counts the request in the current window, waiting for the next window when the current one is full.
+func (l *sharedRateLimiter) Wait(ctx context.Context, host string) error {
+	key := l.config.key(host)
+	for {
+		now := time.Now()
+		windowStart := now.Truncate(l.window)
+		count, err := l.counter.Increment(ctx,
+			fmt.Sprintf("ratelimit:%s:%d", key, windowStart.UnixNano()), 2*l.window)
+		if err != nil {
+			return fmt.Errorf("failed to count request for %s: %w", key, err)
+		}
+		if count <= int64(l.config.Burst) {
+			return nil
+		}
+		wait := windowStart.Add(l.window).Sub(now)
+		if err := checkWait(ctx, key, wait, l.config.FailFast); err != nil {
+			return err
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// checkWait tells if waiting for a token is allowed by the fail fast mode and the context deadline.
+func checkWait(ctx context.Context, key string, wait time.Duration, failFast bool) error {
+	if failFast {
+		return fmt.Errorf("%w: %s", ErrRateLimited, key)
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+		return fmt.Errorf("%w: %s, waiting %s would exceed the context deadline", ErrRateLimited, key, wait)
+	}
+	return nil
+}
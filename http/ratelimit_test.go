@@ -0,0 +1,155 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/narumayase/anysher/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRateLimiter(t *testing.T) {
+	t.Run("burst is allowed and then requests wait", func(t *testing.T) {
+		limiter := NewRateLimiter(RateLimitConfig{RequestsPerSecond: 50, Burst: 2})
+		ctx := context.Background()
+
+		start := time.Now()
+		assert.NoError(t, limiter.Wait(ctx, "a.local"))
+		assert.NoError(t, limiter.Wait(ctx, "a.local"))
+		assert.Less(t, time.Since(start), 10*time.Millisecond)
+
+		assert.NoError(t, limiter.Wait(ctx, "a.local"))
+		assert.GreaterOrEqual(t, time.Since(start), 15*time.Millisecond)
+	})
+
+	t.Run("each host has its own bucket", func(t *testing.T) {
+		limiter := NewRateLimiter(RateLimitConfig{RequestsPerSecond: 1, FailFast: true})
+		ctx := context.Background()
+
+		assert.NoError(t, limiter.Wait(ctx, "a.local"))
+		assert.NoError(t, limiter.Wait(ctx, "b.local"))
+		assert.ErrorIs(t, limiter.Wait(ctx, "a.local"), ErrRateLimited)
+	})
+
+	t.Run("named limiter shares the bucket", func(t *testing.T) {
+		limiter := NewRateLimiter(RateLimitConfig{RequestsPerSecond: 1, FailFast: true, Name: "partner"})
+		ctx := context.Background()
+
+		assert.NoError(t, limiter.Wait(ctx, "a.local"))
+		assert.ErrorIs(t, limiter.Wait(ctx, "b.local"), ErrRateLimited)
+	})
+
+	t.Run("burst without rate is refilled every second", func(t *testing.T) {
+		limiter := NewRateLimiter(RateLimitConfig{Burst: 2})
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		assert.NoError(t, limiter.Wait(ctx, "a.local"))
+		assert.NoError(t, limiter.Wait(ctx, "a.local"))
+		assert.ErrorIs(t, limiter.Wait(ctx, "a.local"), ErrRateLimited)
+	})
+
+	t.Run("fails when the wait exceeds the context deadline", func(t *testing.T) {
+		limiter := NewRateLimiter(RateLimitConfig{RequestsPerSecond: 1})
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		assert.NoError(t, limiter.Wait(ctx, "a.local"))
+		assert.ErrorIs(t, limiter.Wait(ctx, "a.local"), ErrRateLimited)
+	})
+
+	t.Run("concurrent callers queue for tokens", func(t *testing.T) {
+		limiter := NewRateLimiter(RateLimitConfig{RequestsPerSecond: 100, Burst: 1})
+		ctx := context.Background()
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, limiter.Wait(ctx, "a.local"))
+			}()
+		}
+		wg.Wait()
+		assert.GreaterOrEqual(t, time.Since(start), 35*time.Millisecond)
+	})
+}
+
+// *redis.Repository is the shared store of the rate limiter.
+var _ Counter = (*redis.Repository)(nil)
+
+// memoryCounter is an in memory Counter.
+type memoryCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+	err    error
+}
+
+func (c *memoryCounter) Increment(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	c.counts[key]++
+	return c.counts[key], nil
+}
+
+func TestSharedRateLimiter(t *testing.T) {
+	counter := &memoryCounter{counts: map[string]int64{}}
+	ctx := context.Background()
+
+	t.Run("fails fast when the window is full", func(t *testing.T) {
+		limiter := NewSharedRateLimiter(counter, RateLimitConfig{RequestsPerSecond: 1, FailFast: true})
+		assert.NoError(t, limiter.Wait(ctx, "a.local"))
+		assert.ErrorIs(t, limiter.Wait(ctx, "a.local"), ErrRateLimited)
+		assert.NoError(t, limiter.Wait(ctx, "b.local"))
+	})
+
+	t.Run("waits for the next window", func(t *testing.T) {
+		limiter := NewSharedRateLimiter(counter, RateLimitConfig{RequestsPerSecond: 100, Burst: 2, Name: "partner"})
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, limiter.Wait(ctx, "a.local"))
+		}
+		assert.Less(t, time.Since(start), 100*time.Millisecond)
+		assert.Contains(t, fmt.Sprint(counter.counts), "ratelimit:partner:")
+	})
+
+	t.Run("burst without rate", func(t *testing.T) {
+		limiter := NewSharedRateLimiter(counter, RateLimitConfig{Burst: 2, FailFast: true, Name: "burst-only"})
+		assert.Equal(t, time.Second, limiter.(*sharedRateLimiter).window)
+		assert.NoError(t, limiter.Wait(ctx, "a.local"))
+		assert.NoError(t, limiter.Wait(ctx, "a.local"))
+		assert.ErrorIs(t, limiter.Wait(ctx, "a.local"), ErrRateLimited)
+	})
+
+	t.Run("counter error", func(t *testing.T) {
+		limiter := NewSharedRateLimiter(&memoryCounter{err: assert.AnError}, RateLimitConfig{RequestsPerSecond: 1})
+		err := limiter.Wait(ctx, "a.local")
+		assert.ErrorIs(t, err, assert.AnError)
+	})
+}
+
+func TestClient_RateLimiter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.Client(),
+		WithRateLimiter(NewRateLimiter(RateLimitConfig{RequestsPerSecond: 1, FailFast: true})))
+
+	_, err := client.Get(context.Background(), Payload{URL: server.URL})
+	assert.NoError(t, err)
+
+	resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Nil(t, resp)
+}
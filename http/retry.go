@@ -0,0 +1,128 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// RetryPolicy defines how the Client retries a failed request.
+// A request is retried when the transport fails or when the response status code is one of
+// RetryableStatusCodes. Non-idempotent methods (POST, PATCH) are only retried when
+// RetryNonIdempotent is true or when the request carries an Idempotency-Key header.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts. A response whose Retry-After exceeds it isn't retried.
+	MaxBackoff time.Duration
+	// Multiplier is the exponential growth factor of the backoff.
+	Multiplier float64
+	// Jitter is the fraction (0 to 1) of the backoff that is randomized.
+	Jitter float64
+	// RetryableStatusCodes are the response status codes that trigger a retry.
+	RetryableStatusCodes []int
+	// RetryNonIdempotent allows retrying POST and PATCH requests without an Idempotency-Key.
+	RetryNonIdempotent bool
+}
+
+// DefaultRetryPolicy returns a policy with 3 attempts, exponential backoff starting at 100ms
+// with 20% jitter, and retries on 429, 502, 503 and 504.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryableStatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// canRetry tells if the request can be sent again according to its method and headers.
+func (p RetryPolicy) canRetry(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodPost, http.MethodPatch:
+		return p.RetryNonIdempotent || req.Header.Get(idempotencyKeyHeader) != ""
+	}
+	return true
+}
+
+// shouldRetry tells if the result of an attempt must be retried.
+func (p RetryPolicy) shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		// a cancelled or expired context can't be fixed by retrying
+		return ctx.Err() == nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return slices.Contains(p.RetryableStatusCodes, resp.StatusCode)
+}
+
+// backoff returns the wait before the given retry (1 for the first retry) and whether the request can
+// be retried. The Retry-After header of the response, when present, takes precedence, and the request
+// isn't retried when it exceeds MaxBackoff.
+func (p RetryPolicy) backoff(retry int, resp *http.Response) (time.Duration, bool) {
+	if wait, ok := retryAfter(resp); ok {
+		return wait, p.MaxBackoff <= 0 || wait <= p.MaxBackoff
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	wait := float64(p.InitialBackoff) * math.Pow(multiplier, float64(retry-1))
+	if p.MaxBackoff > 0 && wait > float64(p.MaxBackoff) {
+		wait = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		// spread the wait between (1 - jitter) and (1 + jitter) times the backoff
+		wait = wait * (1 - p.Jitter + 2*p.Jitter*rand.Float64())
+	}
+	return time.Duration(wait), true
+}
+
+// retryAfter parses the Retry-After header, given either in seconds or as an HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// sleep waits for the given duration or until the context is done.
+func sleep(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRetryPolicy() RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxBackoff = 5 * time.Millisecond
+	return policy
+}
+
+func TestClient_Retry(t *testing.T) {
+	t.Run("retries retryable status codes replaying the body", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			assert.Equal(t, `{"key":"value"}`, string(body))
+			if attempts.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithRetryPolicy(testRetryPolicy()))
+		resp, err := client.Put(context.Background(), Payload{URL: server.URL, Content: []byte(`{"key":"value"}`)})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int32(3), attempts.Load())
+	})
+
+	t.Run("returns the last response when attempts are exhausted", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithRetryPolicy(testRetryPolicy()))
+		resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.Equal(t, int32(3), attempts.Load())
+	})
+
+	t.Run("does not retry non retryable status codes", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithRetryPolicy(testRetryPolicy()))
+		resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Equal(t, int32(1), attempts.Load())
+	})
+
+	t.Run("post is only retried with an idempotency key or opt in", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithRetryPolicy(testRetryPolicy()))
+		_, err := client.Post(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(1), attempts.Load())
+
+		attempts.Store(0)
+		_, err = client.Post(context.Background(), Payload{
+			URL:     server.URL,
+			Headers: map[string]string{"Idempotency-Key": "123"},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), attempts.Load())
+
+		attempts.Store(0)
+		policy := testRetryPolicy()
+		policy.RetryNonIdempotent = true
+		client = NewClient(server.Client(), WithRetryPolicy(policy))
+		_, err = client.Post(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), attempts.Load())
+	})
+
+	t.Run("retries transport errors", func(t *testing.T) {
+		var attempts atomic.Int32
+		mockClient := &http.Client{Transport: &MockRoundTripper{
+			RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+				attempts.Add(1)
+				return nil, assert.AnError
+			},
+		}}
+		client := NewClient(mockClient, WithRetryPolicy(testRetryPolicy()))
+		resp, err := client.Get(context.Background(), Payload{URL: "http://example.com"})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to execute request")
+		assert.Nil(t, resp)
+		assert.Equal(t, int32(3), attempts.Load())
+	})
+
+	t.Run("stops waiting when the context is cancelled", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		policy := testRetryPolicy()
+		policy.MaxBackoff = time.Minute
+		client := NewClient(server.Client(), WithRetryPolicy(policy))
+		resp, err := client.Get(ctx, Payload{URL: server.URL})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Nil(t, resp)
+	})
+
+	t.Run("doesn't retry when Retry-After exceeds the maximum backoff", func(t *testing.T) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithRetryPolicy(testRetryPolicy()))
+		start := time.Now()
+		resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, int32(1), attempts.Load())
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     300 * time.Millisecond,
+		Multiplier:     2,
+	}
+	for retry, expected := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond} {
+		wait, ok := policy.backoff(retry+1, nil)
+		assert.True(t, ok)
+		assert.Equal(t, expected, wait)
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		wait, _ := policy.backoff(1, nil)
+		assert.GreaterOrEqual(t, wait, 50*time.Millisecond)
+		assert.LessOrEqual(t, wait, 150*time.Millisecond)
+	}
+
+	// a Retry-After beyond MaxBackoff isn't retried
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"2"}}}
+	wait, ok := policy.backoff(1, resp)
+	assert.False(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+
+	policy.MaxBackoff = 0
+	wait, ok = policy.backoff(1, resp)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+
+	resp.Header.Set("Retry-After", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+	wait, ok = policy.backoff(1, resp)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), wait)
+}
@@ -0,0 +1,300 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io"
+	"iter"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultReconnectDelay is the wait before reconnecting when the server doesn't send a retry field.
+	defaultReconnectDelay = 3 * time.Second
+	// maxStreamLineSize is the maximum size of a line of a stream.
+	maxStreamLineSize = 1 << 20
+)
+
+// Event is a Server-Sent Event.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	Retry time.Duration
+}
+
+// StreamOption configures optional behavior of the streams.
+type StreamOption func(*streamOptions)
+
+type streamOptions struct {
+	reconnect      bool
+	reconnectDelay time.Duration
+	maxReconnects  int
+}
+
+// WithoutReconnect stops the stream when the connection ends instead of reconnecting.
+func WithoutReconnect() StreamOption {
+	return func(o *streamOptions) {
+		o.reconnect = false
+	}
+}
+
+// WithReconnectDelay sets the wait before reconnecting until the server sends a retry field. It defaults to 3 seconds.
+func WithReconnectDelay(delay time.Duration) StreamOption {
+	return func(o *streamOptions) {
+		o.reconnectDelay = delay
+	}
+}
+
+// WithMaxReconnects limits the consecutive reconnections without receiving an event. It defaults to no limit.
+func WithMaxReconnects(reconnects int) StreamOption {
+	return func(o *streamOptions) {
+		o.maxReconnects = reconnects
+	}
+}
+
+// Events sends the request of the payload and iterates over the text/event-stream of the response.
+// When the connection ends, it reconnects sending the Last-Event-ID header. The iteration stops when the
+// context is cancelled, when the server answers with an error status code or when the loop breaks.
+func (c *Client) Events(ctx context.Context, payload Payload, opts ...StreamOption) iter.Seq2[Event, error] {
+	options := streamOptions{reconnect: true, reconnectDelay: defaultReconnectDelay}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	payload.SkipCache = true
+	payload.stream = true
+	payload.Headers = withHeader(payload.Headers, "Accept", "text/event-stream")
+	payload.Headers = withHeader(payload.Headers, "Cache-Control", "no-cache")
+
+	return func(yield func(Event, error) bool) {
+		lastEventID := ""
+		delay := options.reconnectDelay
+		reconnects := 0
+		for {
+			request := payload
+			if lastEventID != "" {
+				request.Headers = maps.Clone(payload.Headers)
+				request.Headers["Last-Event-ID"] = lastEventID
+			}
+			resp, err := c.Do(ctx, request)
+			if err == nil && resp.StatusCode != http.StatusOK {
+				// a status code other than 200 means the server doesn't want the client to reconnect
+				body, _ := io.ReadAll(io.LimitReader(resp.Body, defaultMaxResponseSize))
+				_ = resp.Body.Close()
+				yield(Event{}, c.newStatusError(resp, body))
+				return
+			}
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) {
+				// the Client was created WithStatusErrors
+				yield(Event{}, err)
+				return
+			}
+			if err == nil {
+				reader := newEventReader(resp.Body)
+				for {
+					var event Event
+					event, err = reader.next()
+					if err != nil {
+						break
+					}
+					reconnects = 0
+					lastEventID = event.ID
+					if !yield(event, nil) {
+						_ = resp.Body.Close()
+						return
+					}
+				}
+				_ = resp.Body.Close()
+				if reader.retry > 0 {
+					delay = reader.retry
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			reconnects++
+			if !options.reconnect || (options.maxReconnects > 0 && reconnects > options.maxReconnects) {
+				if err == io.EOF {
+					return
+				}
+				yield(Event{}, fmt.Errorf("event stream failed: %w", err))
+				return
+			}
+			log.Ctx(ctx).Warn().Err(safeError(err)).Msgf("event stream of %s ended, reconnecting in %s", safeRawURL(payload.URL), delay)
+			if sleep(ctx, delay) != nil {
+				return
+			}
+		}
+	}
+}
+
+// EventChannel works like Events but delivers the events on a channel. The error channel receives the
+// error that stopped the stream, if any. Both channels are closed when the stream stops.
+func (c *Client) EventChannel(ctx context.Context, payload Payload, opts ...StreamOption) (<-chan Event, <-chan error) {
+	events := make(chan Event)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(events)
+
+		for event, err := range c.Events(ctx, payload, opts...) {
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, errs
+}
+
+// StreamJSON sends the request of the payload and iterates over the line-delimited JSON (NDJSON) values of
+// the response. The iteration stops at the end of the stream, when the context is cancelled or when a line
+// can't be decoded.
+func StreamJSON[T any](ctx context.Context, c *Client, payload Payload) iter.Seq2[T, error] {
+	payload.SkipCache = true
+	payload.stream = true
+	payload.Headers = withHeader(payload.Headers, "Accept", "application/x-ndjson")
+
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		resp, err := c.Do(ctx, payload)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, defaultMaxResponseSize))
+			yield(zero, c.newStatusError(resp, body))
+			return
+		}
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineSize)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var value T
+			if err := json.Unmarshal(line, &value); err != nil {
+				yield(zero, fmt.Errorf("failed to unmarshal stream line: %w", err))
+				return
+			}
+			if !yield(value, nil) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			yield(zero, fmt.Errorf("stream failed: %w", err))
+		}
+	}
+}
+
+// eventReader parses a text/event-stream.
+type eventReader struct {
+	scanner     *bufio.Scanner
+	lastEventID string
+	retry       time.Duration
+}
+
+func newEventReader(body io.Reader) *eventReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineSize)
+	scanner.Split(scanEventLines)
+	return &eventReader{scanner: scanner}
+}
+
+// next returns the next event of the stream. It returns io.EOF when the stream ends.
+func (r *eventReader) next() (Event, error) {
+	var data strings.Builder
+	hasData := false
+	eventType := ""
+
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		if line == "" {
+			// an empty line dispatches the event
+			if !hasData {
+				eventType = ""
+				continue
+			}
+			if eventType == "" {
+				eventType = "message"
+			}
+			return Event{ID: r.lastEventID, Event: eventType, Data: data.String(), Retry: r.retry}, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			// comment
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		case "id":
+			if !strings.Contains(value, "\x00") {
+				r.lastEventID = value
+			}
+		case "retry":
+			if millis, err := strconv.Atoi(value); err == nil && millis >= 0 {
+				r.retry = time.Duration(millis) * time.Millisecond
+			}
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	// an incomplete event at the end of the stream is discarded
+	return Event{}, io.EOF
+}
+
+// scanEventLines splits lines ended by \r\n, \n or \r.
+func scanEventLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\r' {
+			if i+1 < len(data) {
+				if data[i+1] == '\n' {
+					return i + 2, data[:i], nil
+				}
+				return i + 1, data[:i], nil
+			}
+			if !atEOF {
+				// wait to know if \r is followed by \n
+				return 0, nil, nil
+			}
+		}
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,243 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventReader(t *testing.T) {
+	stream := ": a comment\r\n" +
+		"data: first\n\n" +
+		"event: token\rid: 1\rdata: multi\rdata:line\r\r" +
+		"id: 2\r\nretry: 1500\r\ndata\r\n\r\n" +
+		"event: ignored\n\n" +
+		"data: incomplete"
+
+	reader := newEventReader(strings.NewReader(stream))
+
+	event, err := reader.next()
+	assert.NoError(t, err)
+	assert.Equal(t, Event{Event: "message", Data: "first"}, event)
+
+	event, err = reader.next()
+	assert.NoError(t, err)
+	assert.Equal(t, Event{ID: "1", Event: "token", Data: "multi\nline"}, event)
+
+	event, err = reader.next()
+	assert.NoError(t, err)
+	assert.Equal(t, Event{ID: "2", Event: "message", Data: "", Retry: 1500 * time.Millisecond}, event)
+
+	_, err = reader.next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestClient_Events(t *testing.T) {
+	t.Run("reconnects with the last event id", func(t *testing.T) {
+		var connections atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "text/event-stream", r.Header.Get("Accept"))
+			w.Header().Set("Content-Type", "text/event-stream")
+			switch connections.Add(1) {
+			case 1:
+				assert.Empty(t, r.Header.Get("Last-Event-ID"))
+				fmt.Fprint(w, "retry: 10\nid: 1\ndata: a\n\nid: 2\ndata: b\n\n")
+			default:
+				assert.Equal(t, "2", r.Header.Get("Last-Event-ID"))
+				fmt.Fprint(w, "id: 3\ndata: c\n\n")
+			}
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		var data []string
+		for event, err := range client.Events(context.Background(), Payload{URL: server.URL}) {
+			assert.NoError(t, err)
+			data = append(data, event.Data)
+			if event.ID == "3" {
+				break
+			}
+		}
+		assert.Equal(t, []string{"a", "b", "c"}, data)
+		assert.Equal(t, int32(2), connections.Load())
+	})
+
+	t.Run("stops when the context is cancelled", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: a\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		client := NewClient(server.Client())
+		events, errs := client.EventChannel(ctx, Payload{URL: server.URL})
+
+		event := <-events
+		assert.Equal(t, "a", event.Data)
+		cancel()
+
+		_, open := <-events
+		assert.False(t, open)
+		assert.NoError(t, <-errs)
+	})
+
+	t.Run("error status stops the stream", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		events, errs := client.EventChannel(context.Background(), Payload{URL: server.URL})
+		_, open := <-events
+		assert.False(t, open)
+
+		err := <-errs
+		var statusErr *StatusError
+		assert.ErrorAs(t, err, &statusErr)
+		assert.Equal(t, http.StatusUnauthorized, statusErr.StatusCode)
+	})
+
+	t.Run("without reconnect the stream ends with the connection", func(t *testing.T) {
+		var connections atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			connections.Add(1)
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: a\n\n")
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		count := 0
+		for _, err := range client.Events(context.Background(), Payload{URL: server.URL}, WithoutReconnect()) {
+			assert.NoError(t, err)
+			count++
+		}
+		assert.Equal(t, 1, count)
+		assert.Equal(t, int32(1), connections.Load())
+	})
+
+	t.Run("gives up after the maximum reconnections", func(t *testing.T) {
+		mockClient := &http.Client{Transport: &MockRoundTripper{
+			RoundTripFunc: func(req *http.Request) (*http.Response, error) {
+				return nil, assert.AnError
+			},
+		}}
+		client := NewClient(mockClient)
+		var lastErr error
+		for _, err := range client.Events(context.Background(), Payload{URL: "http://example.com"},
+			WithReconnectDelay(time.Millisecond), WithMaxReconnects(2)) {
+			lastErr = err
+		}
+		assert.ErrorIs(t, lastErr, assert.AnError)
+	})
+}
+
+func TestStreamJSON(t *testing.T) {
+	type token struct {
+		Text string `json:"text"`
+	}
+
+	t.Run("decodes every line", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			fmt.Fprint(w, "{\"text\":\"hello\"}\n\n{\"text\":\"world\"}\n")
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		var texts []string
+		for value, err := range StreamJSON[token](context.Background(), client, Payload{URL: server.URL}) {
+			assert.NoError(t, err)
+			texts = append(texts, value.Text)
+		}
+		assert.Equal(t, []string{"hello", "world"}, texts)
+	})
+
+	t.Run("invalid line stops the stream", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "{\"text\":\"hello\"}\nnot json\n{\"text\":\"world\"}\n")
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		var texts []string
+		var lastErr error
+		for value, err := range StreamJSON[token](context.Background(), client, Payload{URL: server.URL}) {
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			texts = append(texts, value.Text)
+		}
+		assert.Equal(t, []string{"hello"}, texts)
+		assert.ErrorContains(t, lastErr, "failed to unmarshal stream line")
+	})
+}
+
+// TestClient_Streams_NotCoalesced tests that streams are read while they arrive by a Client that
+// coalesces and hedges the read requests, instead of being buffered.
+func TestClient_Streams_NotCoalesced(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		for i := 0; ; i++ {
+			if r.Header.Get("Accept") == "text/event-stream" {
+				_, _ = fmt.Fprintf(w, "data: %d\n\n", i)
+			} else {
+				_, _ = fmt.Fprintf(w, "{\"id\":%d}\n", i)
+			}
+			flusher.Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.Client(),
+		WithCoalescing(CoalescingConfig{}),
+		WithHedging(HedgingConfig{Delay: 10 * time.Millisecond}))
+
+	t.Run("events", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		var received []string
+		for event, err := range client.Events(ctx, Payload{URL: server.URL}) {
+			assert.NoError(t, err)
+			received = append(received, event.Data)
+			if len(received) == 3 {
+				break
+			}
+		}
+		assert.Equal(t, []string{"0", "1", "2"}, received)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		var received []int
+		for value, err := range StreamJSON[struct{ ID int }](ctx, client, Payload{URL: server.URL}) {
+			assert.NoError(t, err)
+			received = append(received, value.ID)
+			if len(received) == 3 {
+				break
+			}
+		}
+		assert.Equal(t, []int{0, 1, 2}, received)
+	})
+}
@@ -0,0 +1,100 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	otelpropagation "go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	originalProvider := otel.GetTracerProvider()
+	originalPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(otelpropagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(originalProvider)
+		otel.SetTextMapPropagator(originalPropagator)
+	})
+	return exporter
+}
+
+func TestHttpClientImpl_Tracing(t *testing.T) {
+	t.Run("client span with traceparent", func(t *testing.T) {
+		exporter := setupTracing(t)
+
+		var traceparent string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceparent = r.Header.Get("traceparent")
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client())
+		ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+		resp, err := client.Get(ctx, Payload{URL: server.URL + "/users?api_key=secret"})
+		parent.End()
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 2)
+		span := spans[0]
+		assert.Equal(t, "GET", span.Name)
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind)
+		assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext.TraceID())
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+		assert.Contains(t, span.Attributes, attribute.Int("http.response.status_code", 200))
+		assert.Contains(t, span.Attributes, attribute.String("url.full", server.URL+"/users"))
+		assert.Contains(t, traceparent, span.SpanContext.TraceID().String())
+		assert.Contains(t, traceparent, span.SpanContext.SpanID().String())
+	})
+
+	t.Run("one span per attempt", func(t *testing.T) {
+		exporter := setupTracing(t)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		client := NewClient(server.Client(), WithRetryPolicy(testRetryPolicy()))
+		resp, err := client.Get(context.Background(), Payload{URL: server.URL})
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, testRetryPolicy().MaxAttempts)
+		for _, span := range spans {
+			assert.Equal(t, codes.Error, span.Status.Code)
+		}
+		assert.Contains(t, spans[1].Attributes, attribute.Int("http.request.resend_count", 1))
+	})
+
+	t.Run("transport errors are recorded", func(t *testing.T) {
+		exporter := setupTracing(t)
+
+		client := NewClient(&http.Client{Transport: &MockRoundTripper{
+			RoundTripFunc: func(*http.Request) (*http.Response, error) {
+				return nil, assert.AnError
+			},
+		}})
+		_, err := client.Get(context.Background(), Payload{URL: "http://example.com"})
+		assert.Error(t, err)
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, codes.Error, spans[0].Status.Code)
+		assert.Len(t, spans[0].Events, 1)
+	})
+}
@@ -0,0 +1,480 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// upstreamScheme is the scheme of the URLs of the payloads sent to an upstream, eg: upstream://users/items/1.
+// They're resolved to the base URL of one of its endpoints on every attempt.
+const upstreamScheme = "upstream"
+
+// virtualNodes is the number of points each endpoint has in the consistent hash ring.
+const virtualNodes = 100
+
+// ErrNoHealthyEndpoint is returned when every endpoint of an upstream is ejected or failing its health check.
+var ErrNoHealthyEndpoint = errors.New("no healthy endpoint")
+
+// Balancer is the strategy used to pick the endpoint of an upstream for every attempt.
+type Balancer string
+
+const (
+	// RoundRobin picks the endpoints in turn.
+	RoundRobin Balancer = "round-robin"
+	// LeastOutstanding picks the endpoint with the fewest requests in progress.
+	LeastOutstanding Balancer = "least-outstanding"
+	// ConsistentHash picks the endpoint by the hash key of the payload, so the same key goes to the same
+	// endpoint while it's healthy, and only the keys of a removed endpoint move to another one.
+	ConsistentHash Balancer = "consistent-hash"
+)
+
+// HealthCheck defines the active health probes of the endpoints of an upstream.
+type HealthCheck struct {
+	// Path is requested with GET relative to the base URL of every endpoint, eg: /health. Probes are
+	// disabled when it's empty.
+	Path string
+	// Interval is the time between probes. It defaults to 10 seconds.
+	Interval time.Duration
+	// Timeout is the time a probe waits for a response. It defaults to 2 seconds.
+	Timeout time.Duration
+	// Client sends the probes. It defaults to an http.Client with the Timeout.
+	Client *http.Client
+}
+
+// UpstreamConfig defines the endpoints of an upstream and how they're picked and ejected.
+type UpstreamConfig struct {
+	// Endpoints are the base URLs of the replicas, eg: http://10.0.0.1:8080/api.
+	Endpoints []string
+	// Discovery finds the endpoints instead of Endpoints, see FileDiscovery and SRVDiscovery.
+	Discovery Discovery
+	// RefreshInterval is the time between discoveries, 0 to discover the endpoints only once.
+	RefreshInterval time.Duration
+	// Balancer picks the endpoint of every attempt. It defaults to RoundRobin.
+	Balancer Balancer
+	// HashKey returns the key of the payload used by ConsistentHash. It defaults to the URL of the payload.
+	HashKey func(payload Payload) string
+	// MaxFailures is the number of consecutive failures that eject an endpoint. It defaults to 3.
+	MaxFailures int
+	// EjectionTime is how long an ejected endpoint is left out. It defaults to 30 seconds.
+	EjectionTime time.Duration
+	// IsFailure classifies the result of a request. By default transport errors and 5xx responses are failures.
+	IsFailure func(resp *http.Response, err error) bool
+	// HealthCheck probes the endpoints, an endpoint failing its probe is left out until it passes one.
+	HealthCheck HealthCheck
+}
+
+// Endpoint is the state of an endpoint of an upstream.
+type Endpoint struct {
+	URL string
+	// Healthy is false while the endpoint is ejected or failing its health check.
+	Healthy bool
+	// Outstanding is the number of requests in progress.
+	Outstanding int
+}
+
+// Upstream is a named group of endpoints serving the same API, eg: the replicas of a service.
+// Payloads with its name in Upstream are sent to one of its endpoints, picked by its Balancer on every attempt.
+type Upstream struct {
+	name   string
+	config UpstreamConfig
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	ring      []ringNode
+	next      int
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// endpoint keeps the state of an endpoint of an upstream.
+type endpoint struct {
+	url          string
+	outstanding  int
+	failures     int
+	ejectedUntil time.Time
+	unhealthy    bool
+}
+
+// ringNode is a point of an endpoint in the consistent hash ring.
+type ringNode struct {
+	hash     uint64
+	endpoint *endpoint
+}
+
+// WithUpstream registers the upstream in the Client, so payloads can use its name in Upstream.
+func WithUpstream(upstream *Upstream) Option {
+	return func(c *Client) {
+		if c.upstreams == nil {
+			c.upstreams = make(map[string]*Upstream)
+		}
+		c.upstreams[upstream.name] = upstream
+	}
+}
+
+// NewUpstream creates an upstream with the endpoints of the config, discovering them when it has a Discovery.
+// Discoveries and health probes run in the background until Close is called.
+// It fails when there are no endpoints or when an endpoint isn't an absolute URL.
+func NewUpstream(name string, config UpstreamConfig) (*Upstream, error) {
+	if config.Balancer == "" {
+		config.Balancer = RoundRobin
+	}
+	if !slices.Contains([]Balancer{RoundRobin, LeastOutstanding, ConsistentHash}, config.Balancer) {
+		return nil, fmt.Errorf("invalid balancer %q of upstream %s", config.Balancer, name)
+	}
+	if config.HashKey == nil {
+		config.HashKey = func(payload Payload) string {
+			return payload.URL
+		}
+	}
+	if config.MaxFailures <= 0 {
+		config.MaxFailures = 3
+	}
+	if config.EjectionTime <= 0 {
+		config.EjectionTime = 30 * time.Second
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = func(resp *http.Response, err error) bool {
+			return err != nil || resp.StatusCode >= http.StatusInternalServerError
+		}
+	}
+	if config.HealthCheck.Interval <= 0 {
+		config.HealthCheck.Interval = 10 * time.Second
+	}
+	if config.HealthCheck.Timeout <= 0 {
+		config.HealthCheck.Timeout = 2 * time.Second
+	}
+	if config.HealthCheck.Client == nil {
+		config.HealthCheck.Client = &http.Client{Timeout: config.HealthCheck.Timeout}
+	}
+
+	u := &Upstream{
+		name:   name,
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	endpoints := config.Endpoints
+	if config.Discovery != nil {
+		var err error
+		if endpoints, err = config.Discovery.Discover(context.Background()); err != nil {
+			return nil, fmt.Errorf("failed to discover endpoints of upstream %s: %w", name, err)
+		}
+	}
+	if err := u.setEndpoints(endpoints); err != nil {
+		return nil, err
+	}
+
+	refresh := config.Discovery != nil && config.RefreshInterval > 0
+	if !refresh && config.HealthCheck.Path == "" {
+		close(u.done)
+		return u, nil
+	}
+	go u.run(refresh)
+	return u, nil
+}
+
+// NewUpstreamFromEnv creates an upstream with the configuration of the environment variables prefixed
+// with HTTP_UPSTREAM_<NAME>_, see loadUpstream, eg: HTTP_UPSTREAM_USERS_ENDPOINTS for the upstream users.
+func NewUpstreamFromEnv(name string) (*Upstream, error) {
+	// load configuration from environment
+	config, err := loadUpstream(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upstream %s: %w", name, err)
+	}
+	return NewUpstream(name, config)
+}
+
+// Name returns the name of the upstream.
+func (u *Upstream) Name() string {
+	return u.name
+}
+
+// Endpoints returns the state of the endpoints of the upstream.
+func (u *Upstream) Endpoints() []Endpoint {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	endpoints := make([]Endpoint, 0, len(u.endpoints))
+	for _, e := range u.endpoints {
+		endpoints = append(endpoints, Endpoint{URL: e.url, Healthy: e.available(now), Outstanding: e.outstanding})
+	}
+	return endpoints
+}
+
+// Close stops the discoveries and the health probes of the upstream.
+func (u *Upstream) Close() {
+	u.once.Do(func() {
+		close(u.stop)
+	})
+	<-u.done
+}
+
+// run discovers and probes the endpoints until the upstream is closed.
+func (u *Upstream) run(refresh bool) {
+	defer close(u.done)
+
+	var discoveries, probes <-chan time.Time
+	if refresh {
+		ticker := time.NewTicker(u.config.RefreshInterval)
+		defer ticker.Stop()
+		discoveries = ticker.C
+	}
+	if u.config.HealthCheck.Path != "" {
+		ticker := time.NewTicker(u.config.HealthCheck.Interval)
+		defer ticker.Stop()
+		probes = ticker.C
+		u.probe()
+	}
+	for {
+		select {
+		case <-u.stop:
+			return
+		case <-discoveries:
+			u.discover()
+		case <-probes:
+			u.probe()
+		}
+	}
+}
+
+// discover replaces the endpoints with the discovered ones, keeping them when the discovery fails.
+func (u *Upstream) discover() {
+	ctx, cancel := context.WithTimeout(context.Background(), u.config.RefreshInterval)
+	defer cancel()
+
+	endpoints, err := u.config.Discovery.Discover(ctx)
+	if err == nil {
+		err = u.setEndpoints(endpoints)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to refresh endpoints of upstream %s, keeping the previous ones", u.name)
+	}
+}
+
+// probe requests the health check path of every endpoint and leaves out the ones that don't return 2xx.
+func (u *Upstream) probe() {
+	u.mu.Lock()
+	endpoints := slices.Clone(u.endpoints)
+	u.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, e := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			healthy := u.check(e.url)
+
+			u.mu.Lock()
+			changed := e.unhealthy == healthy
+			e.unhealthy = !healthy
+			u.mu.Unlock()
+			if changed && healthy {
+				log.Info().Msgf("endpoint %s of upstream %s passed its health check", e.url, u.name)
+			} else if changed {
+				log.Warn().Msgf("endpoint %s of upstream %s failed its health check", e.url, u.name)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// check tells if the health check path of the endpoint returns 2xx.
+func (u *Upstream) check(base string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), u.config.HealthCheck.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, joinURL(base, u.config.HealthCheck.Path), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := u.config.HealthCheck.Client.Do(req)
+	if err != nil {
+		return false
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// setEndpoints replaces the endpoints, keeping the state of the ones that remain.
+func (u *Upstream) setEndpoints(urls []string) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("upstream %s has no endpoints", u.name)
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	current := make(map[string]*endpoint, len(u.endpoints))
+	for _, e := range u.endpoints {
+		current[e.url] = e
+	}
+	endpoints := make([]*endpoint, 0, len(urls))
+	for _, rawURL := range urls {
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Errorf("invalid endpoint %q of upstream %s, expected an absolute URL", rawURL, u.name)
+		}
+		base := strings.TrimRight(rawURL, "/")
+		if e, ok := current[base]; ok {
+			endpoints = append(endpoints, e)
+			delete(current, base)
+			continue
+		}
+		endpoints = append(endpoints, &endpoint{url: base})
+	}
+	u.endpoints = endpoints
+
+	u.ring = u.ring[:0]
+	for _, e := range endpoints {
+		for i := range virtualNodes {
+			u.ring = append(u.ring, ringNode{hash: hashKey(e.url + "#" + strconv.Itoa(i)), endpoint: e})
+		}
+	}
+	sort.Slice(u.ring, func(i, j int) bool { return u.ring[i].hash < u.ring[j].hash })
+	return nil
+}
+
+// acquire picks the endpoint for an attempt of the payload, avoiding the endpoint of the previous attempt
+// when there are others, and counts the request as outstanding until it's released.
+func (u *Upstream) acquire(payload Payload, previous string) (*endpoint, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := time.Now()
+	available := func(e *endpoint) bool {
+		return e.available(now) && e.url != previous
+	}
+	e := u.pick(payload, available)
+	if e == nil && previous != "" {
+		available = func(e *endpoint) bool {
+			return e.available(now)
+		}
+		e = u.pick(payload, available)
+	}
+	if e == nil {
+		return nil, fmt.Errorf("%w in upstream %s", ErrNoHealthyEndpoint, u.name)
+	}
+	e.outstanding++
+	return e, nil
+}
+
+// pick returns the endpoint chosen by the balancer among the available ones. It must be called holding the lock.
+func (u *Upstream) pick(payload Payload, available func(*endpoint) bool) *endpoint {
+	switch u.config.Balancer {
+	case ConsistentHash:
+		hash := hashKey(u.config.HashKey(payload))
+		start := sort.Search(len(u.ring), func(i int) bool { return u.ring[i].hash >= hash })
+		for i := range u.ring {
+			node := u.ring[(start+i)%len(u.ring)]
+			if available(node.endpoint) {
+				return node.endpoint
+			}
+		}
+		return nil
+	case LeastOutstanding:
+		var picked *endpoint
+		// the search starts at the next endpoint in turn, so ties are spread
+		for i := range u.endpoints {
+			e := u.endpoints[(u.next+i)%len(u.endpoints)]
+			if available(e) && (picked == nil || e.outstanding < picked.outstanding) {
+				picked = e
+			}
+		}
+		u.next++
+		return picked
+	default:
+		for range u.endpoints {
+			e := u.endpoints[u.next%len(u.endpoints)]
+			u.next++
+			if available(e) {
+				return e
+			}
+		}
+		return nil
+	}
+}
+
+// release records the result of a request to the endpoint, ejecting it after too many consecutive failures.
+func (u *Upstream) release(ctx context.Context, e *endpoint, resp *http.Response, err error) {
+	failed := u.config.IsFailure(resp, err)
+
+	u.mu.Lock()
+	e.outstanding--
+	if !failed {
+		e.failures = 0
+		u.mu.Unlock()
+		return
+	}
+	e.failures++
+	ejected := e.failures >= u.config.MaxFailures
+	if ejected {
+		e.failures = 0
+		e.ejectedUntil = time.Now().Add(u.config.EjectionTime)
+	}
+	u.mu.Unlock()
+
+	if ejected {
+		log.Ctx(ctx).Warn().Msgf("endpoint %s of upstream %s ejected for %s after %d consecutive failures",
+			e.url, u.name, u.config.EjectionTime, u.config.MaxFailures)
+	}
+}
+
+// cancel releases the endpoint of an attempt that wasn't sent, without recording a result.
+func (u *Upstream) cancel(e *endpoint) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	e.outstanding--
+}
+
+// available tells if the endpoint can receive requests. It must be called holding the lock.
+func (e *endpoint) available(now time.Time) bool {
+	return !e.unhealthy && !now.Before(e.ejectedUntil)
+}
+
+// upstream returns the upstream the URL is sent to, or nil when the URL doesn't belong to one.
+func (c *Client) upstream(payload Payload, url string) (*Upstream, error) {
+	if payload.Upstream == "" || !strings.HasPrefix(url, upstreamScheme+"://") {
+		return nil, nil
+	}
+	upstream, ok := c.upstreams[payload.Upstream]
+	if !ok {
+		return nil, fmt.Errorf("unknown upstream %s", payload.Upstream)
+	}
+	return upstream, nil
+}
+
+// upstreamURL returns the URL of the payload relative to the upstream, resolved later against an endpoint.
+func upstreamURL(payload Payload) string {
+	return upstreamScheme + "://" + payload.Upstream
+}
+
+// resolve replaces the upstream of the URL with the base URL of the endpoint.
+func (e *endpoint) resolve(rawURL string) string {
+	_, path, _ := strings.Cut(strings.TrimPrefix(rawURL, upstreamScheme+"://"), "/")
+	return joinURL(e.url, path)
+}
+
+// joinURL joins the base URL and the path.
+func joinURL(base, path string) string {
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
@@ -0,0 +1,255 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// replica is a test server that counts its requests and answers with its status.
+type replica struct {
+	*httptest.Server
+	requests atomic.Int32
+	status   atomic.Int32
+	health   atomic.Int32
+}
+
+func newReplica(t *testing.T) *replica {
+	r := &replica{}
+	r.status.Store(http.StatusOK)
+	r.health.Store(http.StatusOK)
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/api/health" {
+			w.WriteHeader(int(r.health.Load()))
+			return
+		}
+		r.requests.Add(1)
+		assert.Equal(t, "/api/items/1", req.URL.Path)
+		assert.Equal(t, "a-value", req.URL.Query().Get("q"))
+		w.WriteHeader(int(r.status.Load()))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func sendToUpstream(t *testing.T, client *Client, times int) {
+	for range times {
+		resp, err := client.Get(context.Background(), Payload{
+			Upstream: "items",
+			URL:      "/items/1",
+			Query:    map[string]string{"q": "a-value"},
+		})
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+}
+
+func TestClient_Upstream(t *testing.T) {
+	t.Run("round robin", func(t *testing.T) {
+		a, b, c := newReplica(t), newReplica(t), newReplica(t)
+		upstream, err := NewUpstream("items", UpstreamConfig{Endpoints: []string{a.URL + "/api", b.URL + "/api/", c.URL + "/api"}})
+		assert.NoError(t, err)
+		client := NewClient(&http.Client{}, WithUpstream(upstream))
+
+		sendToUpstream(t, client, 6)
+		assert.Equal(t, int32(2), a.requests.Load())
+		assert.Equal(t, int32(2), b.requests.Load())
+		assert.Equal(t, int32(2), c.requests.Load())
+	})
+
+	t.Run("failing endpoints are ejected", func(t *testing.T) {
+		a, b := newReplica(t), newReplica(t)
+		a.status.Store(http.StatusInternalServerError)
+		upstream, err := NewUpstream("items", UpstreamConfig{
+			Endpoints:    []string{a.URL + "/api", b.URL + "/api"},
+			MaxFailures:  2,
+			EjectionTime: 50 * time.Millisecond,
+		})
+		assert.NoError(t, err)
+		client := NewClient(&http.Client{}, WithUpstream(upstream))
+
+		sendToUpstream(t, client, 6)
+		assert.Equal(t, int32(2), a.requests.Load())
+		assert.Equal(t, int32(4), b.requests.Load())
+		assert.False(t, upstream.Endpoints()[0].Healthy)
+
+		// the endpoint is back after the ejection time
+		time.Sleep(60 * time.Millisecond)
+		a.status.Store(http.StatusOK)
+		sendToUpstream(t, client, 2)
+		assert.Equal(t, int32(3), a.requests.Load())
+		assert.True(t, upstream.Endpoints()[0].Healthy)
+	})
+
+	t.Run("retries go to another endpoint", func(t *testing.T) {
+		a, b := newReplica(t), newReplica(t)
+		a.status.Store(http.StatusServiceUnavailable)
+		upstream, err := NewUpstream("items", UpstreamConfig{Endpoints: []string{a.URL + "/api", b.URL + "/api"}})
+		assert.NoError(t, err)
+		client := NewClient(&http.Client{}, WithUpstream(upstream), WithRetryPolicy(testRetryPolicy()))
+
+		resp, err := client.Get(context.Background(), Payload{Upstream: "items", URL: "items/1?q=a-value"})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int32(1), a.requests.Load())
+		assert.Equal(t, int32(1), b.requests.Load())
+	})
+
+	t.Run("every endpoint ejected", func(t *testing.T) {
+		a := newReplica(t)
+		a.status.Store(http.StatusBadGateway)
+		upstream, err := NewUpstream("items", UpstreamConfig{Endpoints: []string{a.URL + "/api"}, MaxFailures: 1})
+		assert.NoError(t, err)
+		client := NewClient(&http.Client{}, WithUpstream(upstream))
+
+		sendToUpstream(t, client, 1)
+		_, err = client.Get(context.Background(), Payload{Upstream: "items", URL: "/items/1"})
+		assert.ErrorIs(t, err, ErrNoHealthyEndpoint)
+	})
+
+	t.Run("unknown upstream", func(t *testing.T) {
+		client := NewClient(&http.Client{})
+
+		_, err := client.Get(context.Background(), Payload{Upstream: "items", URL: "/items/1"})
+		assert.ErrorContains(t, err, "unknown upstream items")
+	})
+
+	t.Run("health checks", func(t *testing.T) {
+		a, b := newReplica(t), newReplica(t)
+		a.health.Store(http.StatusServiceUnavailable)
+		upstream, err := NewUpstream("items", UpstreamConfig{
+			Endpoints:   []string{a.URL + "/api", b.URL + "/api"},
+			HealthCheck: HealthCheck{Path: "/health", Interval: 10 * time.Millisecond},
+		})
+		assert.NoError(t, err)
+		defer upstream.Close()
+		client := NewClient(&http.Client{}, WithUpstream(upstream))
+
+		assert.Eventually(t, func() bool { return !upstream.Endpoints()[0].Healthy }, time.Second, 5*time.Millisecond)
+		sendToUpstream(t, client, 2)
+		assert.Equal(t, int32(0), a.requests.Load())
+
+		a.health.Store(http.StatusOK)
+		assert.Eventually(t, func() bool { return upstream.Endpoints()[0].Healthy }, time.Second, 5*time.Millisecond)
+	})
+}
+
+func TestUpstream_Balancers(t *testing.T) {
+	endpoints := []string{"http://a:8080", "http://b:8080", "http://c:8080"}
+
+	t.Run("least outstanding", func(t *testing.T) {
+		upstream, err := NewUpstream("items", UpstreamConfig{Endpoints: endpoints, Balancer: LeastOutstanding})
+		assert.NoError(t, err)
+
+		first, err := upstream.acquire(Payload{}, "")
+		assert.NoError(t, err)
+		second, err := upstream.acquire(Payload{}, "")
+		assert.NoError(t, err)
+		upstream.cancel(first)
+
+		// the first endpoint is free again, the second one isn't
+		for range 3 {
+			e, err := upstream.acquire(Payload{}, "")
+			assert.NoError(t, err)
+			assert.NotEqual(t, second.url, e.url)
+			upstream.cancel(e)
+		}
+	})
+
+	t.Run("consistent hash", func(t *testing.T) {
+		upstream, err := NewUpstream("items", UpstreamConfig{Endpoints: endpoints, Balancer: ConsistentHash})
+		assert.NoError(t, err)
+
+		picked := make(map[string]string)
+		for i := range 100 {
+			key := fmt.Sprintf("/items/%d", i)
+			e, err := upstream.acquire(Payload{URL: key}, "")
+			assert.NoError(t, err)
+			upstream.cancel(e)
+			picked[key] = e.url
+
+			// the same key goes to the same endpoint
+			again, _ := upstream.acquire(Payload{URL: key}, "")
+			upstream.cancel(again)
+			assert.Equal(t, e.url, again.url)
+		}
+
+		// only the keys of the removed endpoint move
+		assert.NoError(t, upstream.setEndpoints(endpoints[:2]))
+		for key, url := range picked {
+			e, err := upstream.acquire(Payload{URL: key}, "")
+			assert.NoError(t, err)
+			upstream.cancel(e)
+			if url != endpoints[2] {
+				assert.Equal(t, url, e.url, key)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewUpstream("items", UpstreamConfig{Endpoints: endpoints, Balancer: "random"})
+		assert.ErrorContains(t, err, `invalid balancer "random"`)
+
+		_, err = NewUpstream("items", UpstreamConfig{Endpoints: []string{"a:8080"}})
+		assert.ErrorContains(t, err, "expected an absolute URL")
+
+		_, err = NewUpstream("items", UpstreamConfig{})
+		assert.ErrorContains(t, err, "upstream items has no endpoints")
+	})
+}
+
+func TestUpstream_Discovery(t *testing.T) {
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "endpoints")
+		assert.NoError(t, os.WriteFile(path, []byte("# replicas\nhttp://a:8080\n\nhttp://b:8080\n"), 0o600))
+
+		upstream, err := NewUpstream("items", UpstreamConfig{Discovery: FileDiscovery(path), RefreshInterval: 10 * time.Millisecond})
+		assert.NoError(t, err)
+		defer upstream.Close()
+		assert.Len(t, upstream.Endpoints(), 2)
+
+		assert.NoError(t, os.WriteFile(path, []byte("http://c:8080\n"), 0o600))
+		assert.Eventually(t, func() bool {
+			endpoints := upstream.Endpoints()
+			return len(endpoints) == 1 && endpoints[0].URL == "http://c:8080"
+		}, time.Second, 5*time.Millisecond)
+
+		// a failed discovery keeps the endpoints
+		assert.NoError(t, os.Remove(path))
+		time.Sleep(30 * time.Millisecond)
+		assert.Len(t, upstream.Endpoints(), 1)
+	})
+
+	t.Run("srv", func(t *testing.T) {
+		lookup := lookupSRV
+		defer func() { lookupSRV = lookup }()
+		lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+			assert.Equal(t, "_http._tcp.items.service.consul", name)
+			return "", []*net.SRV{
+				{Target: "a.node.consul.", Port: 8080, Priority: 1},
+				{Target: "b.node.consul.", Port: 8081, Priority: 1},
+				{Target: "backup.node.consul.", Port: 8080, Priority: 2},
+			}, nil
+		}
+
+		endpoints, err := SRVDiscovery("https", "_http._tcp.items.service.consul").Discover(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"https://a.node.consul:8080", "https://b.node.consul:8081"}, endpoints)
+
+		lookupSRV = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+			return "", nil, errors.New("no such host")
+		}
+		_, err = NewUpstream("items", UpstreamConfig{Discovery: SRVDiscovery("http", "_http._tcp.items.service.consul")})
+		assert.ErrorContains(t, err, "failed to discover endpoints of upstream items")
+	})
+}
@@ -0,0 +1,65 @@
+// Package metricstest reads the values recorded in the metrics registry from the tests of every component.
+package metricstest
+
+import (
+	"testing"
+
+	"github.com/narumayase/anysher/metrics"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// CounterValue returns the value of the counter with the given labels, 0 when it wasn't recorded yet.
+func CounterValue(t *testing.T, name string, labels map[string]string) float64 {
+	if metric := find(t, name, labels); metric != nil {
+		return metric.GetCounter().GetValue()
+	}
+	return 0
+}
+
+// GaugeValue returns the value of the gauge with the given labels, 0 when it wasn't recorded yet.
+func GaugeValue(t *testing.T, name string, labels map[string]string) float64 {
+	if metric := find(t, name, labels); metric != nil {
+		return metric.GetGauge().GetValue()
+	}
+	return 0
+}
+
+// HistogramCount returns the number of observations of the histogram with the given labels.
+func HistogramCount(t *testing.T, name string, labels map[string]string) uint64 {
+	if metric := find(t, name, labels); metric != nil {
+		return metric.GetHistogram().GetSampleCount()
+	}
+	return 0
+}
+
+func find(t *testing.T, name string, labels map[string]string) *dto.Metric {
+	t.Helper()
+	families, err := metrics.Registry().Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if matches(metric, labels) {
+				return metric
+			}
+		}
+	}
+	return nil
+}
+
+func matches(metric *dto.Metric, labels map[string]string) bool {
+	matched := 0
+	for _, pair := range metric.GetLabel() {
+		if value, ok := labels[pair.GetName()]; ok {
+			if value != pair.GetValue() {
+				return false
+			}
+			matched++
+		}
+	}
+	return matched == len(labels)
+}
@@ -1,36 +1,274 @@
 package kafka
 
 import (
+	"errors"
+	"fmt"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/joho/godotenv"
 	anysherlog "github.com/narumayase/anysher/log"
 	"github.com/rs/zerolog/log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// propertyPrefix is the prefix of the environment variables passed to librdkafka as properties.
+const propertyPrefix = "KAFKA_PROP_"
+
 // Config contains the application configuration for Kafka.
 type Config struct {
-	kafkaBroker string
-	kafkaTopic  string
+	kafkaBroker        string
+	kafkaTopic         string
+	securityProtocol   string
+	saslMechanism      string
+	saslUsername       string
+	saslPassword       string
+	sslCALocation      string
+	sslCertLocation    string
+	sslKeyLocation     string
+	sslKeyPassword     string
+	acks               string
+	compression        string
+	messageMaxBytes    int
+	requestTimeout     time.Duration
+	properties         map[string]string
+	allowedTopics      []string
+	lingerMs           int
+	batchSize          int
+	idempotence        bool
+	transactionalID    string
+	transactionTimeout time.Duration
+	groupID            string
+	topics             []string
+	commitStrategy     string
+	autoCommitInterval time.Duration
+	autoOffsetReset    string
+	retryBackoff       time.Duration
+	// parseErrors are the errors of the values that can't be parsed, returned by validate
+	parseErrors []error
 }
 
 // load creates a new Config instance for Kafka implementation.
 // It takes the configuration from environment variables:
 // - KAFKA_BROKER
 // - KAFKA_TOPIC
+// - KAFKA_SECURITY_PROTOCOL -> plaintext, ssl, sasl_plaintext or sasl_ssl
+// - KAFKA_SASL_MECHANISM -> PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+// - KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD
+// - KAFKA_SSL_CA_LOCATION, KAFKA_SSL_CERTIFICATE_LOCATION, KAFKA_SSL_KEY_LOCATION and KAFKA_SSL_KEY_PASSWORD
+// - KAFKA_ACKS -> all, 0 or 1
+// - KAFKA_COMPRESSION -> none, gzip, snappy, lz4 or zstd
+// - KAFKA_MESSAGE_MAX_BYTES -> maximum size of a request to the brokers
+// - KAFKA_REQUEST_TIMEOUT -> time the producer waits for the acknowledgement of the brokers, eg: 30s
+// - KAFKA_PROP_* -> librdkafka properties, eg: KAFKA_PROP_SOCKET_KEEPALIVE_ENABLE=true sets socket.keepalive.enable
+// - KAFKA_ALLOWED_TOPICS -> topics separated by pipe the Repository can send to besides KAFKA_TOPIC, eg: orders|payments
+// - KAFKA_LINGER_MS -> time the producer waits to batch messages, eg: 5
+// - KAFKA_BATCH_SIZE -> maximum size in bytes of a batch of messages
+// - KAFKA_IDEMPOTENCE -> true to enable the idempotent producer
+// - KAFKA_TRANSACTIONAL_ID -> transactional ID of the producer, it enables the transactions and the idempotence
+// - KAFKA_TRANSACTION_TIMEOUT -> time after which the broker aborts an unfinished transaction, eg: 60s
+// - KAFKA_GROUP_ID -> consumer group of the Consumer
+// - KAFKA_TOPICS -> topics separated by pipe consumed by the Consumer, eg: orders|payments (default: KAFKA_TOPIC)
+// - KAFKA_COMMIT_STRATEGY -> after-handler, auto or transaction
+// - KAFKA_AUTO_COMMIT_INTERVAL -> time between commits of the auto strategy, eg: 5s
+// - KAFKA_AUTO_OFFSET_RESET -> where a group without committed offsets starts, earliest or latest
+// - KAFKA_RETRY_BACKOFF -> wait before consuming again a message whose handler failed
 // - LOG_LEVEL
+// The values that can't be parsed keep their defaults and their errors are returned by validate.
 func load() Config {
 	// Load .env file if it exists (ignore error if file doesn't exist)
 	if err := godotenv.Load(); err != nil {
 		log.Printf("No .env file found or error loading .env file: %v", err)
 	}
+	var errs []error
 	config := Config{
-		kafkaBroker: getEnv("KAFKA_BROKER", "localhost:9092"),
-		kafkaTopic:  getEnv("KAFKA_TOPIC", "a-topic"),
+		kafkaBroker:        getEnv("KAFKA_BROKER", "localhost:9092"),
+		kafkaTopic:         getEnv("KAFKA_TOPIC", "a-topic"),
+		securityProtocol:   strings.ToLower(getEnv("KAFKA_SECURITY_PROTOCOL", "")),
+		saslMechanism:      strings.ToUpper(getEnv("KAFKA_SASL_MECHANISM", "")),
+		saslUsername:       getEnv("KAFKA_SASL_USERNAME", ""),
+		saslPassword:       getEnv("KAFKA_SASL_PASSWORD", ""),
+		sslCALocation:      getEnv("KAFKA_SSL_CA_LOCATION", ""),
+		sslCertLocation:    getEnv("KAFKA_SSL_CERTIFICATE_LOCATION", ""),
+		sslKeyLocation:     getEnv("KAFKA_SSL_KEY_LOCATION", ""),
+		sslKeyPassword:     getEnv("KAFKA_SSL_KEY_PASSWORD", ""),
+		acks:               strings.ToLower(getEnv("KAFKA_ACKS", "")),
+		compression:        strings.ToLower(getEnv("KAFKA_COMPRESSION", "")),
+		messageMaxBytes:    getEnvAsInt("KAFKA_MESSAGE_MAX_BYTES", 0, &errs),
+		requestTimeout:     getEnvAsDuration("KAFKA_REQUEST_TIMEOUT", 0, &errs),
+		properties:         getProperties(),
+		lingerMs:           getEnvAsInt("KAFKA_LINGER_MS", 5, &errs),
+		batchSize:          getEnvAsInt("KAFKA_BATCH_SIZE", 1000000, &errs),
+		idempotence:        getEnvAsBool("KAFKA_IDEMPOTENCE", false),
+		transactionalID:    getEnv("KAFKA_TRANSACTIONAL_ID", ""),
+		transactionTimeout: getEnvAsDuration("KAFKA_TRANSACTION_TIMEOUT", time.Minute, &errs),
+		groupID:            getEnv("KAFKA_GROUP_ID", ""),
+		commitStrategy:     getEnv("KAFKA_COMMIT_STRATEGY", string(CommitAfterHandler)),
+		autoCommitInterval: getEnvAsDuration("KAFKA_AUTO_COMMIT_INTERVAL", 5*time.Second, &errs),
+		autoOffsetReset:    getEnv("KAFKA_AUTO_OFFSET_RESET", "earliest"),
+		retryBackoff:       getEnvAsDuration("KAFKA_RETRY_BACKOFF", time.Second, &errs),
 	}
+	config.allowedTopics = parseList(getEnv("KAFKA_ALLOWED_TOPICS", ""))
+	config.topics = parseList(getEnv("KAFKA_TOPICS", config.kafkaTopic))
+	config.parseErrors = errs
 	anysherlog.SetLogLevel()
 	return config
 }
 
+// validate checks the connection and producer settings, so a misconfiguration fails at construction
+// instead of on the first connection.
+func (c Config) validate() error {
+	if err := errors.Join(c.parseErrors...); err != nil {
+		return err
+	}
+	sasl := false
+	switch c.securityProtocol {
+	case "", "plaintext", "ssl":
+	case "sasl_plaintext", "sasl_ssl":
+		sasl = true
+	default:
+		return fmt.Errorf("invalid KAFKA_SECURITY_PROTOCOL %q, expected plaintext, ssl, sasl_plaintext or sasl_ssl", c.securityProtocol)
+	}
+	switch c.saslMechanism {
+	case "":
+		if sasl {
+			return fmt.Errorf("KAFKA_SASL_MECHANISM is required with KAFKA_SECURITY_PROTOCOL %s", c.securityProtocol)
+		}
+	case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+		if !sasl {
+			return errors.New("KAFKA_SASL_MECHANISM requires KAFKA_SECURITY_PROTOCOL sasl_plaintext or sasl_ssl")
+		}
+		if c.saslUsername == "" || c.saslPassword == "" {
+			return fmt.Errorf("KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD are required with KAFKA_SASL_MECHANISM %s", c.saslMechanism)
+		}
+	default:
+		return fmt.Errorf("invalid KAFKA_SASL_MECHANISM %q, expected PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512", c.saslMechanism)
+	}
+	if (c.sslCertLocation == "") != (c.sslKeyLocation == "") {
+		return errors.New("KAFKA_SSL_CERTIFICATE_LOCATION and KAFKA_SSL_KEY_LOCATION must be set together")
+	}
+	for key, path := range map[string]string{
+		"KAFKA_SSL_CA_LOCATION":          c.sslCALocation,
+		"KAFKA_SSL_CERTIFICATE_LOCATION": c.sslCertLocation,
+		"KAFKA_SSL_KEY_LOCATION":         c.sslKeyLocation,
+	} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	switch c.acks {
+	case "", "all", "-1":
+	case "0", "1":
+		if c.idempotence || c.transactionalID != "" {
+			return fmt.Errorf("KAFKA_ACKS %s can't be used with the idempotent producer, expected all", c.acks)
+		}
+	default:
+		return fmt.Errorf("invalid KAFKA_ACKS %q, expected all, 0 or 1", c.acks)
+	}
+	switch c.compression {
+	case "", "none", "gzip", "snappy", "lz4", "zstd":
+	default:
+		return fmt.Errorf("invalid KAFKA_COMPRESSION %q, expected none, gzip, snappy, lz4 or zstd", c.compression)
+	}
+	if c.messageMaxBytes < 0 {
+		return fmt.Errorf("invalid KAFKA_MESSAGE_MAX_BYTES %d", c.messageMaxBytes)
+	}
+	if c.requestTimeout < 0 {
+		return fmt.Errorf("invalid KAFKA_REQUEST_TIMEOUT %s", c.requestTimeout)
+	}
+	return nil
+}
+
+// clientConfig returns the librdkafka properties shared by the producer and the consumer.
+func (c Config) clientConfig() kafka.ConfigMap {
+	configMap := kafka.ConfigMap{"bootstrap.servers": c.kafkaBroker}
+	setIfNotEmpty(configMap, "security.protocol", c.securityProtocol)
+	setIfNotEmpty(configMap, "sasl.mechanisms", c.saslMechanism)
+	setIfNotEmpty(configMap, "sasl.username", c.saslUsername)
+	setIfNotEmpty(configMap, "sasl.password", c.saslPassword)
+	setIfNotEmpty(configMap, "ssl.ca.location", c.sslCALocation)
+	setIfNotEmpty(configMap, "ssl.certificate.location", c.sslCertLocation)
+	setIfNotEmpty(configMap, "ssl.key.location", c.sslKeyLocation)
+	setIfNotEmpty(configMap, "ssl.key.password", c.sslKeyPassword)
+	if c.messageMaxBytes > 0 {
+		configMap["message.max.bytes"] = c.messageMaxBytes
+	}
+	return configMap
+}
+
+// producerConfig returns the librdkafka properties of the producer, the KAFKA_PROP_* ones replace the others.
+func (c Config) producerConfig() kafka.ConfigMap {
+	configMap := c.clientConfig()
+	configMap["linger.ms"] = c.lingerMs
+	configMap["batch.size"] = c.batchSize
+	setIfNotEmpty(configMap, "acks", c.acks)
+	setIfNotEmpty(configMap, "compression.codec", c.compression)
+	if c.requestTimeout > 0 {
+		configMap["request.timeout.ms"] = int(c.requestTimeout.Milliseconds())
+	}
+	if c.idempotence || c.transactionalID != "" {
+		configMap["enable.idempotence"] = true
+	}
+	if c.transactionalID != "" {
+		configMap["transactional.id"] = c.transactionalID
+		configMap["transaction.timeout.ms"] = int(c.transactionTimeout.Milliseconds())
+	}
+	return c.withProperties(configMap)
+}
+
+// withProperties sets the KAFKA_PROP_* properties into the config map.
+func (c Config) withProperties(configMap kafka.ConfigMap) kafka.ConfigMap {
+	for key, value := range c.properties {
+		configMap[key] = value
+	}
+	return configMap
+}
+
+func setIfNotEmpty(configMap kafka.ConfigMap, key, value string) {
+	if value != "" {
+		configMap[key] = value
+	}
+}
+
+// getProperties returns the librdkafka properties of the KAFKA_PROP_* environment variables.
+// The name of the property is the rest of the variable name in lower case, with dots instead of
+// underscores and underscores instead of double underscores, eg: KAFKA_PROP_SOCKET_KEEPALIVE_ENABLE
+// is socket.keepalive.enable.
+func getProperties() map[string]string {
+	var properties map[string]string
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		name, ok := strings.CutPrefix(key, propertyPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		if properties == nil {
+			properties = make(map[string]string)
+		}
+		parts := strings.Split(strings.ToLower(name), "__")
+		for i, part := range parts {
+			parts[i] = strings.ReplaceAll(part, "_", ".")
+		}
+		properties[strings.Join(parts, "_")] = value
+	}
+	return properties
+}
+
+// parseList splits a list separated by pipe, ignoring empty items.
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 // getEnv gets an environment variable or returns a default value
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -38,3 +276,39 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvAsDuration retrieves environment variable as a duration, eg: 500ms or 30s, with a default value,
+// which is also returned when the value can't be parsed, adding the error to errs
+func getEnvAsDuration(key string, defaultValue time.Duration, errs *[]error) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("error converting %s to duration: %w", key, err))
+			return defaultValue
+		}
+		return duration
+	}
+	return defaultValue
+}
+
+// getEnvAsInt retrieves environment variable as integer with a default value, which is also returned
+// when the value can't be parsed, adding the error to errs
+func getEnvAsInt(key string, defaultValue int, errs *[]error) int {
+	if value := os.Getenv(key); value != "" {
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("error converting %s to int: %w", key, err))
+			return defaultValue
+		}
+		return intValue
+	}
+	return defaultValue
+}
+
+// getEnvAsBool retrieves environment variable as boolean with a default value
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		return strings.ToLower(value) == "true"
+	}
+	return defaultValue
+}
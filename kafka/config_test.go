@@ -1,9 +1,12 @@
 package kafka
 
 import (
+	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestNewConfiguration(t *testing.T) {
@@ -21,10 +24,185 @@ func TestNewConfiguration(t *testing.T) {
 		broker: "localhost:9092",
 		topic:  "test-topic",
 		expectedCfg: Config{
-			kafkaBroker: "localhost:9092",
-			kafkaTopic:  "test-topic",
+			kafkaBroker:        "localhost:9092",
+			kafkaTopic:         "test-topic",
+			lingerMs:           5,
+			batchSize:          1000000,
+			transactionTimeout: time.Minute,
+			topics:             []string{"test-topic"},
+			commitStrategy:     "after-handler",
+			autoCommitInterval: 5 * time.Second,
+			autoOffsetReset:    "earliest",
+			retryBackoff:       time.Second,
 		},
 	}
 	cfg := load()
 	assert.Equal(t, expectedConfig.expectedCfg, cfg)
 }
+
+func TestLoad_Consumer(t *testing.T) {
+	t.Setenv("KAFKA_GROUP_ID", "a-group")
+	t.Setenv("KAFKA_TOPICS", "orders| payments|")
+	t.Setenv("KAFKA_COMMIT_STRATEGY", "auto")
+	t.Setenv("KAFKA_AUTO_COMMIT_INTERVAL", "1s")
+	t.Setenv("KAFKA_AUTO_OFFSET_RESET", "latest")
+	t.Setenv("KAFKA_RETRY_BACKOFF", "250ms")
+
+	cfg := load()
+	assert.Equal(t, "a-group", cfg.groupID)
+	assert.Equal(t, []string{"orders", "payments"}, cfg.topics)
+	assert.Equal(t, "auto", cfg.commitStrategy)
+	assert.Equal(t, time.Second, cfg.autoCommitInterval)
+	assert.Equal(t, "latest", cfg.autoOffsetReset)
+	assert.Equal(t, 250*time.Millisecond, cfg.retryBackoff)
+
+	t.Setenv("KAFKA_RETRY_BACKOFF", "soon")
+	cfg = load()
+	assert.Equal(t, time.Second, cfg.retryBackoff)
+	assert.ErrorContains(t, cfg.validate(), "error converting KAFKA_RETRY_BACKOFF to duration")
+}
+
+func TestLoad_Batching(t *testing.T) {
+	t.Setenv("KAFKA_LINGER_MS", "50")
+	t.Setenv("KAFKA_BATCH_SIZE", "65536")
+
+	cfg := load()
+	assert.Equal(t, 50, cfg.lingerMs)
+	assert.Equal(t, 65536, cfg.batchSize)
+
+	t.Setenv("KAFKA_BATCH_SIZE", "64KB")
+	cfg = load()
+	assert.Equal(t, 1000000, cfg.batchSize)
+	assert.ErrorContains(t, cfg.validate(), "error converting KAFKA_BATCH_SIZE to int")
+}
+
+func TestLoad_AllowedTopics(t *testing.T) {
+	t.Setenv("KAFKA_ALLOWED_TOPICS", "orders|payments")
+
+	cfg := load()
+	assert.Equal(t, []string{"orders", "payments"}, cfg.allowedTopics)
+}
+
+func TestLoad_Transactions(t *testing.T) {
+	t.Setenv("KAFKA_IDEMPOTENCE", "TRUE")
+	t.Setenv("KAFKA_TRANSACTIONAL_ID", "orders-processor-0")
+	t.Setenv("KAFKA_TRANSACTION_TIMEOUT", "30s")
+
+	cfg := load()
+	assert.True(t, cfg.idempotence)
+	assert.Equal(t, "orders-processor-0", cfg.transactionalID)
+	assert.Equal(t, 30*time.Second, cfg.transactionTimeout)
+}
+
+func TestLoad_Security(t *testing.T) {
+	t.Setenv("KAFKA_SECURITY_PROTOCOL", "SASL_SSL")
+	t.Setenv("KAFKA_SASL_MECHANISM", "scram-sha-512")
+	t.Setenv("KAFKA_SASL_USERNAME", "a-user")
+	t.Setenv("KAFKA_SASL_PASSWORD", "a-password")
+	t.Setenv("KAFKA_SSL_CA_LOCATION", "/etc/kafka/ca.pem")
+	t.Setenv("KAFKA_ACKS", "ALL")
+	t.Setenv("KAFKA_COMPRESSION", "zstd")
+	t.Setenv("KAFKA_MESSAGE_MAX_BYTES", "2097152")
+	t.Setenv("KAFKA_REQUEST_TIMEOUT", "15s")
+	t.Setenv("KAFKA_PROP_SOCKET_KEEPALIVE_ENABLE", "true")
+	t.Setenv("KAFKA_PROP_SSL_ENDPOINT_IDENTIFICATION_ALGORITHM", "none")
+	t.Setenv("KAFKA_PROP_PLUGIN__LIBRARY_PATHS", "monitoring-interceptor")
+
+	cfg := load()
+	assert.Equal(t, "sasl_ssl", cfg.securityProtocol)
+	assert.Equal(t, "SCRAM-SHA-512", cfg.saslMechanism)
+	assert.Equal(t, "a-user", cfg.saslUsername)
+	assert.Equal(t, "a-password", cfg.saslPassword)
+	assert.Equal(t, "/etc/kafka/ca.pem", cfg.sslCALocation)
+	assert.Equal(t, "all", cfg.acks)
+	assert.Equal(t, "zstd", cfg.compression)
+	assert.Equal(t, 2097152, cfg.messageMaxBytes)
+	assert.Equal(t, 15*time.Second, cfg.requestTimeout)
+	assert.Equal(t, map[string]string{
+		"socket.keepalive.enable":               "true",
+		"ssl.endpoint.identification.algorithm": "none",
+		"plugin_library.paths":                  "monitoring-interceptor",
+	}, cfg.properties)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	assert.NoError(t, os.WriteFile(cert, []byte("certificate"), 0o600))
+	assert.NoError(t, os.WriteFile(key, []byte("key"), 0o600))
+
+	tests := []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{name: "defaults", config: Config{}},
+		{name: "sasl", config: Config{securityProtocol: "sasl_ssl", saslMechanism: "PLAIN", saslUsername: "a-user", saslPassword: "a-password"}},
+		{name: "mutual tls", config: Config{securityProtocol: "ssl", sslCALocation: cert, sslCertLocation: cert, sslKeyLocation: key}},
+		{name: "idempotent with acks all", config: Config{idempotence: true, acks: "all"}},
+		{name: "invalid protocol", config: Config{securityProtocol: "tls"},
+			err: `invalid KAFKA_SECURITY_PROTOCOL "tls"`},
+		{name: "sasl without mechanism", config: Config{securityProtocol: "sasl_plaintext"},
+			err: "KAFKA_SASL_MECHANISM is required with KAFKA_SECURITY_PROTOCOL sasl_plaintext"},
+		{name: "mechanism without sasl", config: Config{saslMechanism: "PLAIN", saslUsername: "a-user", saslPassword: "a-password"},
+			err: "KAFKA_SASL_MECHANISM requires KAFKA_SECURITY_PROTOCOL sasl_plaintext or sasl_ssl"},
+		{name: "sasl without credentials", config: Config{securityProtocol: "sasl_ssl", saslMechanism: "SCRAM-SHA-256", saslUsername: "a-user"},
+			err: "KAFKA_SASL_USERNAME and KAFKA_SASL_PASSWORD are required"},
+		{name: "invalid mechanism", config: Config{securityProtocol: "sasl_ssl", saslMechanism: "GSSAPI"},
+			err: `invalid KAFKA_SASL_MECHANISM "GSSAPI"`},
+		{name: "certificate without key", config: Config{securityProtocol: "ssl", sslCertLocation: cert},
+			err: "KAFKA_SSL_CERTIFICATE_LOCATION and KAFKA_SSL_KEY_LOCATION must be set together"},
+		{name: "missing ca", config: Config{securityProtocol: "ssl", sslCALocation: filepath.Join(dir, "ca.pem")},
+			err: "invalid KAFKA_SSL_CA_LOCATION"},
+		{name: "invalid acks", config: Config{acks: "2"},
+			err: `invalid KAFKA_ACKS "2"`},
+		{name: "idempotent without acks all", config: Config{transactionalID: "a-producer", acks: "1"},
+			err: "KAFKA_ACKS 1 can't be used with the idempotent producer"},
+		{name: "invalid compression", config: Config{compression: "brotli"},
+			err: `invalid KAFKA_COMPRESSION "brotli"`},
+		{name: "invalid message max bytes", config: Config{messageMaxBytes: -1},
+			err: "invalid KAFKA_MESSAGE_MAX_BYTES -1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestConfig_ProducerConfig(t *testing.T) {
+	cfg := Config{
+		kafkaBroker:      "broker-1:9093,broker-2:9093",
+		securityProtocol: "sasl_ssl",
+		saslMechanism:    "PLAIN",
+		saslUsername:     "a-user",
+		saslPassword:     "a-password",
+		acks:             "all",
+		compression:      "lz4",
+		messageMaxBytes:  2097152,
+		requestTimeout:   15 * time.Second,
+		lingerMs:         5,
+		batchSize:        1000000,
+		properties:       map[string]string{"compression.codec": "zstd", "socket.keepalive.enable": "true"},
+	}
+
+	assert.Equal(t, kafka.ConfigMap{
+		"bootstrap.servers":       "broker-1:9093,broker-2:9093",
+		"security.protocol":       "sasl_ssl",
+		"sasl.mechanisms":         "PLAIN",
+		"sasl.username":           "a-user",
+		"sasl.password":           "a-password",
+		"message.max.bytes":       2097152,
+		"linger.ms":               5,
+		"batch.size":              1000000,
+		"acks":                    "all",
+		"compression.codec":       "zstd",
+		"request.timeout.ms":      15000,
+		"socket.keepalive.enable": "true",
+	}, cfg.producerConfig())
+}
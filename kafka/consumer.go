@@ -0,0 +1,319 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/narumayase/anysher/metrics"
+	"github.com/narumayase/anysher/propagation"
+	"github.com/narumayase/anysher/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// pollTimeoutMs is the time Poll waits for an event, it bounds how long Run takes to notice the context is done.
+const pollTimeoutMs = 100
+
+// newConsumer is a variable that holds the function to create a new Kafka consumer.
+// This is primarily used for mocking in tests.
+var newConsumer = func(configMap *kafka.ConfigMap) (ConsumerClient, error) {
+	return kafka.NewConsumer(configMap)
+}
+
+// ConsumerClient is an interface that wraps the confluent-kafka-go consumer.
+type ConsumerClient interface {
+	SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error
+	Poll(timeoutMs int) kafka.Event
+	CommitMessage(msg *kafka.Message) ([]kafka.TopicPartition, error)
+	StoreMessage(msg *kafka.Message) ([]kafka.TopicPartition, error)
+	Seek(partition kafka.TopicPartition, timeoutMs int) error
+	GetConsumerGroupMetadata() (*kafka.ConsumerGroupMetadata, error)
+	Close() error
+}
+
+// Handler processes a consumed message. When it returns an error the message is consumed again.
+type Handler func(ctx context.Context, msg Message) error
+
+// CommitStrategy defines when the offsets of the processed messages are committed.
+type CommitStrategy string
+
+const (
+	// CommitAfterHandler commits the offset of every message once its handler succeeds.
+	CommitAfterHandler CommitStrategy = "after-handler"
+	// CommitAuto stores the offset of every message once its handler succeeds and commits the stored
+	// offsets periodically, trading duplicates after a crash for fewer commits.
+	CommitAuto CommitStrategy = "auto"
+	// CommitTransaction leaves the offsets to the handler, which sends them within the transaction of the
+	// messages it produces, see Transaction.SendOffsets, so a message is processed exactly once.
+	CommitTransaction CommitStrategy = "transaction"
+)
+
+// RebalanceFunc is called with the partitions assigned to or revoked from the consumer.
+type RebalanceFunc func(ctx context.Context, partitions []kafka.TopicPartition)
+
+// ConsumerOption configures a Consumer.
+type ConsumerOption func(*Consumer)
+
+// WithCommitStrategy replaces the commit strategy of KAFKA_COMMIT_STRATEGY.
+func WithCommitStrategy(strategy CommitStrategy) ConsumerOption {
+	return func(c *Consumer) {
+		c.strategy = strategy
+	}
+}
+
+// WithTopics replaces the topics of KAFKA_TOPICS.
+func WithTopics(topics ...string) ConsumerOption {
+	return func(c *Consumer) {
+		c.topics = topics
+	}
+}
+
+// WithRetryBackoff replaces the wait of KAFKA_RETRY_BACKOFF before consuming again a message whose handler failed.
+func WithRetryBackoff(backoff time.Duration) ConsumerOption {
+	return func(c *Consumer) {
+		c.retryBackoff = backoff
+	}
+}
+
+// WithOnAssigned sets a function called when partitions are assigned to the consumer, before consuming them.
+func WithOnAssigned(f RebalanceFunc) ConsumerOption {
+	return func(c *Consumer) {
+		c.onAssigned = f
+	}
+}
+
+// WithOnRevoked sets a function called when partitions are revoked from the consumer, after its last
+// message from them was processed.
+func WithOnRevoked(f RebalanceFunc) ConsumerOption {
+	return func(c *Consumer) {
+		c.onRevoked = f
+	}
+}
+
+// Consumer consumes the messages of a consumer group and calls a Handler with each of them.
+// The delivery is at-least-once: an offset is only committed after the handler of its message succeeds.
+type Consumer struct {
+	consumer     ConsumerClient
+	handler      Handler
+	topics       []string
+	strategy     CommitStrategy
+	retryBackoff time.Duration
+	onAssigned   RebalanceFunc
+	onRevoked    RebalanceFunc
+	propagator   *propagation.Propagator
+}
+
+// NewConsumer creates a new Kafka consumer that calls the handler with every consumed message.
+// It initializes a Kafka consumer taking the configuration from environment variables:
+// - KAFKA_BROKER
+// - KAFKA_SECURITY_PROTOCOL, KAFKA_SASL_*, KAFKA_SSL_* and KAFKA_MESSAGE_MAX_BYTES -> connection to the brokers
+// - KAFKA_PROP_* -> librdkafka properties, eg: KAFKA_PROP_FETCH_MAX_BYTES
+// - KAFKA_GROUP_ID
+// - KAFKA_TOPICS
+// - KAFKA_COMMIT_STRATEGY
+// - KAFKA_AUTO_COMMIT_INTERVAL
+// - KAFKA_AUTO_OFFSET_RESET
+// - KAFKA_RETRY_BACKOFF
+// - LOG_LEVEL
+// - PROPAGATION_HEADERS -> headers of the messages stored into the context of the handler
+func NewConsumer(handler Handler, opts ...ConsumerOption) (*Consumer, error) {
+	// load configuration from environment
+	cfg := load()
+
+	c := &Consumer{
+		handler:      handler,
+		topics:       cfg.topics,
+		strategy:     CommitStrategy(cfg.commitStrategy),
+		retryBackoff: cfg.retryBackoff,
+		propagator:   propagation.NewPropagator(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if cfg.groupID == "" {
+		return nil, errors.New("failed to create Kafka consumer: KAFKA_GROUP_ID is required")
+	}
+	if len(c.topics) == 0 {
+		return nil, errors.New("failed to create Kafka consumer: no topics to consume")
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("failed to create Kafka consumer: %w", err)
+	}
+
+	configMap := cfg.clientConfig()
+	configMap["group.id"] = cfg.groupID
+	configMap["auto.offset.reset"] = cfg.autoOffsetReset
+	configMap["enable.auto.commit"] = false
+	switch c.strategy {
+	case CommitAfterHandler, CommitTransaction:
+	case CommitAuto:
+		// the offsets are stored after the handler, so the periodic commits skip the messages in process
+		configMap["enable.auto.commit"] = true
+		configMap["enable.auto.offset.store"] = false
+		configMap["auto.commit.interval.ms"] = int(cfg.autoCommitInterval.Milliseconds())
+	default:
+		return nil, fmt.Errorf("failed to create Kafka consumer: invalid commit strategy %q, expected %s, %s or %s",
+			c.strategy, CommitAfterHandler, CommitAuto, CommitTransaction)
+	}
+
+	configMap = cfg.withProperties(configMap)
+	consumer, err := newConsumer(&configMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kafka consumer: %w", err)
+	}
+	log.Info().Msgf("Successfully created Kafka consumer of group %s for brokers: %s", cfg.groupID, cfg.kafkaBroker)
+	c.consumer = consumer
+	return c, nil
+}
+
+// Run subscribes to the topics and calls the handler with every message until the context is done.
+// The message being processed when the context is done is finished before the consumer is closed,
+// leaving the group. It returns an error when the subscription fails or Kafka reports a fatal error.
+func (c *Consumer) Run(ctx context.Context) (err error) {
+	defer func() {
+		if closeErr := c.consumer.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close Kafka consumer: %w", closeErr)
+		}
+	}()
+	if err := c.consumer.SubscribeTopics(c.topics, c.rebalance(ctx)); err != nil {
+		return fmt.Errorf("failed to subscribe to Kafka topics %v: %w", c.topics, err)
+	}
+	log.Ctx(ctx).Info().Msgf("consuming Kafka topics %v", c.topics)
+
+	for ctx.Err() == nil {
+		switch e := c.consumer.Poll(pollTimeoutMs).(type) {
+		case *kafka.Message:
+			c.process(ctx, e)
+		case kafka.Error:
+			if e.IsFatal() {
+				return fmt.Errorf("failed to consume Kafka topics %v: %w", c.topics, e)
+			}
+			log.Ctx(ctx).Warn().Err(e).Msg("Kafka consumer error")
+		}
+	}
+	log.Ctx(ctx).Info().Msgf("stopped consuming Kafka topics %v", c.topics)
+	return nil
+}
+
+// GroupMetadata returns the consumer group metadata, which commits the offsets sent to a transaction.
+func (c *Consumer) GroupMetadata() (*kafka.ConsumerGroupMetadata, error) {
+	metadata, err := c.consumer.GetConsumerGroupMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kafka consumer group metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// rebalance returns the callback that calls the rebalance functions. The partitions are assigned and
+// revoked by the consumer after the callback.
+func (c *Consumer) rebalance(ctx context.Context) kafka.RebalanceCb {
+	return func(_ *kafka.Consumer, e kafka.Event) error {
+		switch e := e.(type) {
+		case kafka.AssignedPartitions:
+			log.Ctx(ctx).Info().Msgf("assigned Kafka partitions %v", e.Partitions)
+			if c.onAssigned != nil {
+				c.onAssigned(ctx, e.Partitions)
+			}
+		case kafka.RevokedPartitions:
+			log.Ctx(ctx).Info().Msgf("revoked Kafka partitions %v", e.Partitions)
+			if c.onRevoked != nil {
+				c.onRevoked(ctx, e.Partitions)
+			}
+		}
+		return nil
+	}
+}
+
+// process calls the handler with the message within an OpenTelemetry consumer span, continuing the trace
+// of its headers, and commits its offset when it succeeds. When it fails the partition is rewound to the
+// message, so it's consumed again after the retry backoff.
+func (c *Consumer) process(ctx context.Context, msg *kafka.Message) {
+	message := newMessage(msg)
+
+	// the handler isn't cancelled by the shutdown, so the message in process is finished
+	handlerCtx := otel.GetTextMapPropagator().Extract(context.WithoutCancel(ctx), headerCarrier{headers: &msg.Headers})
+	header := make(http.Header)
+	for key, value := range message.Headers {
+		header.Set(key, value)
+	}
+	handlerCtx = c.propagator.ContextWithHeaders(handlerCtx, header)
+	handlerCtx, span := tracing.Tracer().Start(handlerCtx, "process "+message.Topic,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingDestinationName(message.Topic),
+			semconv.MessagingDestinationPartitionID(fmt.Sprint(msg.TopicPartition.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(message.Offset)),
+			semconv.MessagingKafkaMessageKey(message.Key),
+		))
+	handlerCtx = tracing.ContextWithLogger(handlerCtx)
+
+	start := time.Now()
+	err := c.handler(handlerCtx, message)
+	tracing.End(span, err)
+	metrics.ObserveKafkaConsume(message.Topic, err, time.Since(start))
+
+	if err != nil {
+		log.Ctx(handlerCtx).Error().Err(err).Msgf("failed to process message from Kafka topic %s [%d] at offset %v, retrying in %s",
+			message.Topic, msg.TopicPartition.Partition, message.Offset, c.retryBackoff)
+		if err := c.consumer.Seek(msg.TopicPartition, 0); err != nil {
+			log.Ctx(handlerCtx).Error().Err(err).Msgf("failed to rewind Kafka topic %s [%d] to offset %v",
+				message.Topic, msg.TopicPartition.Partition, message.Offset)
+		}
+		wait(ctx, c.retryBackoff)
+		return
+	}
+
+	switch c.strategy {
+	case CommitTransaction:
+		return
+	case CommitAuto:
+		_, err = c.consumer.StoreMessage(msg)
+	default:
+		_, err = c.consumer.CommitMessage(msg)
+	}
+	if err != nil {
+		// the message is consumed again by the next owner of the partition
+		log.Ctx(handlerCtx).Error().Err(err).Msgf("failed to commit offset %v of Kafka topic %s [%d]",
+			message.Offset, message.Topic, msg.TopicPartition.Partition)
+	}
+}
+
+// newMessage converts a consumed Kafka message.
+func newMessage(msg *kafka.Message) Message {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	partition := msg.TopicPartition.Partition
+	message := Message{
+		Key:       string(msg.Key),
+		Headers:   headers,
+		Content:   msg.Value,
+		Partition: &partition,
+		Offset:    int64(msg.TopicPartition.Offset),
+		Timestamp: msg.Timestamp,
+	}
+	if msg.TopicPartition.Topic != nil {
+		message.Topic = *msg.TopicPartition.Topic
+	}
+	return message
+}
+
+// wait sleeps for the duration or until the context is done.
+func wait(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
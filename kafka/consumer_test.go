@@ -0,0 +1,399 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/narumayase/anysher/internal/metricstest"
+	"github.com/stretchr/testify/assert"
+)
+
+// MockConsumer is a mock implementation of the ConsumerClient interface.
+type MockConsumer struct {
+	SubscribeTopicsFunc func(topics []string, rebalanceCb kafka.RebalanceCb) error
+	PollFunc            func(timeoutMs int) kafka.Event
+	CommitMessageFunc   func(msg *kafka.Message) ([]kafka.TopicPartition, error)
+	StoreMessageFunc    func(msg *kafka.Message) ([]kafka.TopicPartition, error)
+	SeekFunc            func(partition kafka.TopicPartition, timeoutMs int) error
+	GroupMetadataFunc   func() (*kafka.ConsumerGroupMetadata, error)
+	CloseFunc           func() error
+}
+
+func (m *MockConsumer) SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error {
+	if m.SubscribeTopicsFunc != nil {
+		return m.SubscribeTopicsFunc(topics, rebalanceCb)
+	}
+	return nil
+}
+
+func (m *MockConsumer) Poll(timeoutMs int) kafka.Event {
+	if m.PollFunc != nil {
+		return m.PollFunc(timeoutMs)
+	}
+	return nil
+}
+
+func (m *MockConsumer) CommitMessage(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+	if m.CommitMessageFunc != nil {
+		return m.CommitMessageFunc(msg)
+	}
+	return nil, nil
+}
+
+func (m *MockConsumer) StoreMessage(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+	if m.StoreMessageFunc != nil {
+		return m.StoreMessageFunc(msg)
+	}
+	return nil, nil
+}
+
+func (m *MockConsumer) Seek(partition kafka.TopicPartition, timeoutMs int) error {
+	if m.SeekFunc != nil {
+		return m.SeekFunc(partition, timeoutMs)
+	}
+	return nil
+}
+
+func (m *MockConsumer) GetConsumerGroupMetadata() (*kafka.ConsumerGroupMetadata, error) {
+	if m.GroupMetadataFunc != nil {
+		return m.GroupMetadataFunc()
+	}
+	return nil, nil
+}
+
+func (m *MockConsumer) Close() error {
+	if m.CloseFunc != nil {
+		return m.CloseFunc()
+	}
+	return nil
+}
+
+// pollEvents returns a Poll function that returns the events in order and cancels the context after the last one.
+func pollEvents(cancel context.CancelFunc, events ...kafka.Event) func(int) kafka.Event {
+	return func(int) kafka.Event {
+		if len(events) == 0 {
+			cancel()
+			return nil
+		}
+		e := events[0]
+		events = events[1:]
+		return e
+	}
+}
+
+func consumedMessage(topic string, offset kafka.Offset) *kafka.Message {
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 2, Offset: offset},
+		Key:            []byte("key"),
+		Value:          []byte("content"),
+		Headers:        []kafka.Header{{Key: "X-Request-Id", Value: []byte("a-request-id")}},
+		Timestamp:      time.Unix(1700000000, 0),
+	}
+}
+
+func newTestConsumer(t *testing.T, mock *MockConsumer, handler Handler, opts ...ConsumerOption) *Consumer {
+	originalNewConsumer := newConsumer
+	t.Cleanup(func() { newConsumer = originalNewConsumer })
+	newConsumer = func(*kafka.ConfigMap) (ConsumerClient, error) {
+		return mock, nil
+	}
+	t.Setenv("KAFKA_GROUP_ID", "a-group")
+
+	consumer, err := NewConsumer(handler, append([]ConsumerOption{WithRetryBackoff(time.Millisecond)}, opts...)...)
+	assert.NoError(t, err)
+	return consumer
+}
+
+func TestConsumer_Run(t *testing.T) {
+	t.Run("commits after the handler", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var committed []kafka.Offset
+		closed := false
+		mock := &MockConsumer{
+			SubscribeTopicsFunc: func(topics []string, rebalanceCb kafka.RebalanceCb) error {
+				assert.Equal(t, []string{"orders", "payments"}, topics)
+				return nil
+			},
+			PollFunc: pollEvents(cancel, consumedMessage("orders", 7), kafka.NewError(kafka.ErrTransport, "broker down", false),
+				consumedMessage("payments", 8)),
+			CommitMessageFunc: func(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+				committed = append(committed, msg.TopicPartition.Offset)
+				return nil, nil
+			},
+			CloseFunc: func() error {
+				closed = true
+				return nil
+			},
+		}
+		var handled []Message
+		consumer := newTestConsumer(t, mock, func(ctx context.Context, msg Message) error {
+			assert.Equal(t, "a-request-id", ctx.Value("X-Request-Id"))
+			handled = append(handled, msg)
+			return nil
+		}, WithTopics("orders", "payments"))
+
+		assert.NoError(t, consumer.Run(ctx))
+		partition := int32(2)
+		assert.Equal(t, []kafka.Offset{7, 8}, committed)
+		assert.True(t, closed)
+		assert.Equal(t, Message{
+			Key:       "key",
+			Headers:   map[string]string{"X-Request-Id": "a-request-id"},
+			Content:   []byte("content"),
+			Topic:     "orders",
+			Partition: &partition,
+			Offset:    7,
+			Timestamp: time.Unix(1700000000, 0),
+		}, handled[0])
+		assert.Equal(t, "payments", handled[1].Topic)
+	})
+
+	t.Run("failed messages are consumed again", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var committed, seeked []kafka.Offset
+		mock := &MockConsumer{
+			PollFunc: pollEvents(cancel, consumedMessage("orders", 7), consumedMessage("orders", 7)),
+			CommitMessageFunc: func(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+				committed = append(committed, msg.TopicPartition.Offset)
+				return nil, nil
+			},
+			SeekFunc: func(partition kafka.TopicPartition, timeoutMs int) error {
+				assert.Equal(t, int32(2), partition.Partition)
+				seeked = append(seeked, partition.Offset)
+				return nil
+			},
+		}
+		calls := 0
+		consumer := newTestConsumer(t, mock, func(ctx context.Context, msg Message) error {
+			calls++
+			if calls == 1 {
+				return errors.New("database is down")
+			}
+			return nil
+		})
+
+		assert.NoError(t, consumer.Run(ctx))
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, []kafka.Offset{7}, seeked)
+		assert.Equal(t, []kafka.Offset{7}, committed)
+	})
+
+	t.Run("auto commit stores the offsets", func(t *testing.T) {
+		originalNewConsumer := newConsumer
+		defer func() { newConsumer = originalNewConsumer }()
+		ctx, cancel := context.WithCancel(context.Background())
+		var stored []kafka.Offset
+		mock := &MockConsumer{
+			PollFunc: pollEvents(cancel, consumedMessage("orders", 7)),
+			CommitMessageFunc: func(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+				assert.Fail(t, "unexpected commit")
+				return nil, nil
+			},
+			StoreMessageFunc: func(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+				stored = append(stored, msg.TopicPartition.Offset)
+				return nil, nil
+			},
+		}
+		t.Setenv("KAFKA_GROUP_ID", "a-group")
+		t.Setenv("KAFKA_AUTO_COMMIT_INTERVAL", "2s")
+		newConsumer = func(configMap *kafka.ConfigMap) (ConsumerClient, error) {
+			assert.Equal(t, true, (*configMap)["enable.auto.commit"])
+			assert.Equal(t, false, (*configMap)["enable.auto.offset.store"])
+			assert.Equal(t, 2000, (*configMap)["auto.commit.interval.ms"])
+			assert.Equal(t, "a-group", (*configMap)["group.id"])
+			return mock, nil
+		}
+		consumer, err := NewConsumer(func(ctx context.Context, msg Message) error { return nil }, WithCommitStrategy(CommitAuto))
+		assert.NoError(t, err)
+
+		assert.NoError(t, consumer.Run(ctx))
+		assert.Equal(t, []kafka.Offset{7}, stored)
+	})
+
+	t.Run("transaction strategy leaves the offsets to the handler", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		mock := &MockConsumer{
+			PollFunc: pollEvents(cancel, consumedMessage("orders", 7)),
+			CommitMessageFunc: func(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+				assert.Fail(t, "unexpected commit")
+				return nil, nil
+			},
+			StoreMessageFunc: func(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+				assert.Fail(t, "unexpected store")
+				return nil, nil
+			},
+		}
+		handled := false
+		consumer := newTestConsumer(t, mock, func(ctx context.Context, msg Message) error {
+			handled = true
+			return nil
+		}, WithCommitStrategy(CommitTransaction))
+
+		assert.NoError(t, consumer.Run(ctx))
+		assert.True(t, handled)
+	})
+
+	t.Run("rebalance callbacks", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		topic := "orders"
+		partitions := []kafka.TopicPartition{{Topic: &topic, Partition: 0}, {Topic: &topic, Partition: 1}}
+		var rebalanceCb kafka.RebalanceCb
+		mock := &MockConsumer{
+			SubscribeTopicsFunc: func(topics []string, cb kafka.RebalanceCb) error {
+				rebalanceCb = cb
+				return nil
+			},
+		}
+		polls := 0
+		mock.PollFunc = func(int) kafka.Event {
+			polls++
+			switch polls {
+			case 1:
+				assert.NoError(t, rebalanceCb(nil, kafka.AssignedPartitions{Partitions: partitions}))
+			case 2:
+				assert.NoError(t, rebalanceCb(nil, kafka.RevokedPartitions{Partitions: partitions[1:]}))
+			default:
+				cancel()
+			}
+			return nil
+		}
+		var assigned, revoked []kafka.TopicPartition
+		consumer := newTestConsumer(t, mock, func(ctx context.Context, msg Message) error { return nil },
+			WithOnAssigned(func(ctx context.Context, p []kafka.TopicPartition) { assigned = p }),
+			WithOnRevoked(func(ctx context.Context, p []kafka.TopicPartition) { revoked = p }))
+
+		assert.NoError(t, consumer.Run(ctx))
+		assert.Equal(t, partitions, assigned)
+		assert.Equal(t, partitions[1:], revoked)
+	})
+
+	t.Run("fatal error", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		closed := false
+		mock := &MockConsumer{
+			PollFunc: pollEvents(cancel, kafka.NewError(kafka.ErrFatal, "fenced", true)),
+			CloseFunc: func() error {
+				closed = true
+				return nil
+			},
+		}
+		consumer := newTestConsumer(t, mock, func(ctx context.Context, msg Message) error { return nil })
+
+		assert.ErrorContains(t, consumer.Run(ctx), "failed to consume Kafka topics")
+		assert.True(t, closed)
+	})
+
+	t.Run("subscribe error", func(t *testing.T) {
+		mock := &MockConsumer{
+			SubscribeTopicsFunc: func(topics []string, rebalanceCb kafka.RebalanceCb) error {
+				return errors.New("unknown topic")
+			},
+		}
+		consumer := newTestConsumer(t, mock, func(ctx context.Context, msg Message) error { return nil })
+
+		assert.ErrorContains(t, consumer.Run(context.Background()), "failed to subscribe to Kafka topics")
+	})
+
+	t.Run("the message in process finishes on shutdown", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		committed := false
+		mock := &MockConsumer{
+			PollFunc: pollEvents(cancel, consumedMessage("orders", 7)),
+			CommitMessageFunc: func(msg *kafka.Message) ([]kafka.TopicPartition, error) {
+				committed = true
+				return nil, nil
+			},
+		}
+		consumer := newTestConsumer(t, mock, func(handlerCtx context.Context, msg Message) error {
+			cancel()
+			assert.NoError(t, handlerCtx.Err())
+			return nil
+		})
+
+		assert.NoError(t, consumer.Run(ctx))
+		assert.True(t, committed)
+	})
+}
+
+func TestNewConsumer_Errors(t *testing.T) {
+	originalNewConsumer := newConsumer
+	defer func() { newConsumer = originalNewConsumer }()
+	newConsumer = func(*kafka.ConfigMap) (ConsumerClient, error) {
+		return nil, errors.New("invalid configuration")
+	}
+	handler := func(ctx context.Context, msg Message) error { return nil }
+
+	t.Setenv("KAFKA_GROUP_ID", "")
+	_, err := NewConsumer(handler)
+	assert.ErrorContains(t, err, "KAFKA_GROUP_ID is required")
+
+	t.Setenv("KAFKA_GROUP_ID", "a-group")
+	t.Setenv("KAFKA_COMPRESSION", "brotli")
+	_, err = NewConsumer(handler)
+	assert.ErrorContains(t, err, `failed to create Kafka consumer: invalid KAFKA_COMPRESSION "brotli"`)
+
+	t.Setenv("KAFKA_COMPRESSION", "")
+	t.Setenv("KAFKA_AUTO_COMMIT_INTERVAL", "5000")
+	_, err = NewConsumer(handler)
+	assert.ErrorContains(t, err, "failed to create Kafka consumer: error converting KAFKA_AUTO_COMMIT_INTERVAL to duration")
+
+	t.Setenv("KAFKA_AUTO_COMMIT_INTERVAL", "")
+	_, err = NewConsumer(handler, WithCommitStrategy("never"))
+	assert.ErrorContains(t, err, `invalid commit strategy "never"`)
+
+	_, err = NewConsumer(handler)
+	assert.ErrorContains(t, err, "failed to create Kafka consumer: invalid configuration")
+}
+
+func TestNewConsumer_Security(t *testing.T) {
+	originalNewConsumer := newConsumer
+	defer func() { newConsumer = originalNewConsumer }()
+	t.Setenv("KAFKA_GROUP_ID", "a-group")
+	t.Setenv("KAFKA_SECURITY_PROTOCOL", "sasl_ssl")
+	t.Setenv("KAFKA_SASL_MECHANISM", "SCRAM-SHA-256")
+	t.Setenv("KAFKA_SASL_USERNAME", "a-user")
+	t.Setenv("KAFKA_SASL_PASSWORD", "a-password")
+	t.Setenv("KAFKA_ACKS", "0")
+	t.Setenv("KAFKA_PROP_FETCH_MAX_BYTES", "1048576")
+
+	newConsumer = func(configMap *kafka.ConfigMap) (ConsumerClient, error) {
+		assert.Equal(t, "sasl_ssl", (*configMap)["security.protocol"])
+		assert.Equal(t, "SCRAM-SHA-256", (*configMap)["sasl.mechanisms"])
+		assert.Equal(t, "a-user", (*configMap)["sasl.username"])
+		assert.Equal(t, "1048576", (*configMap)["fetch.max.bytes"])
+		// acks is a producer property
+		_, ok := (*configMap)["acks"]
+		assert.False(t, ok)
+		return &MockConsumer{}, nil
+	}
+
+	_, err := NewConsumer(func(ctx context.Context, msg Message) error { return nil })
+	assert.NoError(t, err)
+}
+
+func TestConsumer_Run_Metrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mock := &MockConsumer{
+		PollFunc: pollEvents(cancel, consumedMessage("metrics-consumed", 1), consumedMessage("metrics-consumed", 1)),
+	}
+	calls := 0
+	consumer := newTestConsumer(t, mock, func(ctx context.Context, msg Message) error {
+		calls++
+		if calls == 1 {
+			return errors.New("handler error")
+		}
+		return nil
+	})
+
+	assert.NoError(t, consumer.Run(ctx))
+	assert.Equal(t, 1.0, metricstest.CounterValue(t, "anysher_kafka_consumed_messages_total",
+		map[string]string{"topic": "metrics-consumed", "result": "processed"}))
+	assert.Equal(t, 1.0, metricstest.CounterValue(t, "anysher_kafka_consumed_messages_total",
+		map[string]string{"topic": "metrics-consumed", "result": "failed"}))
+	assert.Equal(t, uint64(2), metricstest.HistogramCount(t, "anysher_kafka_process_duration_seconds",
+		map[string]string{"topic": "metrics-consumed"}))
+}
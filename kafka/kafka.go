@@ -2,9 +2,20 @@ package kafka
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/narumayase/anysher/metrics"
+	"github.com/narumayase/anysher/propagation"
+	"github.com/narumayase/anysher/redact"
+	"github.com/narumayase/anysher/tracing"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // newProducer is a variable that holds the function to create a new Kafka producer.
@@ -19,49 +30,219 @@ type Producer interface {
 	Events() chan kafka.Event
 	Flush(timeoutMs int) int
 	Close()
+	InitTransactions(ctx context.Context) error
+	BeginTransaction() error
+	SendOffsetsToTransaction(ctx context.Context, offsets []kafka.TopicPartition, consumerMetadata *kafka.ConsumerGroupMetadata) error
+	CommitTransaction(ctx context.Context) error
+	AbortTransaction(ctx context.Context) error
 }
 
-// Message represents the structure of a message to be sent to Kafka.
+// ErrTopicNotAllowed is returned sending a message to a topic missing from KAFKA_ALLOWED_TOPICS.
+var ErrTopicNotAllowed = errors.New("topic is not allowed")
+
+// Message represents the structure of a message to be sent to or consumed from Kafka.
 type Message struct {
 	Key     string
 	Headers map[string]string
 	Content []byte
+	// Topic is the topic of the message, the Repository sends it to KAFKA_TOPIC when it's empty.
+	Topic string
+	// Partition is the partition of the message. When it's nil the partition is chosen from the key.
+	Partition *int32
+	// Offset is set on the consumed messages.
+	Offset int64
+	// Timestamp is the event time of the message. When it's zero the producer sets the send time.
+	Timestamp time.Time
+}
+
+// closeFlushTimeoutMs is the time Close waits for the delivery reports of the messages still in flight.
+const closeFlushTimeoutMs = 10000
+
+// Delivery is the delivery report of a message sent with SendAsync.
+type Delivery struct {
+	Key       string
+	Topic     string
+	Partition int32
+	Offset    int64
+	// Err is set when the message couldn't be delivered.
+	Err error
+}
+
+// DeliveryFunc is called with the delivery report of a message sent with SendAsync.
+type DeliveryFunc func(delivery Delivery)
+
+// pendingDelivery is the state of a message sent with SendAsync until its delivery report.
+type pendingDelivery struct {
+	ctx        context.Context
+	topic      string
+	span       trace.Span
+	start      time.Time
+	onDelivery DeliveryFunc
 }
 
 // Repository Kafka repository.
 type Repository struct {
-	producer Producer
-	topic    string
+	producer      Producer
+	topic         string
+	allowed       map[string]bool
+	transactional bool
+	propagator    *propagation.Propagator
+	redactor      *redact.Redactor
+
+	// the producer events, including the delivery reports of SendAsync, are read by a goroutine started
+	// with the Repository, so they never pile up
+	drainOnce sync.Once
+	drainers  sync.WaitGroup
+	// inFlight is the number of messages enqueued whose delivery report wasn't read yet
+	inFlight atomic.Int64
 }
 
 // NewRepository creates a new Kafka repository instance.
 // It initializes a Kafka taking the configuration from environment variables:
 // - KAFKA_BROKER
-// - KAFKA_TOPIC
+// - KAFKA_SECURITY_PROTOCOL, KAFKA_SASL_* and KAFKA_SSL_* -> connection to the brokers
+// - KAFKA_ACKS, KAFKA_COMPRESSION, KAFKA_MESSAGE_MAX_BYTES and KAFKA_REQUEST_TIMEOUT
+// - KAFKA_PROP_* -> librdkafka properties, eg: KAFKA_PROP_SOCKET_KEEPALIVE_ENABLE
+// - KAFKA_TOPIC -> topic of the messages without a topic
+// - KAFKA_ALLOWED_TOPICS -> topics separated by pipe the messages can be sent to besides KAFKA_TOPIC
+// - KAFKA_LINGER_MS -> time the producer waits to batch messages before sending them
+// - KAFKA_BATCH_SIZE -> maximum size in bytes of a batch of messages
+// - KAFKA_IDEMPOTENCE -> true to send every message exactly once, in order, to its partition
+// - KAFKA_TRANSACTIONAL_ID -> makes the Repository transactional, see Begin
+// - KAFKA_TRANSACTION_TIMEOUT -> time after which the broker aborts an unfinished transaction
 // - LOG_LEVEL
+// - PROPAGATION_HEADERS -> headers propagated from the context, eg: X-Request-Id|X-Correlation-Id
+// - REDACT_HEADERS, REDACT_FIELDS and REDACT_MAX_BODY -> redaction of the logs
 func NewRepository() (*Repository, error) {
 	// load configuration from environment
 	cfg := load()
 
-	p, err := newProducer(&kafka.ConfigMap{"bootstrap.servers": cfg.kafkaBroker})
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
+	}
+	configMap := cfg.producerConfig()
+	p, err := newProducer(&configMap)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
 	}
+	if cfg.transactionalID != "" {
+		// it fences the previous producer with the same transactional ID, aborting its unfinished transaction
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.transactionTimeout)
+		defer cancel()
+		if err := p.InitTransactions(ctx); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("failed to init Kafka transactions: %w", err)
+		}
+	}
 	log.Info().Msgf("Successfully created Kafka producer for brokers: %s", cfg.kafkaBroker)
 
-	return &Repository{
-		producer: p,
-		topic:    cfg.kafkaTopic,
-	}, nil
+	var allowed map[string]bool
+	if len(cfg.allowedTopics) > 0 {
+		allowed = map[string]bool{cfg.kafkaTopic: true}
+		for _, topic := range cfg.allowedTopics {
+			allowed[topic] = true
+		}
+	}
+
+	repo := &Repository{
+		producer:      p,
+		topic:         cfg.kafkaTopic,
+		allowed:       allowed,
+		transactional: cfg.transactionalID != "",
+		propagator:    propagation.NewPropagator(),
+		redactor:      redact.NewRedactor(),
+	}
+	repo.startDrain()
+	return repo, nil
 }
 
-// Send a message to a Kafka topic.
-func (r *Repository) Send(ctx context.Context, payload Message) error {
+// Send a message to its topic, or KAFKA_TOPIC, and wait for its delivery report.
+// The message is sent within an OpenTelemetry producer span whose trace context is written
+// into the message headers with the global propagator. The produce latency and delivery failures
+// are recorded in the metrics package.
+func (r *Repository) Send(ctx context.Context, payload Message) (err error) {
 	if r.producer == nil {
 		log.Ctx(ctx).Warn().Msg("Kafka producer is not initialized; cannot send messages.")
 		return nil
 	}
+	topic, err := r.topicOf(payload)
+	if err != nil {
+		return err
+	}
+	ctx, span := r.startSpan(ctx, topic, payload)
+	start := time.Now()
+	defer func() {
+		tracing.End(span, err)
+		metrics.ObserveKafkaProduce(topic, err, time.Since(start))
+	}()
+	ctx = tracing.ContextWithLogger(ctx)
+
+	deliveryChan := make(chan kafka.Event)
+	if err := r.produce(ctx, topic, payload, deliveryChan, nil); err != nil {
+		return err
+	}
 
+	// Wait for message delivery report.
+	e := <-deliveryChan
+	close(deliveryChan)
+	r.inFlight.Add(-1)
+	return r.delivered(ctx, topic, span, e.(*kafka.Message))
+}
+
+// SendAsync sends a message to its topic, or KAFKA_TOPIC, without waiting for its delivery report, so the producer
+// batches the messages sent meanwhile, see KAFKA_LINGER_MS and KAFKA_BATCH_SIZE.
+// It only returns the errors of enqueueing the message, eg: when the producer queue is full or the topic
+// isn't allowed. The delivery
+// report is passed to onDelivery, which can be nil, from a goroutine that reads the producer events,
+// so it must not block. The message is traced and recorded in the metrics like the ones of Send.
+func (r *Repository) SendAsync(ctx context.Context, payload Message, onDelivery DeliveryFunc) error {
+	if r.producer == nil {
+		log.Ctx(ctx).Warn().Msg("Kafka producer is not initialized; cannot send messages.")
+		return nil
+	}
+	topic, err := r.topicOf(payload)
+	if err != nil {
+		return err
+	}
+	r.startDrain()
+
+	ctx, span := r.startSpan(ctx, topic, payload)
+	ctx = tracing.ContextWithLogger(ctx)
+	pending := &pendingDelivery{ctx: ctx, topic: topic, span: span, start: time.Now(), onDelivery: onDelivery}
+	if err := r.produce(ctx, topic, payload, nil, pending); err != nil {
+		tracing.End(span, err)
+		metrics.ObserveKafkaProduce(topic, err, time.Since(pending.start))
+		return err
+	}
+	return nil
+}
+
+// topicOf returns the topic of the message, failing with ErrTopicNotAllowed when it isn't allowed.
+func (r *Repository) topicOf(payload Message) (string, error) {
+	if payload.Topic == "" {
+		return r.topic, nil
+	}
+	if r.allowed != nil && !r.allowed[payload.Topic] {
+		return "", fmt.Errorf("failed to produce message to Kafka topic %s: %w", payload.Topic, ErrTopicNotAllowed)
+	}
+	return payload.Topic, nil
+}
+
+// startSpan starts the producer span of a message.
+func (r *Repository) startSpan(ctx context.Context, topic string, payload Message) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "publish "+topic,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingKafkaMessageKey(payload.Key),
+		))
+}
+
+// produce enqueues the message to the topic with its headers, the IDs of the context and the trace context.
+// Its delivery report is sent to deliveryChan, or to the producer events when it's nil.
+func (r *Repository) produce(ctx context.Context, topic string, payload Message, deliveryChan chan kafka.Event, opaque interface{}) error {
 	var kafkaHeaders []kafka.Header
 	// Convert message headers to Kafka headers format.
 	for k, v := range payload.Headers {
@@ -69,39 +250,109 @@ func (r *Repository) Send(ctx context.Context, payload Message) error {
 			Key: k, Value: []byte(v),
 		})
 	}
-	log.Ctx(ctx).Debug().Msgf("sending message content to Kafka topic %s: %s", r.topic, string(payload.Content))
-	log.Ctx(ctx).Info().Msgf("sending headers to Kafka topic %s: %v", r.topic, payload.Headers)
-	log.Ctx(ctx).Info().Msgf("sending key to Kafka topic %s: %s", r.topic, payload.Key)
+	// Add the IDs of the context that the message doesn't set.
+	if r.propagator != nil {
+		for k, v := range r.propagator.Extract(ctx) {
+			if _, ok := payload.Headers[k]; !ok {
+				kafkaHeaders = append(kafkaHeaders, kafka.Header{
+					Key: k, Value: []byte(v),
+				})
+			}
+		}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{headers: &kafkaHeaders})
 
-	deliveryChan := make(chan kafka.Event)
+	log.Ctx(ctx).Debug().Msgf("sending message content to Kafka topic %s: %s", topic, r.redactor.Body(payload.Content))
+	log.Ctx(ctx).Info().Msgf("sending headers to Kafka topic %s: %v", topic, r.redactor.Map(payload.Headers))
+	log.Ctx(ctx).Info().Msgf("sending key to Kafka topic %s: %s", topic, payload.Key)
+
+	partition := kafka.PartitionAny
+	if payload.Partition != nil {
+		partition = *payload.Partition
+	}
 	err := r.producer.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &r.topic, Partition: kafka.PartitionAny},
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition},
 		Value:          payload.Content,
 		Headers:        kafkaHeaders,
 		Key:            []byte(payload.Key),
+		Timestamp:      payload.Timestamp,
+		Opaque:         opaque,
 	}, deliveryChan)
-
 	if err != nil {
-		return fmt.Errorf("failed to produce message to Kafka topic %s: %w", r.topic, err)
+		return fmt.Errorf("failed to produce message to Kafka topic %s: %w", topic, err)
 	}
+	r.inFlight.Add(1)
+	return nil
+}
 
-	// Wait for message delivery report.
-	e := <-deliveryChan
-	m := e.(*kafka.Message)
-
+// delivered checks the delivery report of a message, adding its partition and offset to the span.
+func (r *Repository) delivered(ctx context.Context, topic string, span trace.Span, m *kafka.Message) error {
 	if m.TopicPartition.Error != nil {
-		return fmt.Errorf("delivery failed to Kafka topic %s: %v", r.topic, m.TopicPartition.Error)
+		return fmt.Errorf("delivery failed to Kafka topic %s: %v", topic, m.TopicPartition.Error)
 	}
+	span.SetAttributes(
+		semconv.MessagingDestinationPartitionID(fmt.Sprint(m.TopicPartition.Partition)),
+		semconv.MessagingKafkaMessageOffset(int(m.TopicPartition.Offset)),
+	)
 	log.Ctx(ctx).Info().Msgf("delivered message to topic %s [%d] at offset %v",
 		*m.TopicPartition.Topic, m.TopicPartition.Partition, m.TopicPartition.Offset)
-
-	close(deliveryChan)
 	return nil
 }
 
-// Close closes the Kafka producer.
+// startDrain starts the goroutine that reads the producer events, when it isn't running yet.
+func (r *Repository) startDrain() {
+	r.drainOnce.Do(func() {
+		r.drainers.Add(1)
+		go r.drain()
+	})
+}
+
+// drain reads the producer events until the producer is closed, reporting the deliveries of SendAsync.
+func (r *Repository) drain() {
+	defer r.drainers.Done()
+	events := r.producer.Events()
+	if events == nil {
+		return
+	}
+	for e := range events {
+		switch e := e.(type) {
+		case *kafka.Message:
+			pending, ok := e.Opaque.(*pendingDelivery)
+			if !ok {
+				continue
+			}
+			r.inFlight.Add(-1)
+			err := r.delivered(pending.ctx, pending.topic, pending.span, e)
+			tracing.End(pending.span, err)
+			metrics.ObserveKafkaProduce(pending.topic, err, time.Since(pending.start))
+			if err != nil {
+				log.Ctx(pending.ctx).Error().Err(err).Msg("failed to deliver message sent asynchronously")
+			}
+			if pending.onDelivery != nil {
+				pending.onDelivery(Delivery{
+					Key:       string(e.Key),
+					Topic:     pending.topic,
+					Partition: e.TopicPartition.Partition,
+					Offset:    int64(e.TopicPartition.Offset),
+					Err:       err,
+				})
+			}
+		case kafka.Error:
+			log.Error().Err(e).Msg("Kafka producer error")
+		}
+	}
+}
+
+// Close waits for the delivery reports of the messages in flight and closes the Kafka producer.
+// The producer events are read meanwhile, so the ones that aren't delivery reports don't delay it.
 func (r *Repository) Close() {
 	if r.producer != nil {
+		r.startDrain()
+		r.producer.Flush(closeFlushTimeoutMs)
 		r.producer.Close()
+		r.drainers.Wait()
+		if remaining := r.inFlight.Load(); remaining > 0 {
+			log.Warn().Msgf("closed Kafka producer with %d messages not delivered", remaining)
+		}
 	}
 }
@@ -1,11 +1,16 @@
 package kafka
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/narumayase/anysher/propagation"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -15,6 +20,12 @@ type MockProducer struct {
 	EventsFunc  func() chan kafka.Event
 	FlushFunc   func(timeoutMs int) int
 	CloseFunc   func()
+
+	InitTransactionsFunc         func(ctx context.Context) error
+	BeginTransactionFunc         func() error
+	SendOffsetsToTransactionFunc func(ctx context.Context, offsets []kafka.TopicPartition, consumerMetadata *kafka.ConsumerGroupMetadata) error
+	CommitTransactionFunc        func(ctx context.Context) error
+	AbortTransactionFunc         func(ctx context.Context) error
 }
 
 func (m *MockProducer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
@@ -44,6 +55,41 @@ func (m *MockProducer) Close() {
 	}
 }
 
+func (m *MockProducer) InitTransactions(ctx context.Context) error {
+	if m.InitTransactionsFunc != nil {
+		return m.InitTransactionsFunc(ctx)
+	}
+	return nil
+}
+
+func (m *MockProducer) BeginTransaction() error {
+	if m.BeginTransactionFunc != nil {
+		return m.BeginTransactionFunc()
+	}
+	return nil
+}
+
+func (m *MockProducer) SendOffsetsToTransaction(ctx context.Context, offsets []kafka.TopicPartition, consumerMetadata *kafka.ConsumerGroupMetadata) error {
+	if m.SendOffsetsToTransactionFunc != nil {
+		return m.SendOffsetsToTransactionFunc(ctx, offsets, consumerMetadata)
+	}
+	return nil
+}
+
+func (m *MockProducer) CommitTransaction(ctx context.Context) error {
+	if m.CommitTransactionFunc != nil {
+		return m.CommitTransactionFunc(ctx)
+	}
+	return nil
+}
+
+func (m *MockProducer) AbortTransaction(ctx context.Context) error {
+	if m.AbortTransactionFunc != nil {
+		return m.AbortTransactionFunc(ctx)
+	}
+	return nil
+}
+
 func TestKafkaRepository_Produce_NilProducer(t *testing.T) {
 	repo := &Repository{}
 	ctx := context.Background()
@@ -174,6 +220,66 @@ func TestKafkaRepository_Close(t *testing.T) {
 	assert.True(t, closed)
 }
 
+func TestKafkaRepository_Close_SendOnly(t *testing.T) {
+	originalNewProducer := newProducer
+	defer func() { newProducer = originalNewProducer }()
+
+	// like librdkafka, Flush waits until the events are read
+	events := make(chan kafka.Event, 10)
+	mockProducer := &MockProducer{
+		ProduceFunc: func(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+			go func() {
+				deliveryChan <- &kafka.Message{TopicPartition: msg.TopicPartition}
+			}()
+			return nil
+		},
+		EventsFunc: func() chan kafka.Event { return events },
+		FlushFunc: func(timeoutMs int) int {
+			deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+			for len(events) > 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			return len(events)
+		},
+		CloseFunc: func() { close(events) },
+	}
+	newProducer = func(cm *kafka.ConfigMap) (Producer, error) { return mockProducer, nil }
+
+	var logs bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&logs)
+	defer func() { log.Logger = logger }()
+
+	repo, err := NewRepository()
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Send(context.Background(), Message{Key: "key", Content: []byte("message")}))
+	events <- kafka.NewError(kafka.ErrAllBrokersDown, "all brokers are down", false)
+
+	start := time.Now()
+	repo.Close()
+	assert.Less(t, time.Since(start), time.Second)
+	assert.NotContains(t, logs.String(), "not delivered")
+}
+
+func TestKafkaRepository_Close_NotDelivered(t *testing.T) {
+	events := make(chan kafka.Event, 10)
+	mockProducer := &MockProducer{
+		EventsFunc: func() chan kafka.Event { return events },
+		CloseFunc:  func() { close(events) },
+	}
+	repo := &Repository{producer: mockProducer, topic: "test-topic"}
+
+	var logs bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&logs)
+	defer func() { log.Logger = logger }()
+
+	// the producer is closed before the delivery report of the message
+	assert.NoError(t, repo.SendAsync(context.Background(), Message{Key: "key", Content: []byte("message")}, nil))
+	repo.Close()
+	assert.Contains(t, logs.String(), "closed Kafka producer with 1 messages not delivered")
+}
+
 func TestKafkaRepository_Send_NoHeaders(t *testing.T) {
 	mockProducer := &MockProducer{
 		ProduceFunc: func(msg *kafka.Message, deliveryChan chan kafka.Event) error {
@@ -195,3 +301,266 @@ func TestKafkaRepository_Send_NoHeaders(t *testing.T) {
 	assert.NoError(t, err)
 	// Optionally, you could add assertions here to check if headers were indeed empty in the produced message
 }
+
+func TestKafkaRepository_Send_PropagatesContextHeaders(t *testing.T) {
+	var produced *kafka.Message
+	mockProducer := &MockProducer{
+		ProduceFunc: func(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+			produced = msg
+			go func() {
+				deliveryChan <- &kafka.Message{TopicPartition: msg.TopicPartition}
+			}()
+			return nil
+		},
+	}
+	repo := &Repository{
+		producer:   mockProducer,
+		topic:      "test-topic",
+		propagator: propagation.NewPropagator("X-Request-Id", "X-Correlation-Id"),
+	}
+	ctx := propagation.ContextWithValue(context.Background(), "X-Request-Id", "a-request")
+	ctx = propagation.ContextWithValue(ctx, "X-Correlation-Id", "from-context")
+
+	err := repo.Send(ctx, Message{
+		Key:     "key",
+		Headers: map[string]string{"X-Correlation-Id": "from-message"},
+		Content: []byte("test message"),
+	})
+	assert.NoError(t, err)
+
+	headers := map[string]string{}
+	for _, header := range produced.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	assert.Equal(t, map[string]string{
+		"X-Request-Id":     "a-request",
+		"X-Correlation-Id": "from-message",
+	}, headers)
+}
+
+func TestKafkaRepository_Send_RedactsLogs(t *testing.T) {
+	mockProducer := &MockProducer{
+		ProduceFunc: func(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+			go func() {
+				deliveryChan <- &kafka.Message{TopicPartition: msg.TopicPartition}
+			}()
+			return nil
+		},
+	}
+	repo := &Repository{producer: mockProducer, topic: "test-topic"}
+
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	defer zerolog.SetGlobalLevel(level)
+
+	var logs bytes.Buffer
+	ctx := zerolog.New(&logs).WithContext(context.Background())
+
+	err := repo.Send(ctx, Message{
+		Key:     "key",
+		Headers: map[string]string{"Authorization": "a-secret-token"},
+		Content: []byte(`{"password":"a-secret-password"}`),
+	})
+	assert.NoError(t, err)
+	assert.NotContains(t, logs.String(), "a-secret-token")
+	assert.NotContains(t, logs.String(), "a-secret-password")
+}
+
+func TestNewRepository_Batching(t *testing.T) {
+	originalNewProducer := newProducer
+	defer func() { newProducer = originalNewProducer }()
+	t.Setenv("KAFKA_LINGER_MS", "20")
+	t.Setenv("KAFKA_BATCH_SIZE", "131072")
+
+	newProducer = func(cm *kafka.ConfigMap) (Producer, error) {
+		assert.Equal(t, 20, (*cm)["linger.ms"])
+		assert.Equal(t, 131072, (*cm)["batch.size"])
+		return &MockProducer{}, nil
+	}
+
+	_, err := NewRepository()
+	assert.NoError(t, err)
+}
+
+// newAsyncProducer returns a mock producer that reports the deliveries of the messages produced without
+// a delivery channel to its events, failing the ones whose key is "fail", and closes them on Close.
+func newAsyncProducer() *MockProducer {
+	events := make(chan kafka.Event, 10)
+	return &MockProducer{
+		ProduceFunc: func(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+			delivered := *msg
+			delivered.TopicPartition.Partition = 1
+			delivered.TopicPartition.Offset = 10
+			if string(msg.Key) == "fail" {
+				delivered.TopicPartition.Error = errors.New("message timed out")
+			}
+			events <- &delivered
+			return nil
+		},
+		EventsFunc: func() chan kafka.Event { return events },
+		CloseFunc:  func() { close(events) },
+	}
+}
+
+func TestKafkaRepository_SendAsync(t *testing.T) {
+	t.Run("delivery reports", func(t *testing.T) {
+		repo := &Repository{producer: newAsyncProducer(), topic: "test-topic"}
+
+		deliveries := make(chan Delivery, 2)
+		onDelivery := func(d Delivery) { deliveries <- d }
+		assert.NoError(t, repo.SendAsync(context.Background(), Message{Key: "key", Content: []byte("message")}, onDelivery))
+		assert.NoError(t, repo.SendAsync(context.Background(), Message{Key: "fail", Content: []byte("message")}, onDelivery))
+
+		delivered := <-deliveries
+		assert.Equal(t, Delivery{Key: "key", Topic: "test-topic", Partition: 1, Offset: 10}, delivered)
+		failed := <-deliveries
+		assert.Equal(t, "fail", failed.Key)
+		assert.ErrorContains(t, failed.Err, "delivery failed to Kafka topic test-topic: message timed out")
+		repo.Close()
+	})
+
+	t.Run("close waits for the delivery reports", func(t *testing.T) {
+		producer := newAsyncProducer()
+		flushed := false
+		producer.FlushFunc = func(timeoutMs int) int {
+			flushed = true
+			return 0
+		}
+		repo := &Repository{producer: producer, topic: "test-topic"}
+
+		delivered := 0
+		for range 3 {
+			assert.NoError(t, repo.SendAsync(context.Background(), Message{Key: "key"}, func(d Delivery) { delivered++ }))
+		}
+		assert.NoError(t, repo.SendAsync(context.Background(), Message{Key: "key"}, nil))
+		repo.Close()
+
+		assert.True(t, flushed)
+		assert.Equal(t, 3, delivered)
+	})
+
+	t.Run("produce error", func(t *testing.T) {
+		repo := &Repository{producer: &MockProducer{
+			ProduceFunc: func(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+				assert.Nil(t, deliveryChan)
+				return kafka.NewError(kafka.ErrQueueFull, "Local: Queue full", false)
+			},
+		}, topic: "test-topic"}
+
+		err := repo.SendAsync(context.Background(), Message{Key: "key"}, func(d Delivery) {
+			assert.Fail(t, "unexpected delivery report")
+		})
+		assert.ErrorContains(t, err, "failed to produce message to Kafka topic test-topic: Local: Queue full")
+		repo.Close()
+	})
+
+	t.Run("nil producer", func(t *testing.T) {
+		repo := &Repository{}
+		assert.NoError(t, repo.SendAsync(context.Background(), Message{Key: "key"}, nil))
+	})
+}
+
+func TestKafkaRepository_Send_Overrides(t *testing.T) {
+	var sent *kafka.Message
+	mockProducer := &MockProducer{
+		ProduceFunc: func(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+			sent = msg
+			go func() {
+				deliveryChan <- &kafka.Message{TopicPartition: msg.TopicPartition}
+			}()
+			return nil
+		},
+	}
+	repo := &Repository{producer: mockProducer, topic: "test-topic"}
+
+	t.Run("defaults", func(t *testing.T) {
+		assert.NoError(t, repo.Send(context.Background(), Message{Key: "key"}))
+		assert.Equal(t, "test-topic", *sent.TopicPartition.Topic)
+		assert.Equal(t, kafka.PartitionAny, sent.TopicPartition.Partition)
+		assert.True(t, sent.Timestamp.IsZero())
+	})
+
+	t.Run("topic, partition and timestamp", func(t *testing.T) {
+		partition := int32(0)
+		timestamp := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		err := repo.Send(context.Background(), Message{Key: "key", Topic: "payments", Partition: &partition, Timestamp: timestamp})
+		assert.NoError(t, err)
+		assert.Equal(t, "payments", *sent.TopicPartition.Topic)
+		assert.Equal(t, int32(0), sent.TopicPartition.Partition)
+		assert.Equal(t, timestamp, sent.Timestamp)
+	})
+}
+
+func TestKafkaRepository_Send_AllowedTopics(t *testing.T) {
+	originalNewProducer := newProducer
+	defer func() { newProducer = originalNewProducer }()
+	t.Setenv("KAFKA_TOPIC", "orders")
+	t.Setenv("KAFKA_ALLOWED_TOPICS", "payments|refunds")
+
+	var topics []string
+	newProducer = func(cm *kafka.ConfigMap) (Producer, error) {
+		return &MockProducer{
+			ProduceFunc: func(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+				topics = append(topics, *msg.TopicPartition.Topic)
+				if deliveryChan != nil {
+					go func() {
+						deliveryChan <- &kafka.Message{TopicPartition: msg.TopicPartition}
+					}()
+				}
+				return nil
+			},
+		}, nil
+	}
+	repo, err := NewRepository()
+	assert.NoError(t, err)
+
+	assert.NoError(t, repo.Send(context.Background(), Message{Key: "key"}))
+	assert.NoError(t, repo.Send(context.Background(), Message{Key: "key", Topic: "orders"}))
+	assert.NoError(t, repo.Send(context.Background(), Message{Key: "key", Topic: "refunds"}))
+
+	err = repo.Send(context.Background(), Message{Key: "key", Topic: "payment"})
+	assert.ErrorIs(t, err, ErrTopicNotAllowed)
+	assert.ErrorContains(t, err, "failed to produce message to Kafka topic payment")
+	err = repo.SendAsync(context.Background(), Message{Key: "key", Topic: "payment"}, nil)
+	assert.ErrorIs(t, err, ErrTopicNotAllowed)
+
+	assert.Equal(t, []string{"orders", "orders", "refunds"}, topics)
+}
+
+func TestNewRepository_InvalidConfig(t *testing.T) {
+	originalNewProducer := newProducer
+	defer func() { newProducer = originalNewProducer }()
+	t.Setenv("KAFKA_SECURITY_PROTOCOL", "sasl_ssl")
+
+	newProducer = func(cm *kafka.ConfigMap) (Producer, error) {
+		assert.Fail(t, "unexpected producer")
+		return &MockProducer{}, nil
+	}
+
+	_, err := NewRepository()
+	assert.ErrorContains(t, err, "failed to create Kafka producer: KAFKA_SASL_MECHANISM is required")
+}
+
+func TestNewRepository_MalformedConfig(t *testing.T) {
+	originalNewProducer := newProducer
+	defer func() { newProducer = originalNewProducer }()
+	newProducer = func(cm *kafka.ConfigMap) (Producer, error) {
+		assert.Fail(t, "unexpected producer")
+		return &MockProducer{}, nil
+	}
+
+	for key, value := range map[string]string{
+		"KAFKA_REQUEST_TIMEOUT":     "30000",
+		"KAFKA_TRANSACTION_TIMEOUT": "1 minute",
+		"KAFKA_MESSAGE_MAX_BYTES":   "1MB",
+		"KAFKA_LINGER_MS":           "5ms",
+	} {
+		t.Run(key, func(t *testing.T) {
+			t.Setenv(key, value)
+
+			var err error
+			assert.NotPanics(t, func() { _, err = NewRepository() })
+			assert.ErrorContains(t, err, "failed to create Kafka producer: error converting "+key)
+		})
+	}
+}
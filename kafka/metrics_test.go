@@ -0,0 +1,39 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/narumayase/anysher/internal/metricstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaRepository_Send_Metrics(t *testing.T) {
+	deliveryErr := false
+	mockProducer := &MockProducer{
+		ProduceFunc: func(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+			go func() {
+				delivered := *msg
+				if deliveryErr {
+					delivered.TopicPartition.Error = errors.New("delivery error")
+				}
+				deliveryChan <- &delivered
+			}()
+			return nil
+		},
+	}
+	repo := &Repository{producer: mockProducer, topic: "metrics-topic"}
+
+	assert.NoError(t, repo.Send(context.Background(), Message{Key: "key", Content: []byte("message")}))
+	deliveryErr = true
+	assert.Error(t, repo.Send(context.Background(), Message{Key: "key", Content: []byte("message")}))
+
+	assert.Equal(t, 1.0, metricstest.CounterValue(t, "anysher_kafka_messages_total",
+		map[string]string{"topic": "metrics-topic", "result": "delivered"}))
+	assert.Equal(t, 1.0, metricstest.CounterValue(t, "anysher_kafka_messages_total",
+		map[string]string{"topic": "metrics-topic", "result": "failed"}))
+	assert.Equal(t, uint64(1), metricstest.HistogramCount(t, "anysher_kafka_produce_duration_seconds",
+		map[string]string{"topic": "metrics-topic"}))
+}
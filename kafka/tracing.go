@@ -0,0 +1,41 @@
+package kafka
+
+import (
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// headerCarrier adapts the Kafka message headers to an OpenTelemetry TextMapCarrier,
+// so the trace context, eg: W3C traceparent, travels with the message.
+type headerCarrier struct {
+	headers *[]kafka.Header
+}
+
+// Get returns the value of the header with the given key.
+func (c headerCarrier) Get(key string) string {
+	for _, h := range *c.headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set replaces the value of the header with the given key, adding it when it's missing.
+func (c headerCarrier) Set(key, value string) {
+	for i, h := range *c.headers {
+		if h.Key == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+// Keys returns the keys of the headers.
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.headers))
+	for _, h := range *c.headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
@@ -0,0 +1,144 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	originalProvider := otel.GetTracerProvider()
+	originalPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(originalProvider)
+		otel.SetTextMapPropagator(originalPropagator)
+	})
+	return exporter
+}
+
+func TestKafkaRepository_Send_Tracing(t *testing.T) {
+	exporter := setupTracing(t)
+
+	var sent *kafka.Message
+	mockProducer := &MockProducer{
+		ProduceFunc: func(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+			sent = msg
+			go func() {
+				delivered := *msg
+				delivered.TopicPartition.Partition = 3
+				delivered.TopicPartition.Offset = 42
+				deliveryChan <- &delivered
+			}()
+			return nil
+		},
+	}
+	repo := &Repository{producer: mockProducer, topic: "test-topic"}
+
+	err := repo.Send(context.Background(), Message{Key: "key", Content: []byte("test message")})
+	assert.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "publish test-topic", span.Name)
+	assert.Equal(t, trace.SpanKindProducer, span.SpanKind)
+	assert.Contains(t, span.Attributes, attribute.String("messaging.system", "kafka"))
+	assert.Contains(t, span.Attributes, attribute.String("messaging.destination.name", "test-topic"))
+	assert.Contains(t, span.Attributes, attribute.String("messaging.destination.partition.id", "3"))
+	assert.Contains(t, span.Attributes, attribute.Int("messaging.kafka.message.offset", 42))
+
+	traceparent := headerCarrier{headers: &sent.Headers}.Get("traceparent")
+	assert.Contains(t, traceparent, span.SpanContext.TraceID().String())
+	assert.Contains(t, traceparent, span.SpanContext.SpanID().String())
+}
+
+func TestKafkaRepository_Send_TracingDeliveryError(t *testing.T) {
+	exporter := setupTracing(t)
+
+	mockProducer := &MockProducer{
+		ProduceFunc: func(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+			go func() {
+				deliveryChan <- &kafka.Message{
+					TopicPartition: kafka.TopicPartition{Error: errors.New("delivery error")},
+				}
+			}()
+			return nil
+		},
+	}
+	repo := &Repository{producer: mockProducer, topic: "test-topic"}
+
+	err := repo.Send(context.Background(), Message{Key: "key", Content: []byte("test message")})
+	assert.Error(t, err)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+}
+
+func TestHeaderCarrier(t *testing.T) {
+	headers := []kafka.Header{{Key: "traceparent", Value: []byte("old")}}
+	carrier := headerCarrier{headers: &headers}
+
+	carrier.Set("traceparent", "new")
+	carrier.Set("tracestate", "state")
+
+	assert.Equal(t, "new", carrier.Get("traceparent"))
+	assert.Equal(t, "state", carrier.Get("tracestate"))
+	assert.Equal(t, "", carrier.Get("missing"))
+	assert.Equal(t, []string{"traceparent", "tracestate"}, carrier.Keys())
+	assert.Len(t, headers, 2)
+}
+
+func TestConsumer_Run_Tracing(t *testing.T) {
+	exporter := setupTracing(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msg := consumedMessage("orders", 42)
+	msg.Headers = append(msg.Headers, kafka.Header{Key: "traceparent",
+		Value: []byte("00-4bf92f3577b34da6a3ce929b0e0e4736-00f067aa0ba902b7-01")})
+	mock := &MockConsumer{PollFunc: pollEvents(cancel, msg)}
+	consumer := newTestConsumer(t, mock, func(ctx context.Context, msg Message) error {
+		return errors.New("handler error")
+	})
+
+	assert.NoError(t, consumer.Run(ctx))
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "process orders", span.Name)
+	assert.Equal(t, trace.SpanKindConsumer, span.SpanKind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929b0e0e4736", span.SpanContext.TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+	assert.Contains(t, span.Attributes, attribute.String("messaging.destination.partition.id", "2"))
+	assert.Contains(t, span.Attributes, attribute.Int("messaging.kafka.message.offset", 42))
+	assert.Equal(t, codes.Error, span.Status.Code)
+}
+
+func TestKafkaRepository_SendAsync_Tracing(t *testing.T) {
+	exporter := setupTracing(t)
+
+	producer := newAsyncProducer()
+	repo := &Repository{producer: producer, topic: "test-topic"}
+	assert.NoError(t, repo.SendAsync(context.Background(), Message{Key: "key"}, nil))
+	repo.Close()
+
+	// the span ends with the delivery report
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "publish test-topic", spans[0].Name)
+	assert.Contains(t, spans[0].Attributes, attribute.Int("messaging.kafka.message.offset", 10))
+}
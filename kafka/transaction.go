@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrNotTransactional is returned beginning a transaction with a Repository without KAFKA_TRANSACTIONAL_ID.
+	ErrNotTransactional = errors.New("producer is not transactional")
+	// ErrTransactionDone is returned using a transaction that was already committed or aborted.
+	ErrTransactionDone = errors.New("transaction was already committed or aborted")
+)
+
+// Transaction is a Kafka transaction: the messages sent and the consumer offsets sent within it are
+// committed or aborted atomically. A transactional Repository runs one transaction at a time.
+// A Transaction is safe for concurrent use, its operations run one at a time.
+type Transaction struct {
+	repo *Repository
+	mu   sync.Mutex
+	done bool
+}
+
+// Begin starts a transaction of a Repository created with KAFKA_TRANSACTIONAL_ID.
+// The messages of a transactional Repository can only be sent within a transaction.
+func (r *Repository) Begin() (*Transaction, error) {
+	if r.producer == nil || !r.transactional {
+		return nil, fmt.Errorf("failed to begin Kafka transaction: %w", ErrNotTransactional)
+	}
+	if err := r.producer.BeginTransaction(); err != nil {
+		return nil, fmt.Errorf("failed to begin Kafka transaction: %w", err)
+	}
+	return &Transaction{repo: r}, nil
+}
+
+// Send enqueues a message within the transaction, like SendAsync. The delivery of the messages is
+// checked by Commit, which fails with an abortable error when any of them wasn't delivered.
+func (t *Transaction) Send(ctx context.Context, payload Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return fmt.Errorf("failed to produce message within Kafka transaction: %w", ErrTransactionDone)
+	}
+	return t.repo.SendAsync(ctx, payload, nil)
+}
+
+// SendOffsets adds the offsets of the consumed messages to the transaction, so they're committed to the
+// consumer group with the metadata, see Consumer.GroupMetadata, only when the transaction is committed.
+// The committed offset of each partition is the one after its last message.
+func (t *Transaction) SendOffsets(ctx context.Context, metadata *kafka.ConsumerGroupMetadata, messages ...Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return fmt.Errorf("failed to send offsets to Kafka transaction: %w", ErrTransactionDone)
+	}
+	offsets, err := nextOffsets(messages)
+	if err != nil {
+		return fmt.Errorf("failed to send offsets to Kafka transaction: %w", err)
+	}
+	if err := t.repo.producer.SendOffsetsToTransaction(ctx, offsets, metadata); err != nil {
+		return fmt.Errorf("failed to send offsets to Kafka transaction: %w", err)
+	}
+	return nil
+}
+
+// Commit commits the transaction, waiting for the delivery of its messages.
+// When it fails with a retriable error Commit can be called again, and with an abortable error the
+// transaction must be aborted, see IsRetriable and IsAbortable.
+func (t *Transaction) Commit(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return fmt.Errorf("failed to commit Kafka transaction: %w", ErrTransactionDone)
+	}
+	if err := t.repo.producer.CommitTransaction(ctx); err != nil {
+		return fmt.Errorf("failed to commit Kafka transaction: %w", err)
+	}
+	t.done = true
+	log.Ctx(ctx).Debug().Msg("committed Kafka transaction")
+	return nil
+}
+
+// Abort aborts the transaction, discarding its messages and offsets.
+// When it fails with a retriable error Abort can be called again.
+func (t *Transaction) Abort(ctx context.Context) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.done {
+		return fmt.Errorf("failed to abort Kafka transaction: %w", ErrTransactionDone)
+	}
+	if err := t.repo.producer.AbortTransaction(ctx); err != nil {
+		return fmt.Errorf("failed to abort Kafka transaction: %w", err)
+	}
+	t.done = true
+	log.Ctx(ctx).Debug().Msg("aborted Kafka transaction")
+	return nil
+}
+
+// nextOffsets returns the offset after the last message of each partition.
+// It fails when a message doesn't have its topic or partition, eg: it wasn't consumed.
+func nextOffsets(messages []Message) ([]kafka.TopicPartition, error) {
+	var offsets []kafka.TopicPartition
+	index := make(map[string]int)
+	for i, msg := range messages {
+		if msg.Topic == "" || msg.Partition == nil {
+			return nil, fmt.Errorf("message %d doesn't have its topic and partition", i)
+		}
+		topic := msg.Topic
+		key := fmt.Sprintf("%s/%d", topic, *msg.Partition)
+		next := kafka.Offset(msg.Offset + 1)
+		if i, ok := index[key]; ok {
+			if next > offsets[i].Offset {
+				offsets[i].Offset = next
+			}
+			continue
+		}
+		index[key] = len(offsets)
+		offsets = append(offsets, kafka.TopicPartition{Topic: &topic, Partition: *msg.Partition, Offset: next})
+	}
+	return offsets, nil
+}
+
+// IsRetriable tells if the operation that failed with the error can be called again, eg: Commit.
+func IsRetriable(err error) bool {
+	var kafkaErr kafka.Error
+	return errors.As(err, &kafkaErr) && kafkaErr.IsRetriable()
+}
+
+// IsAbortable tells if the error failed the transaction, which must be aborted before beginning another one.
+func IsAbortable(err error) bool {
+	var kafkaErr kafka.Error
+	return errors.As(err, &kafkaErr) && kafkaErr.TxnRequiresAbort()
+}
+
+// IsFatal tells if the error left the producer unusable, eg: it was fenced by another producer with
+// the same transactional ID, so it must be closed and created again.
+func IsFatal(err error) bool {
+	var kafkaErr kafka.Error
+	return errors.As(err, &kafkaErr) && kafkaErr.IsFatal()
+}
@@ -0,0 +1,232 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTransactionalProducer returns an async mock producer that records the transaction calls.
+func newTransactionalProducer(calls *[]string) *MockProducer {
+	producer := newAsyncProducer()
+	produce := producer.ProduceFunc
+	producer.InitTransactionsFunc = func(ctx context.Context) error {
+		*calls = append(*calls, "init")
+		return nil
+	}
+	producer.BeginTransactionFunc = func() error {
+		*calls = append(*calls, "begin")
+		return nil
+	}
+	producer.ProduceFunc = func(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+		*calls = append(*calls, "produce "+*msg.TopicPartition.Topic)
+		return produce(msg, deliveryChan)
+	}
+	producer.SendOffsetsToTransactionFunc = func(ctx context.Context, offsets []kafka.TopicPartition, consumerMetadata *kafka.ConsumerGroupMetadata) error {
+		*calls = append(*calls, "offsets")
+		return nil
+	}
+	producer.CommitTransactionFunc = func(ctx context.Context) error {
+		*calls = append(*calls, "commit")
+		return nil
+	}
+	producer.AbortTransactionFunc = func(ctx context.Context) error {
+		*calls = append(*calls, "abort")
+		return nil
+	}
+	return producer
+}
+
+func newTransactionalRepository(t *testing.T, producer *MockProducer) *Repository {
+	originalNewProducer := newProducer
+	t.Cleanup(func() { newProducer = originalNewProducer })
+	t.Setenv("KAFKA_TOPIC", "orders")
+	t.Setenv("KAFKA_TRANSACTIONAL_ID", "orders-processor-0")
+	t.Setenv("KAFKA_TRANSACTION_TIMEOUT", "30s")
+
+	newProducer = func(cm *kafka.ConfigMap) (Producer, error) {
+		assert.Equal(t, true, (*cm)["enable.idempotence"])
+		assert.Equal(t, "orders-processor-0", (*cm)["transactional.id"])
+		assert.Equal(t, 30000, (*cm)["transaction.timeout.ms"])
+		return producer, nil
+	}
+	repo, err := NewRepository()
+	assert.NoError(t, err)
+	return repo
+}
+
+func TestRepository_Transaction(t *testing.T) {
+	t.Run("commit", func(t *testing.T) {
+		var calls []string
+		repo := newTransactionalRepository(t, newTransactionalProducer(&calls))
+		defer repo.Close()
+		partition := int32(1)
+		metadata := &kafka.ConsumerGroupMetadata{}
+
+		tx, err := repo.Begin()
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Send(context.Background(), Message{Key: "key"}))
+		assert.NoError(t, tx.Send(context.Background(), Message{Key: "key", Topic: "payments"}))
+		assert.NoError(t, tx.SendOffsets(context.Background(), metadata, Message{Topic: "input", Partition: &partition, Offset: 41}))
+		assert.NoError(t, tx.Commit(context.Background()))
+
+		assert.Equal(t, []string{"init", "begin", "produce orders", "produce payments", "offsets", "commit"}, calls)
+		assert.ErrorIs(t, tx.Commit(context.Background()), ErrTransactionDone)
+		assert.ErrorIs(t, tx.Send(context.Background(), Message{Key: "key"}), ErrTransactionDone)
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		var calls []string
+		repo := newTransactionalRepository(t, newTransactionalProducer(&calls))
+		defer repo.Close()
+
+		tx, err := repo.Begin()
+		assert.NoError(t, err)
+		assert.NoError(t, tx.Send(context.Background(), Message{Key: "key"}))
+		assert.NoError(t, tx.Abort(context.Background()))
+
+		assert.Equal(t, []string{"init", "begin", "produce orders", "abort"}, calls)
+		assert.ErrorIs(t, tx.Abort(context.Background()), ErrTransactionDone)
+	})
+
+	t.Run("offsets of messages without partition", func(t *testing.T) {
+		var calls []string
+		repo := newTransactionalRepository(t, newTransactionalProducer(&calls))
+		defer repo.Close()
+
+		tx, err := repo.Begin()
+		assert.NoError(t, err)
+		err = tx.SendOffsets(context.Background(), &kafka.ConsumerGroupMetadata{}, Message{Topic: "input", Offset: 41})
+		assert.ErrorContains(t, err, "failed to send offsets to Kafka transaction")
+		assert.Equal(t, []string{"init", "begin"}, calls)
+	})
+
+	t.Run("concurrent commits", func(t *testing.T) {
+		var calls []string
+		repo := newTransactionalRepository(t, newTransactionalProducer(&calls))
+		defer repo.Close()
+
+		tx, err := repo.Begin()
+		assert.NoError(t, err)
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() { errs <- tx.Commit(context.Background()) }()
+		}
+		first, second := <-errs, <-errs
+		// only one of the commits reaches the producer
+		assert.True(t, (first == nil) != (second == nil))
+		assert.ErrorIs(t, errors.Join(first, second), ErrTransactionDone)
+		assert.Equal(t, []string{"init", "begin", "commit"}, calls)
+	})
+
+	t.Run("abortable commit error", func(t *testing.T) {
+		var calls []string
+		producer := newTransactionalProducer(&calls)
+		producer.CommitTransactionFunc = func(ctx context.Context) error {
+			return kafka.NewError(kafka.ErrMsgTimedOut, "message timed out", false)
+		}
+		repo := newTransactionalRepository(t, producer)
+		defer repo.Close()
+
+		tx, err := repo.Begin()
+		assert.NoError(t, err)
+		err = tx.Commit(context.Background())
+		assert.ErrorContains(t, err, "failed to commit Kafka transaction")
+		assert.False(t, IsFatal(err))
+
+		// the transaction isn't done until it's aborted
+		assert.NoError(t, tx.Abort(context.Background()))
+	})
+
+	t.Run("init error", func(t *testing.T) {
+		var calls []string
+		closed := false
+		producer := newTransactionalProducer(&calls)
+		producer.InitTransactionsFunc = func(ctx context.Context) error {
+			_, ok := ctx.Deadline()
+			assert.True(t, ok)
+			return kafka.NewError(kafka.ErrTimedOut, "timed out", false)
+		}
+		producer.CloseFunc = func() { closed = true }
+		originalNewProducer := newProducer
+		defer func() { newProducer = originalNewProducer }()
+		t.Setenv("KAFKA_TRANSACTIONAL_ID", "orders-processor-0")
+		newProducer = func(cm *kafka.ConfigMap) (Producer, error) { return producer, nil }
+
+		_, err := NewRepository()
+		assert.ErrorContains(t, err, "failed to init Kafka transactions")
+		assert.True(t, closed)
+	})
+
+	t.Run("not transactional", func(t *testing.T) {
+		repo := &Repository{producer: &MockProducer{}, topic: "orders"}
+
+		_, err := repo.Begin()
+		assert.ErrorIs(t, err, ErrNotTransactional)
+	})
+}
+
+func TestNewRepository_Idempotence(t *testing.T) {
+	originalNewProducer := newProducer
+	defer func() { newProducer = originalNewProducer }()
+	t.Setenv("KAFKA_IDEMPOTENCE", "true")
+
+	newProducer = func(cm *kafka.ConfigMap) (Producer, error) {
+		assert.Equal(t, true, (*cm)["enable.idempotence"])
+		_, transactional := (*cm)["transactional.id"]
+		assert.False(t, transactional)
+		return &MockProducer{
+			InitTransactionsFunc: func(ctx context.Context) error {
+				assert.Fail(t, "unexpected init of transactions")
+				return nil
+			},
+		}, nil
+	}
+
+	repo, err := NewRepository()
+	assert.NoError(t, err)
+	_, err = repo.Begin()
+	assert.ErrorIs(t, err, ErrNotTransactional)
+}
+
+func TestNextOffsets(t *testing.T) {
+	zero, one := int32(0), int32(1)
+	offsets, err := nextOffsets([]Message{
+		{Topic: "orders", Partition: &zero, Offset: 5},
+		{Topic: "orders", Partition: &one, Offset: 3},
+		{Topic: "orders", Partition: &zero, Offset: 9},
+		{Topic: "orders", Partition: &zero, Offset: 7},
+		{Topic: "payments", Partition: &zero, Offset: 1},
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, offsets, 3)
+	assert.Equal(t, "orders", *offsets[0].Topic)
+	assert.Equal(t, kafka.Offset(10), offsets[0].Offset)
+	assert.Equal(t, int32(1), offsets[1].Partition)
+	assert.Equal(t, kafka.Offset(4), offsets[1].Offset)
+	assert.Equal(t, "payments", *offsets[2].Topic)
+	assert.Equal(t, kafka.Offset(2), offsets[2].Offset)
+
+	// the messages that weren't consumed don't have their topic or partition
+	for _, msg := range []Message{{Topic: "orders", Offset: 3}, {Partition: &zero, Offset: 3}} {
+		_, err = nextOffsets([]Message{{Topic: "orders", Partition: &zero, Offset: 1}, msg})
+		assert.ErrorContains(t, err, "message 1 doesn't have its topic and partition")
+	}
+}
+
+func TestErrorClassification(t *testing.T) {
+	retriable := kafka.NewError(kafka.ErrTimedOut, "timed out", false)
+	fatal := kafka.NewError(kafka.ErrFenced, "fenced", true)
+
+	assert.False(t, IsRetriable(fatal))
+	assert.True(t, IsFatal(fmt.Errorf("failed to commit Kafka transaction: %w", fatal)))
+	assert.False(t, IsFatal(retriable))
+	assert.False(t, IsAbortable(retriable))
+	assert.False(t, IsRetriable(errors.New("other error")))
+	assert.False(t, IsAbortable(nil))
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"github.com/joho/godotenv"
+	anysherlog "github.com/narumayase/anysher/log"
+	"github.com/rs/zerolog/log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultNamespace      = "anysher"
+	defaultBuckets        = "0.005|0.01|0.025|0.05|0.1|0.25|0.5|1|2.5|5|10"
+	defaultMaxLabelValues = 100
+)
+
+// Config contains the configuration of the metrics.
+type Config struct {
+	namespace      string
+	buckets        []float64
+	maxLabelValues int
+	statusClasses  bool
+}
+
+// load loads configuration from environment variables or an .env file
+// It takes the configuration from environment variables:
+// - METRICS_NAMESPACE
+// - METRICS_BUCKETS -> format eg: 0.1|0.5|1
+// - METRICS_MAX_LABEL_VALUES
+// - METRICS_STATUS_CLASSES
+// - LOG_LEVEL
+func load() Config {
+	// Load .env file if it exists (ignore error if file doesn't exist)
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file found or error loading .env file: %v", err)
+	}
+	anysherlog.SetLogLevel()
+
+	return Config{
+		namespace:      getEnv("METRICS_NAMESPACE", defaultNamespace),
+		buckets:        parseBuckets(getEnv("METRICS_BUCKETS", defaultBuckets)),
+		maxLabelValues: getEnvAsInt("METRICS_MAX_LABEL_VALUES", defaultMaxLabelValues),
+		statusClasses:  getEnvAsBool("METRICS_STATUS_CLASSES", false),
+	}
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsInt retrieves environment variable with a default value
+func getEnvAsInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			log.Panic().Err(err).Msg("metrics: error converting value to int")
+		}
+		return intValue
+	}
+	return defaultValue
+}
+
+// getEnvAsBool gets an environment variable as a boolean or returns a default value
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		return strings.ToLower(value) == "true"
+	}
+	return defaultValue
+}
+
+// parseBuckets splits the pipe separated list of histogram buckets, in seconds.
+func parseBuckets(value string) []float64 {
+	var buckets []float64
+	for _, item := range strings.Split(value, "|") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		bucket, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			log.Panic().Err(err).Msg("metrics: error converting bucket to float")
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestNewConfiguration(t *testing.T) {
+	os.Setenv("METRICS_NAMESPACE", "app")
+	os.Setenv("METRICS_BUCKETS", "0.1|0.5| 1")
+	os.Setenv("METRICS_MAX_LABEL_VALUES", "10")
+	os.Setenv("METRICS_STATUS_CLASSES", "true")
+	defer os.Unsetenv("METRICS_NAMESPACE")
+	defer os.Unsetenv("METRICS_BUCKETS")
+	defer os.Unsetenv("METRICS_MAX_LABEL_VALUES")
+	defer os.Unsetenv("METRICS_STATUS_CLASSES")
+
+	expectedCfg := Config{
+		namespace:      "app",
+		buckets:        []float64{0.1, 0.5, 1},
+		maxLabelValues: 10,
+		statusClasses:  true,
+	}
+	cfg := load()
+	assert.Equal(t, expectedCfg, cfg)
+}
+
+func TestNewConfiguration_Defaults(t *testing.T) {
+	cfg := load()
+	assert.Equal(t, "anysher", cfg.namespace)
+	assert.Equal(t, []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}, cfg.buckets)
+	assert.Equal(t, 100, cfg.maxLabelValues)
+	assert.False(t, cfg.statusClasses)
+}
+
+func TestNewConfiguration_InvalidBuckets(t *testing.T) {
+	os.Setenv("METRICS_BUCKETS", "fast")
+	defer os.Unsetenv("METRICS_BUCKETS")
+
+	assert.Panics(t, func() { load() })
+}
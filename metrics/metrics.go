@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// OtherValue replaces the label values beyond METRICS_MAX_LABEL_VALUES.
+	OtherValue = "other"
+	// UnmatchedRoute is the route label of the requests that don't match any Gin route.
+	UnmatchedRoute = "unmatched"
+)
+
+var (
+	once    sync.Once
+	current *instruments
+)
+
+// instruments contains the Prometheus collectors of every anysher component.
+type instruments struct {
+	registry *prometheus.Registry
+	config   Config
+	labels   *labelLimiter
+
+	serverRequests *prometheus.CounterVec
+	serverDuration *prometheus.HistogramVec
+	serverInFlight prometheus.Gauge
+
+	clientRequests *prometheus.CounterVec
+	clientDuration *prometheus.HistogramVec
+
+	kafkaMessages        *prometheus.CounterVec
+	kafkaDuration        *prometheus.HistogramVec
+	kafkaConsumed        *prometheus.CounterVec
+	kafkaProcessDuration *prometheus.HistogramVec
+
+	redisDuration *prometheus.HistogramVec
+	redisErrors   *prometheus.CounterVec
+
+	gatewayDeliveries *prometheus.CounterVec
+}
+
+// get returns the metrics shared by every component, created on first use.
+// It takes the configuration from environment variables:
+// - METRICS_NAMESPACE
+// - METRICS_BUCKETS
+// - METRICS_MAX_LABEL_VALUES
+// - METRICS_STATUS_CLASSES
+func get() *instruments {
+	once.Do(func() {
+		current = newInstruments(load())
+	})
+	return current
+}
+
+// newInstruments creates the collectors and registers them in a new registry,
+// along with the Go runtime and process collectors.
+func newInstruments(config Config) *instruments {
+	m := &instruments{
+		registry: prometheus.NewRegistry(),
+		config:   config,
+		labels:   newLabelLimiter(config.maxLabelValues),
+	}
+	counter := func(subsystem, name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: config.namespace, Subsystem: subsystem, Name: name, Help: help,
+		}, labels)
+	}
+	histogram := func(subsystem, name, help string, labels ...string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: config.namespace, Subsystem: subsystem, Name: name, Help: help, Buckets: config.buckets,
+		}, labels)
+	}
+
+	m.serverRequests = counter("http_server", "requests_total",
+		"Number of HTTP requests handled.", "method", "route", "status")
+	m.serverDuration = histogram("http_server", "request_duration_seconds",
+		"Duration of the HTTP requests handled.", "method", "route")
+	m.serverInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: config.namespace, Subsystem: "http_server", Name: "requests_in_flight",
+		Help: "Number of HTTP requests being handled.",
+	})
+	m.clientRequests = counter("http_client", "requests_total",
+		"Number of outbound HTTP requests, status is error when no response was received.", "method", "host", "status")
+	m.clientDuration = histogram("http_client", "request_duration_seconds",
+		"Duration of the outbound HTTP requests.", "method", "host")
+	m.kafkaMessages = counter("kafka", "messages_total",
+		"Number of messages produced, by delivery result.", "topic", "result")
+	m.kafkaDuration = histogram("kafka", "produce_duration_seconds",
+		"Duration from producing a message until its delivery report.", "topic")
+	m.kafkaConsumed = counter("kafka", "consumed_messages_total",
+		"Number of messages consumed, by handler result.", "topic", "result")
+	m.kafkaProcessDuration = histogram("kafka", "process_duration_seconds",
+		"Duration of the handler of the consumed messages.", "topic")
+	m.redisDuration = histogram("redis", "command_duration_seconds",
+		"Duration of the Redis commands.", "command")
+	m.redisErrors = counter("redis", "command_errors_total",
+		"Number of failed Redis commands, missing keys aren't errors.", "command")
+	m.gatewayDeliveries = counter("gateway", "deliveries_total",
+		"Number of response payloads sent to the gateway, status is error when no response was received.", "status")
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.serverRequests, m.serverDuration, m.serverInFlight,
+		m.clientRequests, m.clientDuration,
+		m.kafkaMessages, m.kafkaDuration,
+		m.kafkaConsumed, m.kafkaProcessDuration,
+		m.redisDuration, m.redisErrors,
+		m.gatewayDeliveries,
+	)
+	return m
+}
+
+// Registry returns the registry of the anysher metrics, where the application can register its own collectors
+// to expose them with Handler.
+func Registry() *prometheus.Registry {
+	return get().registry
+}
+
+// Handler returns a Gin handler that exposes the metrics in the Prometheus format, eg: router.GET("/metrics", metrics.Handler())
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(get().registry, promhttp.HandlerOpts{}))
+}
+
+// StartServerRequest counts a request in flight and returns the function that records it once it's handled.
+// An empty route is recorded as UnmatchedRoute, so the raw paths never become label values.
+func StartServerRequest() func(method, route string, status int) {
+	m := get()
+	start := time.Now()
+	m.serverInFlight.Inc()
+	return func(method, route string, status int) {
+		m.serverInFlight.Dec()
+		if route == "" {
+			route = UnmatchedRoute
+		} else {
+			route = m.labels.limit("route", route)
+		}
+		m.serverRequests.WithLabelValues(method, route, m.status(status, nil)).Inc()
+		m.serverDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveClientRequest records an outbound HTTP request, the error is the one returned by the transport.
+func ObserveClientRequest(method, host string, status int, err error, duration time.Duration) {
+	m := get()
+	host = m.labels.limit("host", host)
+	m.clientRequests.WithLabelValues(method, host, m.status(status, err)).Inc()
+	m.clientDuration.WithLabelValues(method, host).Observe(duration.Seconds())
+}
+
+// ObserveKafkaProduce records a produced message, the error is the produce or delivery failure.
+func ObserveKafkaProduce(topic string, err error, duration time.Duration) {
+	m := get()
+	topic = m.labels.limit("topic", topic)
+	if err != nil {
+		m.kafkaMessages.WithLabelValues(topic, "failed").Inc()
+		return
+	}
+	m.kafkaMessages.WithLabelValues(topic, "delivered").Inc()
+	m.kafkaDuration.WithLabelValues(topic).Observe(duration.Seconds())
+}
+
+// ObserveKafkaConsume records a consumed message, the error is the one returned by its handler.
+func ObserveKafkaConsume(topic string, err error, duration time.Duration) {
+	m := get()
+	topic = m.labels.limit("topic", topic)
+	m.kafkaProcessDuration.WithLabelValues(topic).Observe(duration.Seconds())
+	if err != nil {
+		m.kafkaConsumed.WithLabelValues(topic, "failed").Inc()
+		return
+	}
+	m.kafkaConsumed.WithLabelValues(topic, "processed").Inc()
+}
+
+// ObserveRedisCommand records a Redis command and counts it as failed when there's an error.
+func ObserveRedisCommand(command string, err error, duration time.Duration) {
+	m := get()
+	m.redisDuration.WithLabelValues(command).Observe(duration.Seconds())
+	if err != nil {
+		m.redisErrors.WithLabelValues(command).Inc()
+	}
+}
+
+// ObserveGatewayDelivery records a response payload sent to the gateway.
+func ObserveGatewayDelivery(status int, err error) {
+	m := get()
+	m.gatewayDeliveries.WithLabelValues(m.status(status, err)).Inc()
+}
+
+// status returns the status label, grouped by class when METRICS_STATUS_CLASSES is enabled.
+func (m *instruments) status(status int, err error) string {
+	if err != nil {
+		return "error"
+	}
+	if m.config.statusClasses {
+		return fmt.Sprintf("%dxx", status/100)
+	}
+	return strconv.Itoa(status)
+}
+
+// labelLimiter bounds the number of distinct values of each label to keep the cardinality under control.
+type labelLimiter struct {
+	mu     sync.Mutex
+	max    int
+	values map[string]map[string]struct{}
+}
+
+func newLabelLimiter(max int) *labelLimiter {
+	return &labelLimiter{max: max, values: map[string]map[string]struct{}{}}
+}
+
+// limit returns the value when it's already known or there's room for it, otherwise OtherValue.
+// A max lower than 1 doesn't limit the values.
+func (l *labelLimiter) limit(label, value string) string {
+	if l.max < 1 {
+		return value
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	values, ok := l.values[label]
+	if !ok {
+		values = map[string]struct{}{}
+		l.values[label] = values
+	}
+	if _, ok := values[value]; ok {
+		return value
+	}
+	if len(values) >= l.max {
+		return OtherValue
+	}
+	values[value] = struct{}{}
+	return value
+}
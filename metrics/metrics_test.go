@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartServerRequest(t *testing.T) {
+	m := get()
+	done := StartServerRequest()
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.serverInFlight))
+
+	done("GET", "/users/:id", 200)
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.serverInFlight))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.serverRequests.WithLabelValues("GET", "/users/:id", "200")))
+
+	StartServerRequest()("GET", "", 404)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.serverRequests.WithLabelValues("GET", UnmatchedRoute, "404")))
+}
+
+func TestObserveClientRequest(t *testing.T) {
+	m := get()
+	ObserveClientRequest("POST", "client.test:8080", 503, nil, time.Millisecond)
+	ObserveClientRequest("POST", "client.test:8080", 0, errors.New("connection refused"), time.Millisecond)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.clientRequests.WithLabelValues("POST", "client.test:8080", "503")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.clientRequests.WithLabelValues("POST", "client.test:8080", "error")))
+}
+
+func TestObserveKafkaProduce(t *testing.T) {
+	m := get()
+	ObserveKafkaProduce("metrics-topic", nil, time.Millisecond)
+	ObserveKafkaProduce("metrics-topic", errors.New("delivery error"), time.Millisecond)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.kafkaMessages.WithLabelValues("metrics-topic", "delivered")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.kafkaMessages.WithLabelValues("metrics-topic", "failed")))
+}
+
+func TestObserveRedisCommand(t *testing.T) {
+	m := get()
+	ObserveRedisCommand("HGET", nil, time.Millisecond)
+	ObserveRedisCommand("HGET", errors.New("redis error"), time.Millisecond)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.redisErrors.WithLabelValues("HGET")))
+}
+
+func TestObserveGatewayDelivery(t *testing.T) {
+	m := get()
+	before := testutil.ToFloat64(m.gatewayDeliveries.WithLabelValues("502"))
+	ObserveGatewayDelivery(502, nil)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(m.gatewayDeliveries.WithLabelValues("502")))
+}
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ObserveClientRequest("GET", "handler.test", 200, nil, time.Millisecond)
+
+	router := gin.New()
+	router.GET("/metrics", Handler())
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	body, _ := io.ReadAll(w.Body)
+	assert.Contains(t, string(body),
+		`anysher_http_client_requests_total{host="handler.test",method="GET",status="200"} 1`)
+	assert.Contains(t, string(body), "go_goroutines")
+}
+
+func TestStatus(t *testing.T) {
+	m := newInstruments(Config{namespace: "test", maxLabelValues: 10})
+	assert.Equal(t, "404", m.status(404, nil))
+	assert.Equal(t, "error", m.status(0, errors.New("error")))
+
+	m = newInstruments(Config{namespace: "test", maxLabelValues: 10, statusClasses: true})
+	assert.Equal(t, "4xx", m.status(404, nil))
+}
+
+func TestLabelLimiter(t *testing.T) {
+	limiter := newLabelLimiter(2)
+	assert.Equal(t, "a", limiter.limit("host", "a"))
+	assert.Equal(t, "b", limiter.limit("host", "b"))
+	assert.Equal(t, OtherValue, limiter.limit("host", "c"))
+	// known values are kept
+	assert.Equal(t, "a", limiter.limit("host", "a"))
+	// every label has its own limit
+	assert.Equal(t, "c", limiter.limit("topic", "c"))
+
+	unlimited := newLabelLimiter(0)
+	for _, value := range []string{"a", "b", "c"} {
+		assert.Equal(t, value, unlimited.limit("host", value))
+	}
+}
@@ -4,20 +4,28 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	anysherhttp "github.com/narumayase/anysher/http"
+	"github.com/narumayase/anysher/metrics"
+	"github.com/narumayase/anysher/propagation"
+	"github.com/narumayase/anysher/redact"
 	"github.com/rs/zerolog/log"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"strings"
+	"time"
 )
 
 const (
 	correlationIdHeader = "X-Correlation-ID"
 	routingIdHeader     = "X-Routing-ID"
 	requestIdHeader     = "X-Request-Id"
+
+	// defaultTimeout is the timeout of the HTTP client used when its configuration is invalid
+	defaultTimeout = 30 * time.Second
 )
 
 type bodyCaptureWriter struct {
@@ -31,8 +39,22 @@ type bodyCaptureWriter struct {
 // - GATEWAY_ENABLED
 // - GATEWAY_TOKEN
 // - GATEWAY_IGNORE_ENDPOINTS -> format eg: GET:health|POST:send
+// - HTTP_TIMEOUT, HTTP_CA_FILE, etc. -> transport of the HTTP client, see http.NewClientFromEnv
 // - LOG_LEVEL
+// When the transport configuration is invalid the error is logged and a default HTTP client is used.
 func Sender() gin.HandlerFunc {
+	// the gateway always needs the request, correlation and routing IDs, besides the configured ones
+	propagator := propagation.NewPropagator().With(requestIdHeader, correlationIdHeader, routingIdHeader)
+	redactor := redact.NewRedactor()
+	opts := []anysherhttp.Option{
+		anysherhttp.WithPropagator(propagator), anysherhttp.WithRedactor(redactor), anysherhttp.WithStatusErrors(),
+	}
+	httpClient, err := anysherhttp.NewClientFromEnv(opts...)
+	if err != nil {
+		log.Error().Err(err).Msg("gateway: invalid HTTP configuration, using a default HTTP client")
+		httpClient = anysherhttp.NewClient(&http.Client{Timeout: defaultTimeout}, opts...)
+	}
+
 	return func(c *gin.Context) {
 		config := load()
 
@@ -55,14 +77,6 @@ func Sender() gin.HandlerFunc {
 
 		c.Next()
 
-		requestID := c.Request.Header.Get(requestIdHeader)
-		if requestID == "" {
-			// Generate a new one if not present
-			requestID = uuid.NewString()
-		}
-		correlationID := c.Request.Header.Get(correlationIdHeader)
-		routingID := c.Request.Header.Get(routingIdHeader)
-
 		ctx := c.Request.Context()
 
 		type Message struct {
@@ -76,30 +90,38 @@ func Sender() gin.HandlerFunc {
 			return
 		}
 
-		httpClient := anysherhttp.NewClient(&http.Client{})
-		resp, err := httpClient.Post(context.Background(), anysherhttp.Payload{
+		// the IDs of the incoming request are added to the outbound request by the propagator
+		sendCtx := propagator.ContextWithHeaders(context.WithoutCancel(ctx), c.Request.Header)
+		if c.Request.Header.Get(requestIdHeader) == "" {
+			// Generate a new one if not present
+			sendCtx = propagation.ContextWithValue(sendCtx, requestIdHeader, uuid.NewString())
+		}
+		resp, err := httpClient.Post(sendCtx, anysherhttp.Payload{
 			URL:   config.gatewayAPIUrl,
 			Token: config.gatewayToken,
 			Headers: map[string]string{
-				"Content-Type":      "application/json",
-				correlationIdHeader: correlationID,
-				routingIdHeader:     routingID,
-				requestIdHeader:     requestID,
+				"Content-Type": "application/json",
 			},
 			Content: payloadBytes,
 		})
+		var statusErr *anysherhttp.StatusError
+		if errors.As(err, &statusErr) {
+			metrics.ObserveGatewayDelivery(statusErr.StatusCode, nil)
+			log.Ctx(ctx).Error().Err(
+				fmt.Errorf("llm status code %d body %+v", statusErr.StatusCode, redactor.Body(statusErr.Body))).
+				Msg("failed to send response payload to gateway")
+			return
+		}
 		if err != nil {
+			metrics.ObserveGatewayDelivery(0, err)
 			log.Ctx(ctx).Error().Err(err).Msg("failed to send response payload to gateway")
 			return
 		}
-		body, _ := ioutil.ReadAll(resp.Body)
+		defer resp.Body.Close()
+		metrics.ObserveGatewayDelivery(resp.StatusCode, nil)
+		// drain the body so the connection is reused
+		_, _ = io.Copy(io.Discard, resp.Body)
 
-		if resp.StatusCode != http.StatusOK {
-			log.Ctx(ctx).Error().Err(
-				fmt.Errorf("llm status code %d body %+v", resp.StatusCode, string(body))).
-				Msg("failed to send response payload to gateway")
-			return
-		}
 		log.Ctx(ctx).Info().Msg("response payload sent to gateway successfully")
 	}
 }
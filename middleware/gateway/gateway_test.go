@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	anysherhttp "github.com/narumayase/anysher/http"
+	"github.com/narumayase/anysher/internal/metricstest"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -45,3 +47,81 @@ func TestSenderMiddleware(t *testing.T) {
 
 	assert.Equal(t, 200, w.Code)
 }
+
+func TestSenderMiddleware_Enabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	received := make(chan *http.Request, 1)
+	gatewayServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Clone(context.Background())
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gatewayServer.Close()
+
+	os.Setenv("GATEWAY_ENABLED", "true")
+	os.Setenv("GATEWAY_API_URL", gatewayServer.URL)
+	defer os.Unsetenv("GATEWAY_ENABLED")
+	defer os.Unsetenv("GATEWAY_API_URL")
+
+	r := gin.New()
+	r.Use(Sender())
+	r.POST("/test", func(c *gin.Context) {
+		c.String(200, "ok")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader([]byte(`{"foo":"bar"}`)))
+	req.Header.Set("X-Correlation-ID", "a-correlation")
+	req.Header.Set("X-Routing-ID", "a-routing")
+	w := httptest.NewRecorder()
+	delivered := metricstest.CounterValue(t, "anysher_gateway_deliveries_total", map[string]string{"status": "200"})
+
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, delivered+1,
+		metricstest.CounterValue(t, "anysher_gateway_deliveries_total", map[string]string{"status": "200"}))
+	sent := <-received
+	assert.Equal(t, "a-correlation", sent.Header.Get("X-Correlation-ID"))
+	assert.Equal(t, "a-routing", sent.Header.Get("X-Routing-ID"))
+	assert.NotEmpty(t, sent.Header.Get("X-Request-Id"))
+}
+
+func TestSenderMiddleware_InvalidHTTPConfig(t *testing.T) {
+	os.Setenv("HTTP_MIN_TLS_VERSION", "1.4")
+	defer os.Unsetenv("HTTP_MIN_TLS_VERSION")
+
+	// the middleware falls back to a default HTTP client instead of panicking
+	assert.NotPanics(t, func() { Sender() })
+}
+
+func TestSenderMiddleware_ErrorStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	gatewayServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte(`{"error":"unavailable"}`))
+	}))
+	defer gatewayServer.Close()
+
+	os.Setenv("GATEWAY_ENABLED", "true")
+	os.Setenv("GATEWAY_API_URL", gatewayServer.URL)
+	defer os.Unsetenv("GATEWAY_ENABLED")
+	defer os.Unsetenv("GATEWAY_API_URL")
+
+	r := gin.New()
+	r.Use(Sender())
+	r.POST("/test", func(c *gin.Context) {
+		c.String(200, "ok")
+	})
+
+	labels := map[string]string{"status": "502"}
+	failed := metricstest.CounterValue(t, "anysher_gateway_deliveries_total", labels)
+
+	req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader([]byte(`{"foo":"bar"}`)))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	// the response of the handler isn't affected by the gateway
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, failed+1, metricstest.CounterValue(t, "anysher_gateway_deliveries_total", labels))
+}
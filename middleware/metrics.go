@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/narumayase/anysher/metrics"
+)
+
+// Metrics is a middleware that records the count, latency and in-flight requests in the metrics package.
+// Requests are labelled by the route template, eg: /users/:id, so the label cardinality is bounded by the routes.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		done := metrics.StartServerRequest()
+
+		c.Next()
+
+		done(c.Request.Method, c.FullPath(), c.Writer.Status())
+	}
+}
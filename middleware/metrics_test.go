@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/narumayase/anysher/internal/metricstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(Metrics())
+	router.GET("/metrics-test/:id", func(c *gin.Context) {
+		assert.Equal(t, 1.0, metricstest.GaugeValue(t, "anysher_http_server_requests_in_flight", nil))
+		c.Status(http.StatusCreated)
+	})
+
+	matched := map[string]string{"method": "GET", "route": "/metrics-test/:id", "status": "201"}
+	unmatched := map[string]string{"method": "GET", "route": "unmatched", "status": "404"}
+	matchedBefore := metricstest.CounterValue(t, "anysher_http_server_requests_total", matched)
+	unmatchedBefore := metricstest.CounterValue(t, "anysher_http_server_requests_total", unmatched)
+
+	for _, path := range []string{"/metrics-test/1", "/metrics-test/2", "/not-found"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// the route template is the label, not the path
+	assert.Equal(t, matchedBefore+2, metricstest.CounterValue(t, "anysher_http_server_requests_total", matched))
+	assert.Equal(t, unmatchedBefore+1, metricstest.CounterValue(t, "anysher_http_server_requests_total", unmatched))
+	assert.Equal(t, uint64(2), metricstest.HistogramCount(t, "anysher_http_server_request_duration_seconds",
+		map[string]string{"method": "GET", "route": "/metrics-test/:id"}))
+	assert.Equal(t, 0.0, metricstest.GaugeValue(t, "anysher_http_server_requests_in_flight", nil))
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/narumayase/anysher/redact"
 	"github.com/rs/zerolog/log"
 )
 
@@ -72,7 +73,10 @@ func ErrorHandler() gin.HandlerFunc {
 // HeadersToContext is a Gin middleware that takes every incoming request header
 // and stores it individually into the request context.
 // This allows you to access any header later in the request lifecycle using ctx.Value(headerName).
+// The logged headers are redacted according to REDACT_HEADERS.
 func HeadersToContext() gin.HandlerFunc {
+	redactor := redact.NewRedactor()
+
 	return func(c *gin.Context) {
 		ctx := c.Request.Context()
 
@@ -85,7 +89,7 @@ func HeadersToContext() gin.HandlerFunc {
 				ctx = context.WithValue(ctx, k, v[0])
 			}
 		}
-		log.Ctx(ctx).Info().Msgf("headers received: %+v", c.Request.Header)
+		log.Ctx(ctx).Info().Msgf("headers received: %+v", redactor.Headers(c.Request.Header))
 		// Replace the request with the new one that has the updated context
 		c.Request = c.Request.WithContext(ctx)
 
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	originalProvider := otel.GetTracerProvider()
+	originalPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(originalProvider)
+		otel.SetTextMapPropagator(originalPropagator)
+	})
+	return exporter
+}
+
+func TestTracing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("server span is recorded with the route", func(t *testing.T) {
+		exporter := setupTracing(t)
+
+		var logs bytes.Buffer
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Request = c.Request.WithContext(zerolog.New(&logs).WithContext(c.Request.Context()))
+		})
+		router.Use(Tracing())
+		router.GET("/users/:id", func(c *gin.Context) {
+			log.Ctx(c.Request.Context()).Info().Msg("handled")
+			c.JSON(200, gin.H{"message": "ok"})
+		})
+
+		req, _ := http.NewRequest("GET", "/users/42", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "GET /users/:id", spans[0].Name)
+		assert.Equal(t, trace.SpanKindServer, spans[0].SpanKind)
+		assert.Contains(t, spans[0].Attributes, attribute.Int("http.response.status_code", 200))
+		assert.Contains(t, spans[0].Attributes, attribute.String("http.route", "/users/:id"))
+		assert.Equal(t, codes.Unset, spans[0].Status.Code)
+		assert.Contains(t, logs.String(), `"trace_id":"`+spans[0].SpanContext.TraceID().String()+`"`)
+		assert.Contains(t, logs.String(), `"span_id":"`+spans[0].SpanContext.SpanID().String()+`"`)
+	})
+
+	t.Run("incoming traceparent is continued", func(t *testing.T) {
+		exporter := setupTracing(t)
+
+		router := gin.New()
+		router.Use(Tracing())
+		router.GET("/test", func(c *gin.Context) {
+			c.Status(200)
+		})
+
+		req, _ := http.NewRequest("GET", "/test", nil)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext.TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent.SpanID().String())
+	})
+
+	t.Run("server errors set the span status", func(t *testing.T) {
+		exporter := setupTracing(t)
+
+		router := gin.New()
+		router.Use(Tracing())
+		router.GET("/fail", func(c *gin.Context) {
+			c.Status(503)
+		})
+
+		req, _ := http.NewRequest("GET", "/fail", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, codes.Error, spans[0].Status.Code)
+	})
+}
@@ -0,0 +1,55 @@
+package propagation
+
+import (
+	"github.com/joho/godotenv"
+	anysherlog "github.com/narumayase/anysher/log"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultHeaders are the headers propagated when PROPAGATION_HEADERS isn't set.
+const defaultHeaders = "X-Request-Id|X-Correlation-Id|X-Routing-Id"
+
+// Config contains the configuration of the propagated headers.
+type Config struct {
+	headers []string
+}
+
+// load loads configuration from environment variables or an .env file
+// It takes the configuration from environment variables:
+// - PROPAGATION_HEADERS -> format eg: X-Request-Id|X-Correlation-Id
+// - LOG_LEVEL
+func load() Config {
+	// Load .env file if it exists (ignore error if file doesn't exist)
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file found or error loading .env file: %v", err)
+	}
+	anysherlog.SetLogLevel()
+
+	return Config{
+		headers: parseHeaders(getEnv("PROPAGATION_HEADERS", defaultHeaders)),
+	}
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// parseHeaders splits the pipe separated list of headers and canonicalizes their names.
+func parseHeaders(value string) []string {
+	var headers []string
+	for _, header := range strings.Split(value, "|") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		headers = append(headers, http.CanonicalHeaderKey(header))
+	}
+	return headers
+}
@@ -0,0 +1,24 @@
+package propagation
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestNewConfiguration(t *testing.T) {
+	t.Run("default headers", func(t *testing.T) {
+		os.Unsetenv("PROPAGATION_HEADERS")
+
+		cfg := load()
+		assert.Equal(t, Config{headers: []string{"X-Request-Id", "X-Correlation-Id", "X-Routing-Id"}}, cfg)
+	})
+
+	t.Run("configured headers", func(t *testing.T) {
+		os.Setenv("PROPAGATION_HEADERS", "x-request-id| X-Tenant-ID ||")
+		defer os.Unsetenv("PROPAGATION_HEADERS")
+
+		cfg := load()
+		assert.Equal(t, Config{headers: []string{"X-Request-Id", "X-Tenant-Id"}}, cfg)
+	})
+}
@@ -0,0 +1,83 @@
+package propagation
+
+import (
+	"context"
+	"net/http"
+	"slices"
+)
+
+// Propagator reads the tracing IDs (request, correlation and routing IDs by default) from the
+// context so they can be added to every outbound HTTP request and Kafka message.
+// The IDs are stored in the context under their canonical header name, the same way
+// middleware.HeadersToContext stores the incoming headers.
+type Propagator struct {
+	headers []string
+}
+
+// NewPropagator creates a new Propagator for the given headers.
+// When no header is given, it takes them from the environment variable:
+// - PROPAGATION_HEADERS -> format eg: X-Request-Id|X-Correlation-Id|X-Routing-Id (default)
+func NewPropagator(headers ...string) *Propagator {
+	if len(headers) == 0 {
+		return &Propagator{headers: load().headers}
+	}
+	canonical := make([]string, 0, len(headers))
+	for _, header := range headers {
+		canonical = append(canonical, http.CanonicalHeaderKey(header))
+	}
+	return &Propagator{headers: canonical}
+}
+
+// Headers returns the names of the propagated headers.
+func (p *Propagator) Headers() []string {
+	return p.headers
+}
+
+// With returns a new Propagator that also propagates the given headers.
+func (p *Propagator) With(headers ...string) *Propagator {
+	all := append([]string{}, p.headers...)
+	for _, header := range headers {
+		header = http.CanonicalHeaderKey(header)
+		if !slices.Contains(all, header) {
+			all = append(all, header)
+		}
+	}
+	return &Propagator{headers: all}
+}
+
+// Extract returns the propagated headers found in the context.
+func (p *Propagator) Extract(ctx context.Context) map[string]string {
+	values := make(map[string]string)
+	for _, header := range p.headers {
+		if value, ok := ctx.Value(header).(string); ok && value != "" {
+			values[header] = value
+		}
+	}
+	return values
+}
+
+// Inject sets the propagated headers found in the context into the HTTP headers.
+// Headers that are already set are kept.
+func (p *Propagator) Inject(ctx context.Context, header http.Header) {
+	for key, value := range p.Extract(ctx) {
+		if header.Get(key) == "" {
+			header.Set(key, value)
+		}
+	}
+}
+
+// ContextWithHeaders stores the propagated headers found in the HTTP headers into the context.
+func (p *Propagator) ContextWithHeaders(ctx context.Context, header http.Header) context.Context {
+	for _, key := range p.headers {
+		if value := header.Get(key); value != "" {
+			ctx = ContextWithValue(ctx, key, value)
+		}
+	}
+	return ctx
+}
+
+// ContextWithValue stores the value of a header into the context under its canonical name.
+func ContextWithValue(ctx context.Context, header, value string) context.Context {
+	//nolint:staticcheck // headers are stored by name to stay compatible with middleware.HeadersToContext
+	return context.WithValue(ctx, http.CanonicalHeaderKey(header), value)
+}
@@ -0,0 +1,53 @@
+package propagation
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPropagator_Extract(t *testing.T) {
+	propagator := NewPropagator("X-Request-Id", "x-correlation-id")
+
+	ctx := ContextWithValue(context.Background(), "x-request-id", "a-request")
+	ctx = ContextWithValue(ctx, "X-Correlation-ID", "a-correlation")
+	ctx = ContextWithValue(ctx, "X-Other", "not propagated")
+
+	assert.Equal(t, map[string]string{
+		"X-Request-Id":     "a-request",
+		"X-Correlation-Id": "a-correlation",
+	}, propagator.Extract(ctx))
+}
+
+func TestPropagator_Inject(t *testing.T) {
+	propagator := NewPropagator("X-Request-Id", "X-Correlation-Id")
+
+	ctx := ContextWithValue(context.Background(), "X-Request-Id", "a-request")
+	ctx = ContextWithValue(ctx, "X-Correlation-Id", "a-correlation")
+
+	header := http.Header{}
+	header.Set("X-Correlation-Id", "kept")
+	propagator.Inject(ctx, header)
+
+	assert.Equal(t, "a-request", header.Get("X-Request-Id"))
+	assert.Equal(t, "kept", header.Get("X-Correlation-Id"))
+}
+
+func TestPropagator_ContextWithHeaders(t *testing.T) {
+	propagator := NewPropagator("X-Request-Id", "X-Routing-Id")
+
+	header := http.Header{}
+	header.Set("X-Request-Id", "a-request")
+	header.Set("X-Other", "ignored")
+
+	ctx := propagator.ContextWithHeaders(context.Background(), header)
+	assert.Equal(t, map[string]string{"X-Request-Id": "a-request"}, propagator.Extract(ctx))
+	assert.Nil(t, ctx.Value("X-Other"))
+}
+
+func TestPropagator_With(t *testing.T) {
+	propagator := NewPropagator("X-Request-Id").With("x-request-id", "X-Tenant-Id")
+	assert.Equal(t, []string{"X-Request-Id", "X-Tenant-Id"}, propagator.Headers())
+}
@@ -0,0 +1,83 @@
+package redact
+
+import (
+	"github.com/joho/godotenv"
+	anysherlog "github.com/narumayase/anysher/log"
+	"github.com/rs/zerolog/log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultHeaders = "Authorization|Proxy-Authorization|Cookie|Set-Cookie|X-Api-Key"
+	defaultFields  = "password|secret|token|access_token|refresh_token|client_secret|api_key"
+	defaultMaxBody = 2048
+)
+
+// Config contains the configuration of the redaction of logs.
+type Config struct {
+	headers []string
+	fields  []string
+	maxBody int
+}
+
+// load loads configuration from environment variables or an .env file
+// It takes the configuration from environment variables:
+// - REDACT_HEADERS -> format eg: Authorization|X-Api-Key
+// - REDACT_FIELDS -> format eg: password|user.email|cards.*.number
+// - REDACT_MAX_BODY
+// - LOG_LEVEL
+func load() Config {
+	// Load .env file if it exists (ignore error if file doesn't exist)
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file found or error loading .env file: %v", err)
+	}
+	anysherlog.SetLogLevel()
+
+	return Config{
+		headers: splitList(getEnv("REDACT_HEADERS", defaultHeaders)),
+		fields:  splitList(getEnv("REDACT_FIELDS", defaultFields)),
+		maxBody: getEnvAsInt("REDACT_MAX_BODY", defaultMaxBody),
+	}
+}
+
+// defaultConfig returns the configuration used when no environment variable is set.
+func defaultConfig() Config {
+	return Config{
+		headers: splitList(defaultHeaders),
+		fields:  splitList(defaultFields),
+		maxBody: defaultMaxBody,
+	}
+}
+
+// getEnv gets an environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvAsInt retrieves environment variable with a default value
+func getEnvAsInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			log.Panic().Err(err).Msg("redact: error converting value to int")
+		}
+		return intValue
+	}
+	return defaultValue
+}
+
+// splitList splits a pipe separated list.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, "|") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,29 @@
+package redact
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestNewConfiguration(t *testing.T) {
+	os.Setenv("REDACT_HEADERS", "Authorization|X-Secret")
+	os.Setenv("REDACT_FIELDS", "password|user.email")
+	os.Setenv("REDACT_MAX_BODY", "100")
+	defer os.Unsetenv("REDACT_HEADERS")
+	defer os.Unsetenv("REDACT_FIELDS")
+	defer os.Unsetenv("REDACT_MAX_BODY")
+
+	expectedCfg := Config{
+		headers: []string{"Authorization", "X-Secret"},
+		fields:  []string{"password", "user.email"},
+		maxBody: 100,
+	}
+	cfg := load()
+	assert.Equal(t, expectedCfg, cfg)
+}
+
+func TestNewConfiguration_Defaults(t *testing.T) {
+	cfg := load()
+	assert.Equal(t, defaultConfig(), cfg)
+}
@@ -0,0 +1,191 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Mask replaces the redacted values.
+const Mask = "[REDACTED]"
+
+// Redactor masks secrets and personal data before they are written to the logs.
+// It masks the values of a denylist of headers, masks JSON fields by path and truncates large bodies.
+type Redactor struct {
+	headers map[string]struct{}
+	fields  [][]string
+	maxBody int
+}
+
+// NewRedactor creates a new Redactor.
+// It takes the configuration from environment variables:
+// - REDACT_HEADERS -> headers to mask, eg: Authorization|X-Api-Key (default: Authorization|Proxy-Authorization|Cookie|Set-Cookie|X-Api-Key)
+// - REDACT_FIELDS -> JSON fields to mask, eg: password|user.email|cards.*.number
+// - REDACT_MAX_BODY -> bytes of the body that are logged (default: 2048, 0 or less means no limit)
+// A field without dots is masked at any depth, while a dotted path starts at the root of the document
+// and accepts * to match any field or array element.
+func NewRedactor() *Redactor {
+	return newRedactor(load())
+}
+
+var defaultRedactor = newRedactor(defaultConfig())
+
+func newRedactor(config Config) *Redactor {
+	r := &Redactor{
+		headers: make(map[string]struct{}, len(config.headers)),
+		maxBody: config.maxBody,
+	}
+	for _, header := range config.headers {
+		r.headers[strings.ToLower(header)] = struct{}{}
+	}
+	for _, field := range config.fields {
+		r.fields = append(r.fields, strings.Split(field, "."))
+	}
+	return r
+}
+
+// orDefault returns the default Redactor for nil receivers, so a zero value struct holding a nil
+// *Redactor still redacts its logs.
+func (r *Redactor) orDefault() *Redactor {
+	if r == nil {
+		return defaultRedactor
+	}
+	return r
+}
+
+// Headers returns a copy of the HTTP headers with the denied values masked.
+func (r *Redactor) Headers(header http.Header) http.Header {
+	r = r.orDefault()
+	redacted := make(http.Header, len(header))
+	for key, values := range header {
+		if _, ok := r.headers[strings.ToLower(key)]; ok {
+			redacted[key] = []string{Mask}
+			continue
+		}
+		redacted[key] = values
+	}
+	return redacted
+}
+
+// Map returns a copy of the headers, eg: Kafka message headers, with the denied values masked.
+func (r *Redactor) Map(headers map[string]string) map[string]string {
+	r = r.orDefault()
+	redacted := make(map[string]string, len(headers))
+	for key, value := range headers {
+		if _, ok := r.headers[strings.ToLower(key)]; ok {
+			value = Mask
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
+
+// Query returns a copy of the query parameters with the values of the denied fields masked.
+// Only the fields without dots apply, eg: token or client_secret.
+func (r *Redactor) Query(query url.Values) url.Values {
+	r = r.orDefault()
+	redacted := make(url.Values, len(query))
+	for key, values := range query {
+		redacted[key] = values
+		for _, path := range r.fields {
+			if len(path) == 1 && strings.EqualFold(path[0], key) {
+				redacted[key] = []string{Mask}
+				break
+			}
+		}
+	}
+	return redacted
+}
+
+// Form returns the application/x-www-form-urlencoded body with the values of the denied fields masked,
+// like Query does. A body that can't be parsed is masked as a whole.
+func (r *Redactor) Form(body []byte) []byte {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return []byte(Mask)
+	}
+	return []byte(r.Query(form).Encode())
+}
+
+// Content returns the body with the denied fields masked according to its content type: the fields of
+// application/x-www-form-urlencoded forms, see Form, and of JSON documents, see JSON.
+// Unlike Body, it's never truncated.
+func (r *Redactor) Content(contentType string, body []byte) []byte {
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/x-www-form-urlencoded" {
+		return r.Form(body)
+	}
+	return r.JSON(body)
+}
+
+// JSON returns the body with the denied fields masked when it's a JSON document, otherwise the body as it is.
+// Unlike Body, it's never truncated.
+func (r *Redactor) JSON(body []byte) []byte {
+	r = r.orDefault()
+	if len(r.fields) == 0 || !json.Valid(body) {
+		return body
+	}
+	var document any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return body
+	}
+	for _, path := range r.fields {
+		document = maskPath(document, path, len(path) == 1)
+	}
+	masked, err := json.Marshal(document)
+	if err != nil {
+		return body
+	}
+	return masked
+}
+
+// Body returns the body to log: JSON bodies get their denied fields masked and every body is
+// truncated to the configured size.
+func (r *Redactor) Body(body []byte) string {
+	r = r.orDefault()
+	body = r.JSON(body)
+	if r.maxBody > 0 && len(body) > r.maxBody {
+		return fmt.Sprintf("%s...(%d bytes truncated)", body[:r.maxBody], len(body)-r.maxBody)
+	}
+	return string(body)
+}
+
+// maskPath masks the values of the document found at the path. When anywhere is true, the path
+// is also looked up in every nested object and array.
+func maskPath(document any, path []string, anywhere bool) any {
+	switch value := document.(type) {
+	case map[string]any:
+		for key, child := range value {
+			if path[0] == "*" || strings.EqualFold(path[0], key) {
+				if len(path) == 1 {
+					value[key] = Mask
+					continue
+				}
+				value[key] = maskPath(child, path[1:], false)
+			}
+			if anywhere {
+				value[key] = maskPath(value[key], path, true)
+			}
+		}
+	case []any:
+		for i, child := range value {
+			if anywhere {
+				value[i] = maskPath(child, path, true)
+				continue
+			}
+			if path[0] == "*" {
+				if len(path) == 1 {
+					value[i] = Mask
+					continue
+				}
+				value[i] = maskPath(child, path[1:], false)
+			}
+		}
+	}
+	return document
+}
@@ -0,0 +1,121 @@
+package redact
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor_Headers(t *testing.T) {
+	r := newRedactor(Config{headers: []string{"Authorization", "x-api-key"}})
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer a-token")
+	header.Set("X-Api-Key", "a-key")
+	header.Set("Content-Type", "application/json")
+
+	redacted := r.Headers(header)
+	assert.Equal(t, Mask, redacted.Get("Authorization"))
+	assert.Equal(t, Mask, redacted.Get("X-Api-Key"))
+	assert.Equal(t, "application/json", redacted.Get("Content-Type"))
+	// the original headers are untouched
+	assert.Equal(t, "Bearer a-token", header.Get("Authorization"))
+}
+
+func TestRedactor_Map(t *testing.T) {
+	r := newRedactor(Config{headers: []string{"Authorization"}})
+
+	redacted := r.Map(map[string]string{"authorization": "a-token", "correlation_id": "123"})
+	assert.Equal(t, map[string]string{"authorization": Mask, "correlation_id": "123"}, redacted)
+}
+
+func TestRedactor_Body(t *testing.T) {
+	r := newRedactor(Config{fields: []string{"password", "user.email", "cards.*.number"}})
+
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "field at any depth",
+			body:     `{"password":"a","nested":{"password":"b","list":[{"password":"c"}]}}`,
+			expected: `{"nested":{"list":[{"password":"[REDACTED]"}],"password":"[REDACTED]"},"password":"[REDACTED]"}`,
+		},
+		{
+			name:     "path from the root",
+			body:     `{"user":{"email":"a@b.c","name":"a"},"email":"kept"}`,
+			expected: `{"email":"kept","user":{"email":"[REDACTED]","name":"a"}}`,
+		},
+		{
+			name:     "wildcard over arrays",
+			body:     `{"cards":[{"number":"4111","brand":"visa"},{"number":"5500"}]}`,
+			expected: `{"cards":[{"brand":"visa","number":"[REDACTED]"},{"number":"[REDACTED]"}]}`,
+		},
+		{
+			name:     "numbers are preserved",
+			body:     `{"amount":12345678901234567890}`,
+			expected: `{"amount":12345678901234567890}`,
+		},
+		{
+			name:     "non json body",
+			body:     `password=a`,
+			expected: `password=a`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, r.Body([]byte(tt.body)))
+		})
+	}
+}
+
+func TestRedactor_Query(t *testing.T) {
+	r := newRedactor(Config{fields: []string{"api_key", "user.email"}})
+
+	query := url.Values{"API_KEY": {"a-key"}, "page": {"2"}, "email": {"a-mail"}}
+	redacted := r.Query(query)
+	assert.Equal(t, url.Values{"API_KEY": {Mask}, "page": {"2"}, "email": {"a-mail"}}, redacted)
+	// the original query is untouched
+	assert.Equal(t, "a-key", query.Get("API_KEY"))
+}
+
+func TestRedactor_Content(t *testing.T) {
+	r := newRedactor(Config{fields: []string{"client_secret"}})
+
+	form := []byte("client_id=an-id&client_secret=a-secret")
+	assert.Equal(t, "client_id=an-id&client_secret=%5BREDACTED%5D",
+		string(r.Content("application/x-www-form-urlencoded; charset=utf-8", form)))
+	assert.Equal(t, Mask, string(r.Form([]byte("client_secret=%zz"))))
+	assert.Equal(t, `{"client_secret":"[REDACTED]"}`,
+		string(r.Content("application/json", []byte(`{"client_secret":"a-secret"}`))))
+	// a form sent as another content type isn't parsed
+	assert.Equal(t, string(form), string(r.Content("text/plain", form)))
+}
+
+func TestRedactor_JSON(t *testing.T) {
+	r := newRedactor(Config{fields: []string{"password"}, maxBody: 5})
+
+	assert.Equal(t, `{"password":"[REDACTED]","user":"a-user"}`,
+		string(r.JSON([]byte(`{"user":"a-user","password":"a-secret"}`))))
+	assert.Equal(t, "not json", string(r.JSON([]byte("not json"))))
+}
+
+func TestRedactor_BodyTruncation(t *testing.T) {
+	r := newRedactor(Config{maxBody: 10})
+
+	body := r.Body([]byte(strings.Repeat("a", 25)))
+	assert.Equal(t, "aaaaaaaaaa...(15 bytes truncated)", body)
+}
+
+func TestRedactor_NilUsesDefaults(t *testing.T) {
+	var r *Redactor
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer a-token")
+	assert.Equal(t, Mask, r.Headers(header).Get("Authorization"))
+	assert.Equal(t, `{"token":"[REDACTED]"}`, r.Body([]byte(`{"token":"a"}`)))
+}
@@ -0,0 +1,34 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/narumayase/anysher/metrics"
+	"github.com/narumayase/anysher/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrument starts an OpenTelemetry client span for the Redis command and returns the function
+// that ends it and records the command in the metrics package. A missing key isn't recorded as an error.
+func (r *Repository) instrument(ctx context.Context, command string) (context.Context, func(err error)) {
+	ctx, span := tracing.Tracer().Start(ctx, command,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemRedis,
+			semconv.DBOperationName(command),
+			semconv.DBNamespace(fmt.Sprint(r.client.Options().DB)),
+			semconv.ServerAddress(r.client.Options().Addr),
+		))
+	start := time.Now()
+	return tracing.ContextWithLogger(ctx), func(err error) {
+		if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+		tracing.End(span, err)
+		metrics.ObserveRedisCommand(command, err, time.Since(start))
+	}
+}
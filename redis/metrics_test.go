@@ -0,0 +1,32 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/narumayase/anysher/internal/metricstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisRepository_Metrics(t *testing.T) {
+	ctx := context.Background()
+	db, mock := redismock.NewClientMock()
+	repo := &Repository{client: db}
+
+	errorsBefore := metricstest.CounterValue(t, "anysher_redis_command_errors_total", map[string]string{"command": "GET"})
+	callsBefore := metricstest.HistogramCount(t, "anysher_redis_command_duration_seconds", map[string]string{"command": "GET"})
+
+	mock.ExpectGet("missing").RedisNil()
+	mock.ExpectGet("broken").SetErr(errors.New("redis error"))
+	_, _ = repo.Get(ctx, "missing")
+	_, _ = repo.Get(ctx, "broken")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// a missing key isn't an error
+	assert.Equal(t, errorsBefore+1, metricstest.CounterValue(t, "anysher_redis_command_errors_total",
+		map[string]string{"command": "GET"}))
+	assert.Equal(t, callsBefore+2, metricstest.HistogramCount(t, "anysher_redis_command_duration_seconds",
+		map[string]string{"command": "GET"}))
+}
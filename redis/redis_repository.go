@@ -2,17 +2,39 @@ package redis
 
 import (
 	"context"
+	"errors"
+	"github.com/narumayase/anysher/redact"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 	"time"
 )
 
+// ErrNotFound is returned by Get when the key doesn't exist.
+var ErrNotFound = redis.Nil
+
+// incrementScript increments the counter and sets its expiration, in milliseconds, when it's created,
+// so a counter is never left without expiration.
+var incrementScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
 // Repository implements the CacheRepository interface using Redis.
 type Repository struct {
-	client *redis.Client
+	client   *redis.Client
+	redactor *redact.Redactor
 }
 
 // NewRepository creates a new instance of RedisRepository.
+// It takes the configuration from environment variables:
+// - CACHE_ADDRESS
+// - CACHE_PASSWORD
+// - CACHE_DATABASE
+// - LOG_LEVEL
+// - REDACT_FIELDS and REDACT_MAX_BODY -> redaction of the logged data
 func NewRepository() *Repository {
 	config := load()
 	rdb := redis.NewClient(&redis.Options{
@@ -21,28 +43,79 @@ func NewRepository() *Repository {
 		DB:       config.cacheDatabase,
 	})
 	return &Repository{
-		client: rdb,
+		client:   rdb,
+		redactor: redact.NewRedactor(),
+	}
+}
+
+// NewRepositoryWithClient creates a new instance of RedisRepository on top of an existing Redis client.
+func NewRepositoryWithClient(client *redis.Client) *Repository {
+	return &Repository{
+		client:   client,
+		redactor: redact.NewRedactor(),
 	}
 }
 
-// Save saves the data into redis
+// Save saves the data into redis for 24 hours
 func (r *Repository) Save(ctx context.Context, key string, data []byte) error {
-	// TODO ver de hacer configurable esta duración
-	if err := r.client.Set(ctx, key, data, 24*time.Hour).Err(); err != nil {
+	return r.SaveWithExpiration(ctx, key, data, 24*time.Hour)
+}
+
+// SaveWithExpiration saves the data into redis for the given time
+func (r *Repository) SaveWithExpiration(ctx context.Context, key string, data []byte, expiration time.Duration) (err error) {
+	ctx, done := r.instrument(ctx, "SET")
+	defer func() { done(err) }()
+
+	if err = r.client.Set(ctx, key, data, expiration).Err(); err != nil {
 		log.Ctx(ctx).Error().Err(err).Msg("failed to save metadata")
 		return err
 	}
-	log.Ctx(ctx).Debug().Msgf("metadata saved in Redis: %s:%v", key, string(data))
+	log.Ctx(ctx).Debug().Msgf("metadata saved in Redis: %s:%v", key, r.redactor.Body(data))
 	return nil
 }
 
 // Get gets the data from redis
-func (r *Repository) Get(ctx context.Context, key string) (string, error) {
+func (r *Repository) Get(ctx context.Context, key string) (_ string, err error) {
+	ctx, done := r.instrument(ctx, "GET")
+	defer func() { done(err) }()
+
 	data, err := r.client.Get(ctx, key).Result()
+	if errors.Is(err, ErrNotFound) {
+		log.Ctx(ctx).Debug().Msgf("metadata not found in Redis: %s", key)
+		return "", err
+	}
 	if err != nil {
 		log.Ctx(ctx).Error().Err(err).Msg("failed to fetch metadata")
 		return "", err
 	}
-	log.Ctx(ctx).Debug().Msgf("metadata retrieve from Redis: %s:%v", key, data)
+	log.Ctx(ctx).Debug().Msgf("metadata retrieve from Redis: %s:%v", key, r.redactor.Body([]byte(data)))
 	return data, nil
 }
+
+// Delete deletes the data from redis
+func (r *Repository) Delete(ctx context.Context, key string) (err error) {
+	ctx, done := r.instrument(ctx, "DEL")
+	defer func() { done(err) }()
+
+	if err = r.client.Del(ctx, key).Err(); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to delete metadata")
+		return err
+	}
+	log.Ctx(ctx).Debug().Msgf("metadata deleted from Redis: %s", key)
+	return nil
+}
+
+// Increment increments the counter of the key and returns its new value.
+// The expiration is set atomically when the counter is created.
+func (r *Repository) Increment(ctx context.Context, key string, expiration time.Duration) (_ int64, err error) {
+	ctx, done := r.instrument(ctx, "INCR")
+	defer func() { done(err) }()
+
+	count, err := incrementScript.Run(ctx, r.client, []string{key}, expiration.Milliseconds()).Int64()
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to increment counter")
+		return 0, err
+	}
+	log.Ctx(ctx).Debug().Msgf("counter incremented in Redis: %s:%d", key, count)
+	return count, nil
+}
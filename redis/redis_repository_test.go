@@ -67,13 +67,77 @@ func TestRedisRepository_GetKeyNotFound(t *testing.T) {
 	mock.ExpectGet("missing").RedisNil()
 
 	result, err := repo.Get(ctx, "missing")
-	assert.ErrorIs(t, err, redis.Nil)
+	assert.ErrorIs(t, err, ErrNotFound)
 	assert.Equal(t, "", result)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestNewRepositoryWithClient(t *testing.T) {
+	db, _ := redismock.NewClientMock()
+	repo := NewRepositoryWithClient(db)
+	assert.Equal(t, db, repo.client)
+}
+
 func TestNewRedisRepository(t *testing.T) {
 	repo := NewRepository()
 	assert.NotNil(t, repo)
 	assert.NotNil(t, repo.client)
 }
+
+func TestRedisRepository_Increment(t *testing.T) {
+	ctx := context.Background()
+	db, mock := redismock.NewClientMock()
+	repo := &Repository{client: db}
+
+	// the expiration is set by the script when the counter is created
+	mock.ExpectEvalSha(incrementScript.Hash(), []string{"counter"}, int64(1000)).SetVal(int64(1))
+	mock.ExpectEvalSha(incrementScript.Hash(), []string{"counter"}, int64(1000)).SetVal(int64(2))
+
+	count, err := repo.Increment(ctx, "counter", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	count, err = repo.Increment(ctx, "counter", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRedisRepository_IncrementRedisError(t *testing.T) {
+	ctx := context.Background()
+	db, mock := redismock.NewClientMock()
+	repo := &Repository{client: db}
+
+	mock.ExpectEvalSha(incrementScript.Hash(), []string{"counter"}, int64(1000)).SetErr(redis.ErrClosed)
+
+	count, err := repo.Increment(ctx, "counter", time.Second)
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRedisRepository_SaveWithExpiration(t *testing.T) {
+	ctx := context.Background()
+	db, mock := redismock.NewClientMock()
+	repo := &Repository{client: db}
+
+	data := []byte("hola")
+	mock.ExpectSet("key", data, time.Minute).SetVal("OK")
+
+	err := repo.SaveWithExpiration(ctx, "key", data, time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRedisRepository_Delete(t *testing.T) {
+	ctx := context.Background()
+	db, mock := redismock.NewClientMock()
+	repo := &Repository{client: db}
+
+	mock.ExpectDel("key").SetVal(1)
+	assert.NoError(t, repo.Delete(ctx, "key"))
+
+	mock.ExpectDel("key").SetErr(redis.ErrClosed)
+	assert.Error(t, repo.Delete(ctx, "key"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
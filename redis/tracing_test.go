@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v9"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	original := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(original) })
+	return exporter
+}
+
+func TestRedisRepository_Tracing(t *testing.T) {
+	exporter := setupTracing(t)
+	ctx := context.Background()
+	db, mock := redismock.NewClientMock()
+	repo := &Repository{client: db}
+
+	mock.ExpectSet("key", []byte("value"), 24*time.Hour).SetVal("OK")
+	mock.ExpectGet("missing").RedisNil()
+	mock.ExpectDel("key").SetErr(errors.New("redis error"))
+	mock.ExpectEvalSha(incrementScript.Hash(), []string{"counter"}, int64(60000)).SetVal(int64(1))
+
+	assert.NoError(t, repo.Save(ctx, "key", []byte("value")))
+	_, err := repo.Get(ctx, "missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Error(t, repo.Delete(ctx, "key"))
+	_, err = repo.Increment(ctx, "counter", time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 4)
+	for i, name := range []string{"SET", "GET", "DEL", "INCR"} {
+		assert.Equal(t, name, spans[i].Name)
+		assert.Equal(t, trace.SpanKindClient, spans[i].SpanKind)
+		assert.Contains(t, spans[i].Attributes, attribute.String("db.system", "redis"))
+		assert.Contains(t, spans[i].Attributes, attribute.String("db.operation.name", name))
+	}
+	assert.Equal(t, codes.Unset, spans[0].Status.Code)
+	// a missing key isn't an error
+	assert.Equal(t, codes.Unset, spans[1].Status.Code)
+	assert.Equal(t, codes.Error, spans[2].Status.Code)
+	assert.Equal(t, codes.Unset, spans[3].Status.Code)
+}
@@ -0,0 +1,50 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans created by anysher.
+const instrumentationName = "github.com/narumayase/anysher"
+
+// Tracer returns the tracer of the global TracerProvider.
+// Tracing is opt-in: spans are only recorded once the application registers a TracerProvider
+// with otel.SetTracerProvider, and trace context is only propagated once it registers a
+// propagator with otel.SetTextMapPropagator.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// End records the error, if any, in the span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// ContextWithLogger adds the trace and span IDs of the span in the context to its zerolog logger,
+// so any log written with log.Ctx(ctx) includes "trace_id" and "span_id".
+func ContextWithLogger(ctx context.Context) context.Context {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return ctx
+	}
+	logger := log.Ctx(ctx)
+	if logger.GetLevel() == zerolog.Disabled {
+		// there's no logger in the context yet
+		logger = &log.Logger
+	}
+	withIDs := logger.With().
+		Str("trace_id", spanContext.TraceID().String()).
+		Str("span_id", spanContext.SpanID().String()).
+		Logger()
+	return withIDs.WithContext(ctx)
+}
@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestEnd(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	original := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(original)
+
+	_, span := Tracer().Start(context.Background(), "failed")
+	End(span, assert.AnError)
+	_, span = Tracer().Start(context.Background(), "succeeded")
+	End(span, nil)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Len(t, spans[0].Events, 1)
+	assert.Equal(t, codes.Unset, spans[1].Status.Code)
+}
+
+func TestContextWithLogger(t *testing.T) {
+	provider := sdktrace.NewTracerProvider()
+	ctx, span := provider.Tracer("test").Start(context.Background(), "span")
+	defer span.End()
+
+	var logs bytes.Buffer
+	ctx = zerolog.New(&logs).WithContext(ctx)
+	ctx = ContextWithLogger(ctx)
+	log.Ctx(ctx).Info().Msg("traced")
+
+	assert.Contains(t, logs.String(), `"trace_id":"`+span.SpanContext().TraceID().String()+`"`)
+	assert.Contains(t, logs.String(), `"span_id":"`+span.SpanContext().SpanID().String()+`"`)
+}
+
+func TestContextWithLogger_WithoutSpan(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, ctx, ContextWithLogger(ctx))
+}